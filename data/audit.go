@@ -0,0 +1,218 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// The actions recorded in the audit log
+const (
+	AuditInsert  = "insert"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// redacted replaces the values of secret columns in the audit log
+const redacted = "[redacted]"
+
+// redactedColumns are never written to the audit log in the clear
+var redactedColumns = map[string]bool{
+	"password":       true,
+	"token_hash":     true,
+	"remember_token": true,
+}
+
+// AuditEvent is the type for a row in the audit_events table
+type AuditEvent struct {
+	ID             int       `db:"id,omitempty" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organization_id"`
+	ActorID        int       `db:"actor_id" json:"actor_id"`
+	ActorVia       string    `db:"actor_via" json:"actor_via"`
+	ActorIP        string    `db:"actor_ip" json:"actor_ip"`
+	Action         string    `db:"action" json:"action"`
+	Entity         string    `db:"entity" json:"entity"`
+	EntityID       int       `db:"entity_id" json:"entity_id"`
+	Changes        string    `db:"changes" json:"changes"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// Change is the value of a column before and after a change. Before is nil for an insert and
+// After is nil when the record was removed.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Diff decodes the changed columns of the event
+func (e *AuditEvent) Diff() (map[string]Change, error) {
+	diff := make(map[string]Change)
+	if err := json.Unmarshal([]byte(e.Changes), &diff); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+// NewAuditEvent returns the event recording that actor changed the entity with the given id
+// from before to after, which are records such as User or nil. Only the columns that differ
+// are kept, and secret columns such as password are redacted.
+func NewAuditEvent(actor Actor, action, entity string, entityID int, before, after any) (AuditEvent, error) {
+	if actor.Via == "" {
+		actor.Via = ActorSystem
+	}
+
+	changes, err := json.Marshal(diffColumns(before, after))
+	if err != nil {
+		return AuditEvent{}, err
+	}
+
+	return AuditEvent{
+		ActorID:   actor.UserID,
+		ActorVia:  actor.Via,
+		ActorIP:   actor.IP,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Changes:   string(changes),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// diffColumns compares the db columns of two records of the same type, either of which may be nil
+func diffColumns(before, after any) map[string]Change {
+	from, to := columns(before), columns(after)
+	diff := make(map[string]Change)
+
+	for name, value := range to {
+		if previous, ok := from[name]; ok && sameValue(previous, value) {
+			continue
+		}
+		diff[name] = Change{Before: redact(name, from[name]), After: redact(name, value)}
+	}
+
+	for name, value := range from {
+		if _, ok := to[name]; !ok {
+			diff[name] = Change{Before: redact(name, value)}
+		}
+	}
+
+	return diff
+}
+
+// columns returns the values of the db tagged fields of record, keyed by column name
+func columns(record any) map[string]any {
+	values := make(map[string]any)
+
+	v := reflect.ValueOf(record)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return values
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return values
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("db"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				values[name] = nil
+				continue
+			}
+			field = field.Elem()
+		}
+
+		values[name] = field.Interface()
+	}
+
+	return values
+}
+
+func sameValue(a, b any) bool {
+	switch a := a.(type) {
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func redact(name string, value any) any {
+	if value == nil || !redactedColumns[name] {
+		return value
+	}
+
+	return redacted
+}
+
+// AuditFields are the fields audit event listings may be sorted and filtered by
+var AuditFields = Fields[*AuditEvent]{
+	"id":         {Column: "id", Kind: IntField, Sortable: true, Value: func(e *AuditEvent) any { return e.ID }},
+	"actor_id":   {Column: "actor_id", Kind: IntField, Filterable: true, Value: func(e *AuditEvent) any { return e.ActorID }},
+	"action":     {Column: "action", Kind: StringField, Filterable: true, Value: func(e *AuditEvent) any { return e.Action }},
+	"entity":     {Column: "entity", Kind: StringField, Filterable: true, Value: func(e *AuditEvent) any { return e.Entity }},
+	"entity_id":  {Column: "entity_id", Kind: IntField, Filterable: true, Value: func(e *AuditEvent) any { return e.EntityID }},
+	"created_at": {Column: "created_at", Kind: TimeField, Sortable: true, Value: func(e *AuditEvent) any { return e.CreatedAt }},
+}
+
+// auditEventModel is the SQL implementation of AuditEventRepository
+type auditEventModel struct {
+	sqlSession
+}
+
+func (m *auditEventModel) Table() string {
+	return "audit_events"
+}
+
+func (m *auditEventModel) List(opts ListOptions) (*Page[*AuditEvent], error) {
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	return listCollection(collection, m.inTenant(), AuditFields, opts, "-id")
+}
+
+// inTenant limits a query on the audit_events table to the tenant of the session, if there is one
+func (s sqlSession) inTenant() up.LogicalExpr {
+	if s.tenant == 0 {
+		return up.Cond{}
+	}
+
+	return up.Cond{"organization_id =": s.tenant}
+}
+
+// record stores an audit event for a change made through s within its tenant
+func (s sqlSession) record(action, entity string, entityID int, before, after any) error {
+	event, err := NewAuditEvent(s.actor, action, entity, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	event.OrganizationID = s.tenant
+
+	collection, err := s.writeCollection("audit_events")
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.Insert(event)
+	return err
+}
@@ -0,0 +1,94 @@
+package data
+
+import "context"
+
+type contextKey string
+
+const (
+	primaryReadsKey contextKey = "primaryReads"
+	actorKey        contextKey = "actor"
+	tenantKey       contextKey = "tenant"
+	userKey         contextKey = "user"
+	scopesKey       contextKey = "scopes"
+)
+
+// WithPrimaryReads marks ctx so that models returned by Models.WithContext read from the primary
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey, true)
+}
+
+// PrimaryReads reports whether ctx was marked by WithPrimaryReads
+func PrimaryReads(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey).(bool)
+	return primary
+}
+
+// Actor is whoever changes a record, as recorded in the audit log
+type Actor struct {
+	// UserID is the id of the user making the change, or 0 when nobody is logged in
+	UserID int
+	// Via is how the actor was identified: ActorSession, ActorToken, ActorAnonymous or ActorSystem
+	Via string
+	// IP is the address of the client making the request, if there is one
+	IP string
+}
+
+// The ways an actor can be identified
+const (
+	ActorSession   = "session"
+	ActorToken     = "token"
+	ActorAnonymous = "anonymous"
+	ActorSystem    = "system"
+)
+
+// WithActor returns a copy of ctx carrying actor, which models returned by Models.WithContext
+// record as the author of their changes
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFrom returns the actor stored in ctx by WithActor
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey).(Actor)
+	return actor, ok
+}
+
+// NoOrganization is the tenant of a request by a user who belongs to no organization. It is
+// not the id of any, so models scoped to it see no users, tokens, roles or audit events.
+const NoOrganization = -1
+
+// WithTenant returns a copy of ctx scoped to the organization with the given id. Models
+// returned by Models.WithContext for it only see the users of that organization, their
+// tokens and the audit events recorded within it.
+func WithTenant(ctx context.Context, organizationID int) context.Context {
+	return context.WithValue(ctx, tenantKey, organizationID)
+}
+
+// TenantFrom returns the id of the organization ctx was scoped to by WithTenant
+func TenantFrom(ctx context.Context) (int, bool) {
+	organizationID, ok := ctx.Value(tenantKey).(int)
+	return organizationID, ok && organizationID != 0
+}
+
+// WithUser returns a copy of ctx carrying the logged in user
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey, u)
+}
+
+// UserFrom returns the logged in user stored in ctx by WithUser
+func UserFrom(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(userKey).(*User)
+	return u, ok && u != nil
+}
+
+// WithScopes returns a copy of ctx carrying the scopes of the API token the request was made with
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// ScopesFrom returns the token scopes stored in ctx by WithScopes. A request made with a token
+// without scopes has an empty list, which allows everything.
+func ScopesFrom(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesKey).([]string)
+	return scopes, ok
+}
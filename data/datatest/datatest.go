@@ -0,0 +1,1831 @@
+// Package datatest holds the behavioral test suite every implementation of the data
+// repositories must pass, so the SQL models and the in-memory fakes stay interchangeable.
+package datatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/data"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Password is the password of the users stored by InsertUser
+const Password = "kettle-harbor-quilt-42"
+
+// newPassword is what the tests reset the password of a user to
+const newPassword = "lantern-meadow-cobalt-7"
+
+// NewModels returns models backed by a fresh, empty store for the given test
+type NewModels func(t *testing.T) data.Models
+
+// Run runs the whole suite, creating new models for every subtest
+func Run(t *testing.T, newModels NewModels) {
+	t.Run("Tables", func(t *testing.T) { testTables(t, newModels(t)) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, newModels(t)) })
+	t.Run("UsersGetAll", func(t *testing.T) { testUsersGetAll(t, newModels(t)) })
+	t.Run("UsersDuplicateEmail", func(t *testing.T) { testUsersDuplicateEmail(t, newModels(t)) })
+	t.Run("UsersStaleUpdate", func(t *testing.T) { testUsersStaleUpdate(t, newModels(t)) })
+	t.Run("UsersResetPassword", func(t *testing.T) { testUsersResetPassword(t, newModels(t)) })
+	t.Run("UsersPasswordPolicy", func(t *testing.T) { testUsersPasswordPolicy(t, newModels(t)) })
+	t.Run("UsersRehash", func(t *testing.T) { testUsersRehash(t, newModels(t)) })
+	t.Run("UsersSoftDelete", func(t *testing.T) { testUsersSoftDelete(t, newModels(t)) })
+	t.Run("UsersPurge", func(t *testing.T) { testUsersPurge(t, newModels(t)) })
+	t.Run("UsersDeleteCascades", func(t *testing.T) { testUsersDeleteCascades(t, newModels(t)) })
+	t.Run("Tokens", func(t *testing.T) { testTokens(t, newModels(t)) })
+	t.Run("TokensExpired", func(t *testing.T) { testTokensExpired(t, newModels(t)) })
+	t.Run("TokensForUser", func(t *testing.T) { testTokensForUser(t, newModels(t)) })
+	t.Run("TokensScopes", func(t *testing.T) { testTokensScopes(t, newModels(t)) })
+	t.Run("UsersList", func(t *testing.T) { testUsersList(t, newModels(t)) })
+	t.Run("TokensList", func(t *testing.T) { testTokensList(t, newModels(t)) })
+	t.Run("RememberTokens", func(t *testing.T) { testRememberTokens(t, newModels(t)) })
+	t.Run("RememberTokensConcurrentRotate", func(t *testing.T) { testRememberTokensConcurrentRotate(t, newModels(t)) })
+	t.Run("EmailVerifications", func(t *testing.T) { testEmailVerifications(t, newModels(t)) })
+	t.Run("UserIdentities", func(t *testing.T) { testUserIdentities(t, newModels(t)) })
+	t.Run("WithTx", func(t *testing.T) { testWithTx(t, newModels(t)) })
+	t.Run("WithTxRollback", func(t *testing.T) { testWithTxRollback(t, newModels(t)) })
+	t.Run("WithTxPanic", func(t *testing.T) { testWithTxPanic(t, newModels(t)) })
+	t.Run("WithTxNested", func(t *testing.T) { testWithTxNested(t, newModels(t)) })
+	t.Run("AuditEvents", func(t *testing.T) { testAuditEvents(t, newModels(t)) })
+	t.Run("AuditEventsRollback", func(t *testing.T) { testAuditEventsRollback(t, newModels(t)) })
+	t.Run("Organizations", func(t *testing.T) { testOrganizations(t, newModels(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, newModels(t)) })
+	t.Run("NoOrganization", func(t *testing.T) { testNoOrganization(t, newModels(t)) })
+	t.Run("Roles", func(t *testing.T) { testRoles(t, newModels(t)) })
+	t.Run("RolesPerOrganization", func(t *testing.T) { testRolesPerOrganization(t, newModels(t)) })
+}
+
+// InsertUser stores a user with the given email and Password
+func InsertUser(t *testing.T, m data.Models, email string) *data.User {
+	t.Helper()
+
+	id, err := m.Users.Insert(data.User{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     email,
+		Active:    1,
+		Password:  Password,
+	})
+	if err != nil {
+		t.Fatal("failed to insert new user record:", err)
+	}
+
+	u, err := m.Users.Get(id)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+
+	return u
+}
+
+// InsertToken stores a token for the user that expires after ttl and returns it with its plain text
+func InsertToken(t *testing.T, m data.Models, u *data.User, ttl time.Duration) *data.Token {
+	t.Helper()
+
+	token, err := m.Tokens.GenerateToken(u.ID, ttl)
+	if err != nil {
+		t.Fatal("error generating token:", err)
+	}
+
+	if err := m.Tokens.Insert(*token, *u); err != nil {
+		t.Fatal("error inserting token:", err)
+	}
+
+	return token
+}
+
+// InsertOrganization stores an organization with the given slug
+func InsertOrganization(t *testing.T, m data.Models, slug string) *data.Organization {
+	t.Helper()
+
+	id, err := m.Organizations.Insert(data.Organization{Name: slug, Slug: slug})
+	if err != nil {
+		t.Fatal("failed to insert organization:", err)
+	}
+
+	org, err := m.Organizations.Get(id)
+	if err != nil {
+		t.Fatal("failed to get organization:", err)
+	}
+
+	return org
+}
+
+// InTenant returns the models scoped to the organization
+func InTenant(m data.Models, org *data.Organization) data.Models {
+	return m.WithContext(data.WithTenant(context.Background(), org.ID))
+}
+
+func bearerRequest(plainText string) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Add("Authorization", "Bearer "+plainText)
+	return req
+}
+
+func testTables(t *testing.T, m data.Models) {
+	if s := m.Users.Table(); s != "users" {
+		t.Error("wrong table name returned for users:", s)
+	}
+	if s := m.Tokens.Table(); s != "tokens" {
+		t.Error("wrong table name returned for tokens:", s)
+	}
+	if s := m.RememberTokens.Table(); s != "remember_tokens" {
+		t.Error("wrong table name returned for remember tokens:", s)
+	}
+	if s := m.AuditEvents.Table(); s != "audit_events" {
+		t.Error("wrong table name returned for audit events:", s)
+	}
+	if s := m.Organizations.Table(); s != "organizations" {
+		t.Error("wrong table name returned for organizations:", s)
+	}
+	if s := m.Roles.Table(); s != "roles" {
+		t.Error("wrong table name returned for roles:", s)
+	}
+	if s := m.EmailVerifications.Table(); s != "email_verifications" {
+		t.Error("wrong table name returned for email verifications:", s)
+	}
+	if s := m.UserIdentities.Table(); s != "user_identities" {
+		t.Error("wrong table name returned for user identities:", s)
+	}
+}
+
+func testUsers(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	if u.ID == 0 {
+		t.Error("0 returned as id after insert")
+	}
+
+	if u.Password == Password {
+		t.Error("password stored in plain text")
+	}
+
+	if u.CreatedAt.IsZero() || u.UpdatedAt.IsZero() {
+		t.Error("timestamps were not stored")
+	}
+
+	if ok, err := u.PasswordMatches(Password); !ok || err != nil {
+		t.Error("password does not match, expected a match", err)
+	}
+
+	byEmail, err := m.Users.GetByEmail(u.Email)
+	if err != nil || byEmail.ID != u.ID {
+		t.Error("failed to get user by email:", err)
+	}
+
+	u.LastName = "Test"
+	if err := m.Users.Update(*u); err != nil {
+		t.Error("failed to update user:", err)
+	}
+
+	if u, _ = m.Users.Get(u.ID); u.LastName != "Test" {
+		t.Error("last name failed to update")
+	}
+
+	if _, err := m.Users.Get(u.ID + 100); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting non-existent user, expected ErrNotFound, got", err)
+	}
+
+	if _, err := m.Users.GetByEmail("not.exist@test.com"); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting non-existent email, expected ErrNotFound, got", err)
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Error("failed to delete user:", err)
+	}
+
+	if _, err := m.Users.Get(u.ID); err == nil {
+		t.Error("trying to retrieve record of deleted user, expected error, received none")
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Error("deleting non-existent user, expected no error, got", err)
+	}
+}
+
+func testUsersGetAll(t *testing.T, m data.Models) {
+	all, err := m.Users.GetAll()
+	if err != nil || len(all) != 0 {
+		t.Errorf("expected no users in a new store, got %d: %v", len(all), err)
+	}
+
+	for _, lastName := range []string{"Young", "Adams", "Miller"} {
+		if _, err := m.Users.Insert(data.User{FirstName: "Test", LastName: lastName, Email: lastName + "@test.com", Password: Password}); err != nil {
+			t.Fatal("failed to insert new user record:", err)
+		}
+	}
+
+	all, err = m.Users.GetAll()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected 3 users, got %d: %v", len(all), err)
+	}
+
+	if all[0].LastName != "Adams" || all[1].LastName != "Miller" || all[2].LastName != "Young" {
+		t.Error("users not ordered by last name")
+	}
+}
+
+func testUsersDuplicateEmail(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	_, err := m.Users.Insert(data.User{FirstName: "Jane", LastName: "Doe", Email: u.Email, Password: Password})
+	if !errors.Is(err, data.ErrDuplicateEmail) {
+		t.Error("inserting user with duplicate email, expected ErrDuplicateEmail, got", err)
+	}
+
+	// the address of a soft deleted user stays taken, so restoring them cannot clash
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("error deleting user:", err)
+	}
+	_, err = m.Users.Insert(data.User{FirstName: "Jane", LastName: "Doe", Email: u.Email, Password: Password})
+	if !errors.Is(err, data.ErrDuplicateEmail) {
+		t.Error("inserting user with the email of a soft deleted user, expected ErrDuplicateEmail, got", err)
+	}
+	if err := m.Users.Restore(u.ID); err != nil {
+		t.Error("error restoring user:", err)
+	}
+}
+
+func testUsersStaleUpdate(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	if u.Version != 1 {
+		t.Error("new user does not start at version 1, got", u.Version)
+	}
+
+	first, _ := m.Users.Get(u.ID)
+	second, _ := m.Users.Get(u.ID)
+
+	first.LastName = "First"
+	if err := m.Users.Update(*first); err != nil {
+		t.Fatal("failed to update user:", err)
+	}
+
+	second.LastName = "Second"
+	if err := m.Users.Update(*second); !errors.Is(err, data.ErrStaleRecord) {
+		t.Error("updating user changed since it was loaded, expected ErrStaleRecord, got", err)
+	}
+
+	u, _ = m.Users.Get(u.ID)
+	if u.LastName != "First" || u.Version != 2 {
+		t.Errorf("expected the first update to stick at version 2, got %q at version %d", u.LastName, u.Version)
+	}
+
+	u.LastName = "Reloaded"
+	if err := u.Update(*u); err != nil {
+		t.Error("updating reloaded user through the user failed:", err)
+	}
+	if err := (&data.User{}).Update(*u); err == nil {
+		t.Error("updated a user through one that was not loaded from a repository")
+	}
+	u, _ = m.Users.Get(u.ID)
+
+	if err := m.Users.ResetPassword(u.ID, newPassword); err != nil {
+		t.Fatal("failed to reset password:", err)
+	}
+
+	u.LastName = "Stale"
+	if err := m.Users.Update(*u); !errors.Is(err, data.ErrStaleRecord) {
+		t.Error("updating user loaded before a password reset, expected ErrStaleRecord, got", err)
+	}
+
+	missing := *u
+	missing.ID += 100
+	if err := m.Users.Update(missing); !errors.Is(err, data.ErrNotFound) {
+		t.Error("updating non-existent user, expected ErrNotFound, got", err)
+	}
+}
+
+func testUsersResetPassword(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	remember, err := m.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Fatal("error issuing remember token:", err)
+	}
+
+	if err := m.Users.ResetPassword(u.ID, newPassword); err != nil {
+		t.Fatal("failed to reset password:", err)
+	}
+
+	u, _ = m.Users.Get(u.ID)
+	if ok, _ := u.PasswordMatches(newPassword); !ok {
+		t.Error("new password does not match after reset")
+	}
+	if ok, _ := u.PasswordMatches(Password); ok {
+		t.Error("old password still matches after reset")
+	}
+
+	if ok, _ := m.RememberTokens.Valid(u.ID, remember); ok {
+		t.Error("remember token still valid after password reset")
+	}
+
+	if err := m.Users.ResetPassword(u.ID+100, newPassword); err == nil {
+		t.Error("resetting password for non-existent user, expected an error, received none")
+	}
+}
+
+func testUsersPasswordPolicy(t *testing.T, m data.Models) {
+	var fieldErrors data.FieldErrors
+
+	_, err := m.Users.Insert(data.User{FirstName: "John", LastName: "Smith", Email: "john.smith@test.com", Password: "password"})
+	if !errors.As(err, &fieldErrors) || fieldErrors["password"] == "" {
+		t.Error("inserting a user with a common password, expected a password field error, got", err)
+	}
+
+	if all, _ := m.Users.GetAll(); len(all) != 0 {
+		t.Error("user with a rejected password was stored")
+	}
+
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	err = m.Users.ResetPassword(u.ID, "JohnSmith-2024!")
+	if !errors.As(err, &fieldErrors) || fieldErrors["password"] == "" {
+		t.Error("resetting to a password made of the name, expected a password field error, got", err)
+	}
+
+	if u, _ = m.Users.Get(u.ID); u != nil {
+		if ok, _ := u.PasswordMatches(Password); !ok {
+			t.Error("password changed by a rejected reset")
+		}
+	}
+
+	// a generated password nobody chose is stored as hashed, whatever it contains
+	hash, err := data.HashPassword("janedoe-x7k2")
+	if err != nil {
+		t.Fatal("error hashing password:", err)
+	}
+	id, err := m.Users.InsertWithHash(data.User{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@test.com", Active: 1, Password: hash})
+	if err != nil {
+		t.Fatal("inserting a user with a hashed password, expected no error, got", err)
+	}
+	if u, _ = m.Users.Get(id); u == nil || u.Password != hash {
+		t.Error("hash of a user inserted with one not stored as it was")
+	}
+	if _, err := m.Users.InsertWithHash(data.User{Email: "jane.doe@test.com", Password: hash}); !errors.Is(err, data.ErrDuplicateEmail) {
+		t.Error("inserting a user with a hashed password and a taken address, expected ErrDuplicateEmail, got", err)
+	}
+}
+
+func testUsersRehash(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	bcryptHash := u.Password
+
+	previous := data.PasswordHasher()
+	t.Cleanup(func() { data.SetPasswordHasher(previous) })
+	data.SetPasswordHasher(data.Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	if ok, _ := u.PasswordMatches("wrongpassword"); ok {
+		t.Error("used incorrect password, expected no match, got a match")
+	}
+	if u.Password != bcryptHash {
+		t.Error("hash upgraded after a failed match")
+	}
+
+	if ok, err := u.PasswordMatches(Password); !ok || err != nil {
+		t.Fatal("password does not match, expected a match", err)
+	}
+
+	upgraded, err := m.Users.Get(u.ID)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+	if !strings.HasPrefix(upgraded.Password, "$argon2id$") {
+		t.Errorf("expected the hash to be upgraded to argon2id, got %q", upgraded.Password)
+	}
+	if upgraded.Password != u.Password {
+		t.Error("upgraded hash not kept on the user")
+	}
+	if upgraded.Version != u.Version {
+		t.Errorf("expected version %d to be kept by the upgrade, got %d", u.Version, upgraded.Version)
+	}
+	if ok, _ := upgraded.PasswordMatches(Password); !ok {
+		t.Error("password does not match the upgraded hash")
+	}
+
+	if err := m.Users.UpdatePasswordHash(u.ID, bcryptHash, bcryptHash); !errors.Is(err, data.ErrStaleRecord) {
+		t.Error("replacing an outdated hash, expected ErrStaleRecord, got", err)
+	}
+}
+
+func testUsersSoftDelete(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	InsertUser(t, m, "jane.doe@test.com")
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("failed to delete user:", err)
+	}
+
+	if _, err := m.Users.Get(u.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting deleted user, expected ErrNotFound, got", err)
+	}
+
+	if _, err := m.Users.GetByEmail(u.Email); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting deleted user by email, expected ErrNotFound, got", err)
+	}
+
+	if all, _ := m.Users.GetAll(); len(all) != 1 {
+		t.Errorf("expected 1 user left, got %d", len(all))
+	}
+
+	if p, _ := m.Users.List(data.ListOptions{}); p.Total != 1 {
+		t.Errorf("expected 1 user listed, got %d", p.Total)
+	}
+
+	if err := m.Users.Update(*u); !errors.Is(err, data.ErrNotFound) {
+		t.Error("updating deleted user, expected ErrNotFound, got", err)
+	}
+
+	if err := m.Users.ResetPassword(u.ID, newPassword); err == nil {
+		t.Error("resetting password of deleted user, expected error, received none")
+	}
+
+	// a token issued after the deletion must not work either
+	token := InsertToken(t, m, u, time.Hour)
+	if _, err := m.Tokens.AuthenticateToken(bearerRequest(token.PlainText)); err == nil {
+		t.Error("authenticating as deleted user, expected error, received none")
+	}
+
+	deleted, err := m.Users.ListDeleted(data.ListOptions{})
+	if err != nil || deleted.Total != 1 || deleted.Items[0].ID != u.ID || !deleted.Items[0].Deleted() {
+		t.Fatalf("expected the deleted user to be listed as deleted, got %v: %v", deleted, err)
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Error("deleting deleted user, expected no error, got", err)
+	}
+
+	if err := m.Users.Restore(u.ID); err != nil {
+		t.Fatal("failed to restore user:", err)
+	}
+
+	restored, err := m.Users.Get(u.ID)
+	if err != nil || restored.Deleted() {
+		t.Fatal("restored user not found:", err)
+	}
+
+	if ok, _ := restored.PasswordMatches(Password); !ok {
+		t.Error("password of restored user does not match")
+	}
+
+	if err := m.Users.Update(*restored); err != nil {
+		t.Error("failed to update restored user:", err)
+	}
+
+	if err := m.Users.Restore(u.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("restoring user that is not deleted, expected ErrNotFound, got", err)
+	}
+
+	if deleted, _ := m.Users.ListDeleted(data.ListOptions{}); deleted.Total != 0 {
+		t.Error("restored user still listed as deleted")
+	}
+}
+
+func testUsersPurge(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	kept := InsertUser(t, m, "jane.doe@test.com")
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("failed to delete user:", err)
+	}
+
+	if purged, err := m.Users.Purge(time.Hour); err != nil || purged != 0 {
+		t.Errorf("purging users deleted over an hour ago removed %d: %v", purged, err)
+	}
+
+	if err := m.Users.Restore(u.ID); err != nil {
+		t.Fatal("user deleted within the retention period could not be restored:", err)
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("failed to delete user:", err)
+	}
+
+	if purged, err := m.Users.Purge(0); err != nil || purged != 1 {
+		t.Errorf("expected 1 user purged, got %d: %v", purged, err)
+	}
+
+	if err := m.Users.Restore(u.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("restoring purged user, expected ErrNotFound, got", err)
+	}
+
+	if _, err := m.Users.Get(kept.ID); err != nil {
+		t.Error("purge removed a user that was not deleted:", err)
+	}
+}
+
+func testUsersDeleteCascades(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	token := InsertToken(t, m, u, time.Hour)
+
+	remember, err := m.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Fatal("error issuing remember token:", err)
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("failed to delete user:", err)
+	}
+
+	if _, err := m.Tokens.AuthenticateToken(bearerRequest(token.PlainText)); err == nil {
+		t.Error("using token from deleted user, expected error, received none")
+	}
+
+	if tokens, _ := m.Tokens.GetTokensForUser(u.ID); len(tokens) != 0 {
+		t.Error("tokens of deleted user were not removed")
+	}
+
+	if ok, _ := m.RememberTokens.Valid(u.ID, remember); ok {
+		t.Error("remember token of deleted user still valid")
+	}
+}
+
+func testTokens(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	token := InsertToken(t, m, u, time.Hour)
+
+	if len(token.PlainText) != 26 {
+		t.Error("generated token has wrong length:", len(token.PlainText))
+	}
+
+	u, _ = m.Users.Get(u.ID)
+	if u.Token.ID == 0 {
+		t.Fatal("unexpired token not attached to user")
+	}
+
+	stored, err := m.Tokens.Get(u.Token.ID)
+	if err != nil {
+		t.Fatal("failed to get token by id:", err)
+	}
+
+	if stored.PlainText != "" {
+		t.Error("plain text of token was stored")
+	}
+	if stored.Email != u.Email || stored.FirstName != u.FirstName {
+		t.Error("token not stamped with user's email and first name")
+	}
+
+	if _, err := m.Tokens.Get(0); err == nil {
+		t.Error("used invalid id, expected an error, received none")
+	}
+
+	if _, err := m.Tokens.GetByToken(token.PlainText); err != nil {
+		t.Error("failed to get token data by token:", err)
+	}
+
+	if _, err := m.Tokens.GetByToken("ABCDEFGHIJKLMNOPQRSTUVWXYZ"); !errors.Is(err, data.ErrInvalidToken) {
+		t.Error("getting unknown token, expected ErrInvalidToken, got", err)
+	}
+
+	owner, err := m.Tokens.GetUserForToken(token.PlainText)
+	if err != nil || owner.ID != u.ID {
+		t.Error("using a valid token to search for a user, error received:", err)
+	}
+
+	authenticated, err := m.Tokens.AuthenticateToken(bearerRequest(token.PlainText))
+	if err != nil || authenticated.ID != u.ID {
+		t.Error("valid token reported as invalid:", err)
+	}
+
+	if ok, err := m.Tokens.ValidToken(token.PlainText); !ok || err != nil {
+		t.Error("using valid token, failed validation, expected to pass", err)
+	}
+
+	if ok, err := m.Tokens.ValidToken("invalidtoken"); ok || err == nil {
+		t.Error("using invalid token, passed validation, expected to fail")
+	}
+
+	if err := m.Tokens.DeleteByToken(token.PlainText); err != nil {
+		t.Error("error deleting token:", err)
+	}
+
+	if ok, _ := m.Tokens.ValidToken(token.PlainText); ok {
+		t.Error("using deleted token, passed validation, expected to fail")
+	}
+
+	if err := m.Tokens.DeleteByToken("invalidtoken"); err != nil {
+		t.Error("deleting non-existent token, expected no error, got", err)
+	}
+
+	other := InsertToken(t, m, u, time.Hour)
+	otherStored, err := m.Tokens.GetByToken(other.PlainText)
+	if err != nil {
+		t.Fatal("failed to get token data by token:", err)
+	}
+	if err := m.Tokens.Delete(otherStored.ID); err != nil {
+		t.Error("failed to delete token:", err)
+	}
+	if ok, _ := m.Tokens.ValidToken(other.PlainText); ok {
+		t.Error("using token deleted by id, passed validation, expected to fail")
+	}
+
+	orphan, _ := m.Tokens.GenerateToken(u.ID+100, time.Hour)
+	if err := m.Tokens.Insert(*orphan, data.User{}); err == nil {
+		t.Error("inserting token for non-existent user, expected error, received none")
+	}
+}
+
+func testTokensExpired(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	token := InsertToken(t, m, u, -time.Hour)
+
+	if ok, err := m.Tokens.ValidToken(token.PlainText); ok || !errors.Is(err, data.ErrExpiredToken) {
+		t.Error("using expired token, expected ErrExpiredToken, got", err)
+	}
+
+	if _, err := m.Tokens.AuthenticateToken(bearerRequest(token.PlainText)); !errors.Is(err, data.ErrExpiredToken) {
+		t.Error("authenticating with expired token, expected ErrExpiredToken, got", err)
+	}
+
+	if u, _ = m.Users.Get(u.ID); u.Token.ID != 0 {
+		t.Error("expired token attached to user")
+	}
+}
+
+func testTokensScopes(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	token, err := m.Tokens.GenerateToken(u.ID, time.Hour)
+	if err != nil {
+		t.Fatal("error generating token:", err)
+	}
+	token.Scopes = "users:read tokens:write"
+	if err := m.Tokens.Insert(*token, *u); err != nil {
+		t.Fatal("error inserting token:", err)
+	}
+
+	owner, err := m.Tokens.AuthenticateToken(bearerRequest(token.PlainText))
+	if err != nil {
+		t.Fatal("valid token reported as invalid:", err)
+	}
+	if owner.Token.Scopes != "users:read tokens:write" {
+		t.Errorf("scopes not stored with the token, got %q", owner.Token.Scopes)
+	}
+
+	unscoped := InsertToken(t, m, u, time.Hour)
+	if owner, _ := m.Tokens.GetUserForToken(unscoped.PlainText); owner == nil || owner.Token.Scopes != "" {
+		t.Error("token inserted without scopes came back with some")
+	}
+}
+
+func testTokensForUser(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	other := InsertUser(t, m, "jane.doe@test.com")
+
+	InsertToken(t, m, u, time.Hour)
+	InsertToken(t, m, u, time.Hour)
+	InsertToken(t, m, other, time.Hour)
+
+	tokens, err := m.Tokens.GetTokensForUser(u.ID)
+	if err != nil {
+		t.Fatal("failed to get tokens for user:", err)
+	}
+
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(tokens))
+	}
+
+	for _, token := range tokens {
+		if token.UserID != u.ID {
+			t.Error("token of another user returned")
+		}
+	}
+
+	if tokens, _ = m.Tokens.GetTokensForUser(u.ID + 100); len(tokens) > 0 {
+		t.Error("tokens returned for non-existent user")
+	}
+}
+
+func testRememberTokens(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	token, err := m.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Fatal("error issuing remember token:", err)
+	}
+
+	if ok, err := m.RememberTokens.Valid(u.ID, token); !ok || err != nil {
+		t.Error("using valid remember token, failed validation, expected to pass", err)
+	}
+
+	if ok, _ := m.RememberTokens.Valid(u.ID+1, token); ok {
+		t.Error("using remember token of another user, passed validation, expected to fail")
+	}
+
+	newToken, err := m.RememberTokens.Rotate(u.ID, token)
+	if err != nil {
+		t.Fatal("error rotating remember token:", err)
+	}
+
+	if ok, _ := m.RememberTokens.Valid(u.ID, token); ok {
+		t.Error("using rotated remember token, passed validation, expected to fail")
+	}
+
+	if _, err := m.RememberTokens.Rotate(u.ID, token); err == nil {
+		t.Error("rotating an already rotated remember token, expected error, received none")
+	}
+
+	if err := m.RememberTokens.Delete(newToken); err != nil {
+		t.Error("error deleting remember token:", err)
+	}
+
+	if ok, _ := m.RememberTokens.Valid(u.ID, newToken); ok {
+		t.Error("using deleted remember token, passed validation, expected to fail")
+	}
+
+	first, _ := m.RememberTokens.Issue(u.ID)
+	second, _ := m.RememberTokens.Issue(u.ID)
+
+	if err := m.RememberTokens.DeleteForUser(u.ID); err != nil {
+		t.Error("error revoking remember tokens:", err)
+	}
+
+	for _, revoked := range []string{first, second} {
+		if ok, _ := m.RememberTokens.Valid(u.ID, revoked); ok {
+			t.Error("using revoked remember token, passed validation, expected to fail")
+		}
+	}
+
+	page, err := m.AuditEvents.List(data.ListOptions{PageSize: data.MaxPageSize, Sort: "id", Filters: map[string]string{"entity": "remember_tokens"}})
+	if err != nil {
+		t.Fatal("error listing audit events:", err)
+	}
+
+	var actions []string
+	for _, event := range page.Items {
+		actions = append(actions, event.Action)
+		if c := auditDiff(t, event)["remember_token"]; c.Before != nil && c.Before != "[redacted]" || c.After != nil && c.After != "[redacted]" {
+			t.Errorf("%s: expected remember_token to be redacted, got %+v", event.Action, c)
+		}
+	}
+
+	// issue, rotate, delete, two more issues and the revocation of both
+	expected := []string{
+		data.AuditInsert,
+		data.AuditDelete, data.AuditInsert,
+		data.AuditDelete,
+		data.AuditInsert, data.AuditInsert,
+		data.AuditDelete, data.AuditDelete,
+	}
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Errorf("expected remember token events %v, got %v", expected, actions)
+	}
+}
+
+func testEmailVerifications(t *testing.T, m data.Models) {
+	id, err := m.Users.Insert(data.User{FirstName: "John", LastName: "Smith", Email: "john.smith@test.com", Password: Password})
+	if err != nil {
+		t.Fatal("failed to insert new user record:", err)
+	}
+
+	token, err := m.EmailVerifications.Issue(id)
+	if err != nil {
+		t.Fatal("error issuing email verification:", err)
+	}
+
+	if _, err := m.EmailVerifications.Issue(id); !errors.Is(err, data.ErrThrottled) {
+		t.Error("issuing a second verification right away, expected ErrThrottled, got", err)
+	}
+
+	if _, err := m.EmailVerifications.Issue(id + 100); !errors.Is(err, data.ErrNotFound) {
+		t.Error("issuing a verification for a non-existent user, expected ErrNotFound, got", err)
+	}
+
+	if _, err := m.EmailVerifications.Verify("not-a-token"); !errors.Is(err, data.ErrInvalidToken) {
+		t.Error("verifying an unknown token, expected ErrInvalidToken, got", err)
+	}
+
+	if u, _ := m.Users.Get(id); u == nil || u.Active != 0 {
+		t.Fatal("new user is active before verifying their email")
+	}
+
+	verified, err := m.EmailVerifications.Verify(token)
+	if err != nil || verified != id {
+		t.Fatalf("failed to verify email, got user %d: %v", verified, err)
+	}
+
+	u, err := m.Users.Get(id)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+	if u.Active != 1 {
+		t.Error("user not activated by verifying their email")
+	}
+	if u.Version != 2 {
+		t.Errorf("expected activating to bump the version to 2, got %d", u.Version)
+	}
+
+	if _, err := m.EmailVerifications.Verify(token); !errors.Is(err, data.ErrInvalidToken) {
+		t.Error("verifying with a used token, expected ErrInvalidToken, got", err)
+	}
+
+	page, err := m.AuditEvents.List(data.ListOptions{Filters: map[string]string{"entity": "users", "action": data.AuditUpdate}})
+	if err != nil || len(page.Items) != 1 {
+		t.Fatal("expected one audit event for the activation, got", page, err)
+	}
+	if c := auditDiff(t, page.Items[0])["user_active"]; c.After != float64(1) {
+		t.Errorf("expected the activation in the audit event, got %v", c)
+	}
+
+	// a link sent for a later sign up gives the account the password of that sign up, and
+	// whoever signed up before loses any access they were given
+	squattedID, err := m.Users.Insert(data.User{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@test.com", Password: Password})
+	if err != nil {
+		t.Fatal("failed to insert new user record:", err)
+	}
+	squatted, err := m.Users.Get(squattedID)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+	InsertToken(t, m, squatted, time.Hour)
+	remember, err := m.RememberTokens.Issue(squatted.ID)
+	if err != nil {
+		t.Fatal("error issuing remember token:", err)
+	}
+
+	hash, err := data.HashPassword("harbor-violet-anchor-3")
+	if err != nil {
+		t.Fatal("error hashing password:", err)
+	}
+	token, err = m.EmailVerifications.IssueWithPassword(squatted.ID, hash)
+	if err != nil {
+		t.Fatal("error issuing email verification with a password:", err)
+	}
+	if _, err := m.EmailVerifications.Verify(token); err != nil {
+		t.Fatal("failed to verify email:", err)
+	}
+
+	u, err = m.Users.Get(squatted.ID)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+	if matches, _ := u.PasswordMatches("harbor-violet-anchor-3"); !matches || u.Active != 1 {
+		t.Error("user not activated with the password sent with the link")
+	}
+	if matches, _ := u.PasswordMatches(Password); matches {
+		t.Error("password from before the link still matches")
+	}
+	if tokens, _ := m.Tokens.GetTokensForUser(squatted.ID); len(tokens) != 0 {
+		t.Errorf("expected the tokens from before verifying to be revoked, got %d", len(tokens))
+	}
+	if valid, _ := m.RememberTokens.Valid(squatted.ID, remember); valid {
+		t.Error("remember token from before verifying still valid")
+	}
+}
+
+// lastNames returns the last names of the users on a page
+func lastNames(p *data.Page[*data.User]) []string {
+	names := make([]string, 0, len(p.Items))
+	for _, u := range p.Items {
+		names = append(names, u.LastName)
+	}
+	return names
+}
+
+func testUsersList(t *testing.T, m data.Models) {
+	for i, lastName := range []string{"Davis", "Adams", "Clark", "Evans", "Baker", "Clark"} {
+		u := data.User{FirstName: "Test", LastName: lastName, Email: fmt.Sprintf("user%d@test.com", i), Active: i % 2, Password: Password}
+		if _, err := m.Users.Insert(u); err != nil {
+			t.Fatal("failed to insert new user record:", err)
+		}
+	}
+
+	want := []string{"Adams", "Baker", "Clark", "Clark", "Davis", "Evans"}
+
+	var forward []string
+	var pages []*data.Page[*data.User]
+
+	opts := data.ListOptions{PageSize: 2}
+	for {
+		p, err := m.Users.List(opts)
+		if err != nil {
+			t.Fatal("failed to list users:", err)
+		}
+		if p.Total != 6 || p.PageSize != 2 {
+			t.Fatalf("expected total 6 and page size 2, got %d and %d", p.Total, p.PageSize)
+		}
+
+		forward = append(forward, lastNames(p)...)
+		pages = append(pages, p)
+
+		if p.NextCursor == "" || len(pages) > 5 {
+			break
+		}
+		opts.Cursor = p.NextCursor
+	}
+
+	if fmt.Sprint(forward) != fmt.Sprint(want) || len(pages) != 3 {
+		t.Errorf("paging forward returned %v in %d pages, expected %v in 3", forward, len(pages), want)
+	}
+
+	if pages[0].PrevCursor != "" {
+		t.Error("first page has a previous cursor")
+	}
+
+	back, err := m.Users.List(data.ListOptions{PageSize: 2, Cursor: pages[2].PrevCursor})
+	if err != nil {
+		t.Fatal("failed to list users:", err)
+	}
+	if fmt.Sprint(lastNames(back)) != fmt.Sprint(lastNames(pages[1])) || back.NextCursor == "" || back.PrevCursor == "" {
+		t.Errorf("paging back from the last page returned %v, expected %v with cursors both ways", lastNames(back), lastNames(pages[1]))
+	}
+
+	first, _ := m.Users.List(data.ListOptions{PageSize: 2, Cursor: back.PrevCursor})
+	if fmt.Sprint(lastNames(first)) != fmt.Sprint(want[:2]) || first.PrevCursor != "" {
+		t.Errorf("paging back to the first page returned %v, expected %v without a previous cursor", lastNames(first), want[:2])
+	}
+
+	second, err := m.Users.List(data.ListOptions{PageSize: 2, PageNumber: 2})
+	if err != nil || fmt.Sprint(lastNames(second)) != fmt.Sprint(want[2:4]) || second.PrevCursor == "" {
+		t.Errorf("second page by number returned %v, expected %v: %v", lastNames(second), want[2:4], err)
+	}
+
+	desc, _ := m.Users.List(data.ListOptions{Sort: "-last_name", PageSize: 3})
+	if fmt.Sprint(lastNames(desc)) != "[Evans Davis Clark]" {
+		t.Error("descending sort returned", lastNames(desc))
+	}
+
+	descNext, _ := m.Users.List(data.ListOptions{Sort: "-last_name", PageSize: 3, Cursor: desc.NextCursor})
+	if fmt.Sprint(lastNames(descNext)) != "[Clark Baker Adams]" {
+		t.Error("descending sort, second page returned", lastNames(descNext))
+	}
+
+	filtered, _ := m.Users.List(data.ListOptions{Filters: map[string]string{"last_name": "Clark"}})
+	if filtered.Total != 2 || len(filtered.Items) != 2 {
+		t.Errorf("filtering by last name returned %d of %d users, expected 2", len(filtered.Items), filtered.Total)
+	}
+
+	active, _ := m.Users.List(data.ListOptions{Filters: map[string]string{"active": "1"}})
+	if active.Total != 3 {
+		t.Errorf("filtering by active returned %d users, expected 3", active.Total)
+	}
+
+	if p, _ := m.Users.List(data.ListOptions{PageSize: 1000}); p.PageSize != data.MaxPageSize {
+		t.Error("page size not capped, got", p.PageSize)
+	}
+
+	if p, _ := m.Users.List(data.ListOptions{}); p.PageSize != data.DefaultPageSize || len(p.Items) != 6 {
+		t.Error("default page size not applied, got", p.PageSize)
+	}
+
+	for name, opts := range map[string]data.ListOptions{
+		"unknown sort":       {Sort: "password"},
+		"unknown filter":     {Filters: map[string]string{"password": "secret"}},
+		"bad filter value":   {Filters: map[string]string{"active": "yes"}},
+		"malformed cursor":   {Cursor: "not a cursor"},
+		"cursor of a sort":   {Sort: "email", Cursor: pages[0].NextCursor},
+		"sort not permitted": {Sort: "active"},
+	} {
+		if _, err := m.Users.List(opts); !errors.Is(err, data.ErrInvalidListOptions) {
+			t.Errorf("%s: expected ErrInvalidListOptions, got %v", name, err)
+		}
+	}
+}
+
+func testTokensList(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+	other := InsertUser(t, m, "jane.doe@test.com")
+
+	for _, ttl := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		InsertToken(t, m, u, ttl)
+	}
+	InsertToken(t, m, other, time.Hour)
+
+	all, err := m.Tokens.List(data.ListOptions{})
+	if err != nil || all.Total != 4 {
+		t.Fatalf("expected 4 tokens, got %v: %v", all, err)
+	}
+
+	for i := 1; i < len(all.Items); i++ {
+		prev, cur := all.Items[i-1], all.Items[i]
+		if prev.CreatedAt.Before(cur.CreatedAt) || (prev.CreatedAt.Equal(cur.CreatedAt) && prev.ID < cur.ID) {
+			t.Error("tokens not listed newest first")
+		}
+	}
+
+	owned, err := m.Tokens.List(data.ListOptions{Sort: "expiry", Filters: map[string]string{"user_id": fmt.Sprint(u.ID)}, PageSize: 2})
+	if err != nil || owned.Total != 3 || len(owned.Items) != 2 {
+		t.Fatalf("expected the first 2 of 3 tokens of the user, got %v: %v", owned, err)
+	}
+
+	if !owned.Items[0].Expires.Before(owned.Items[1].Expires) {
+		t.Error("tokens not sorted by expiry")
+	}
+
+	rest, err := m.Tokens.List(data.ListOptions{Sort: "expiry", Filters: map[string]string{"user_id": fmt.Sprint(u.ID)}, PageSize: 2, Cursor: owned.NextCursor})
+	if err != nil || len(rest.Items) != 1 || rest.NextCursor != "" {
+		t.Fatalf("expected the last token of the user, got %v: %v", rest, err)
+	}
+
+	if !rest.Items[0].Expires.After(owned.Items[1].Expires) || rest.Items[0].UserID != u.ID {
+		t.Error("second page of tokens is out of order or belongs to another user")
+	}
+
+	if _, err := m.Tokens.List(data.ListOptions{Filters: map[string]string{"token_hash": "x"}}); !errors.Is(err, data.ErrInvalidListOptions) {
+		t.Error("filtering tokens by hash, expected ErrInvalidListOptions, got", err)
+	}
+}
+
+var errRollback = errors.New("roll back")
+
+// insertUserWithToken inserts a user and their first token through tx
+func insertUserWithToken(tx data.Models, email string) (*data.Token, error) {
+	id, err := tx.Users.Insert(data.User{FirstName: "John", LastName: "Smith", Email: email, Password: Password})
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := tx.Users.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := tx.Tokens.GenerateToken(id, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Tokens.Insert(*token, *u)
+}
+
+func testWithTx(t *testing.T, m data.Models) {
+	var token *data.Token
+
+	err := m.WithTx(context.Background(), func(tx data.Models) error {
+		var err error
+		token, err = insertUserWithToken(tx, "john.smith@test.com")
+		return err
+	})
+	if err != nil {
+		t.Fatal("error running transaction:", err)
+	}
+
+	u, err := m.Users.GetByEmail("john.smith@test.com")
+	if err != nil {
+		t.Fatal("user inserted in committed transaction not found:", err)
+	}
+
+	if ok, err := m.Tokens.ValidToken(token.PlainText); !ok || err != nil {
+		t.Error("token inserted in committed transaction not valid:", err)
+	}
+
+	if u.Token.ID == 0 {
+		t.Error("token inserted in committed transaction not attached to user")
+	}
+}
+
+func testWithTxRollback(t *testing.T, m data.Models) {
+	var token *data.Token
+
+	err := m.WithTx(context.Background(), func(tx data.Models) error {
+		var err error
+		if token, err = insertUserWithToken(tx, "john.smith@test.com"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatal("expected the error returned inside the transaction, got", err)
+	}
+
+	if _, err := m.Users.GetByEmail("john.smith@test.com"); err == nil {
+		t.Error("user inserted in rolled back transaction found")
+	}
+
+	if ok, _ := m.Tokens.ValidToken(token.PlainText); ok {
+		t.Error("token inserted in rolled back transaction is valid")
+	}
+}
+
+func testWithTxPanic(t *testing.T, m data.Models) {
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Error("expected the panic to be passed on after rolling back, got", p)
+			}
+		}()
+
+		_ = m.WithTx(context.Background(), func(tx data.Models) error {
+			if _, err := insertUserWithToken(tx, "john.smith@test.com"); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	if _, err := m.Users.GetByEmail("john.smith@test.com"); err == nil {
+		t.Error("user inserted in transaction that panicked found")
+	}
+
+	// the models must still be usable after the rollback
+	InsertUser(t, m, "jane.doe@test.com")
+}
+
+func testWithTxNested(t *testing.T, m data.Models) {
+	err := m.WithTx(context.Background(), func(tx data.Models) error {
+		if _, err := insertUserWithToken(tx, "outer@test.com"); err != nil {
+			return err
+		}
+
+		err := tx.WithTx(context.Background(), func(inner data.Models) error {
+			if _, err := insertUserWithToken(inner, "failed@test.com"); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Error("expected the error returned inside the savepoint, got", err)
+		}
+
+		return tx.WithTx(context.Background(), func(inner data.Models) error {
+			_, err := insertUserWithToken(inner, "inner@test.com")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal("error running transaction:", err)
+	}
+
+	for email, exists := range map[string]bool{"outer@test.com": true, "failed@test.com": false, "inner@test.com": true} {
+		if _, err := m.Users.GetByEmail(email); (err == nil) != exists {
+			t.Errorf("%s: expected the user to exist: %t, got error %v", email, exists, err)
+		}
+	}
+}
+
+// auditEvents returns every audit event recorded for the entity, oldest first
+func auditEvents(t *testing.T, m data.Models, entity string, id int) []*data.AuditEvent {
+	t.Helper()
+
+	page, err := m.AuditEvents.List(data.ListOptions{
+		PageSize: data.MaxPageSize,
+		Sort:     "id",
+		Filters:  map[string]string{"entity": entity, "entity_id": fmt.Sprint(id)},
+	})
+	if err != nil {
+		t.Fatal("error listing audit events:", err)
+	}
+
+	return page.Items
+}
+
+// auditDiff returns the decoded changes of event
+func auditDiff(t *testing.T, event *data.AuditEvent) map[string]data.Change {
+	t.Helper()
+
+	diff, err := event.Diff()
+	if err != nil {
+		t.Fatal("error decoding audit event changes:", err)
+	}
+
+	return diff
+}
+
+func testAuditEvents(t *testing.T, m data.Models) {
+	admin := data.Actor{UserID: 42, Via: data.ActorSession, IP: "192.0.2.1"}
+	m = m.WithContext(data.WithActor(context.Background(), admin))
+
+	u := InsertUser(t, m, "john.smith@test.com")
+	token := InsertToken(t, m, u, time.Hour)
+
+	u.LastName = "Jones"
+	if err := m.Users.Update(*u); err != nil {
+		t.Fatal("failed to update user:", err)
+	}
+
+	if err := m.Users.ResetPassword(u.ID, newPassword); err != nil {
+		t.Fatal("failed to reset password:", err)
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("failed to delete user:", err)
+	}
+
+	if err := m.Users.Restore(u.ID); err != nil {
+		t.Fatal("failed to restore user:", err)
+	}
+
+	events := auditEvents(t, m, "users", u.ID)
+
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+		if event.ActorID != admin.UserID || event.ActorVia != admin.Via || event.ActorIP != admin.IP {
+			t.Errorf("%s: expected actor %+v, got %d via %q from %q", event.Action, admin, event.ActorID, event.ActorVia, event.ActorIP)
+		}
+	}
+
+	expected := []string{data.AuditInsert, data.AuditUpdate, data.AuditUpdate, data.AuditDelete, data.AuditRestore}
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Fatalf("expected user events %v, got %v", expected, actions)
+	}
+
+	inserted := auditDiff(t, events[0])
+	if c := inserted["email"]; c.Before != nil || c.After != "john.smith@test.com" {
+		t.Errorf("insert: expected email to change from nil to john.smith@test.com, got %+v", c)
+	}
+
+	updated := auditDiff(t, events[1])
+	if c := updated["last_name"]; c.Before != "Smith" || c.After != "Jones" {
+		t.Errorf("update: expected last_name to change from Smith to Jones, got %+v", c)
+	}
+	if _, ok := updated["email"]; ok {
+		t.Error("update: unchanged email recorded in diff")
+	}
+
+	// the insert and the password reset change the password
+	for _, event := range []*data.AuditEvent{events[0], events[2]} {
+		if c := auditDiff(t, event)["password"]; c.After != "[redacted]" {
+			t.Errorf("event %d: expected password to be redacted, got %+v", event.ID, c)
+		}
+	}
+
+	if c := auditDiff(t, events[3])["deleted_at"]; c.Before != nil || c.After == nil {
+		t.Errorf("delete: expected deleted_at to be set, got %+v", c)
+	}
+
+	if _, err := m.Tokens.GetByToken(token.PlainText); err == nil {
+		t.Fatal("token still exists after its user was deleted")
+	}
+
+	// the user's only token was inserted and then revoked by the delete
+	page, err := m.AuditEvents.List(data.ListOptions{Sort: "id", Filters: map[string]string{"entity": "tokens"}})
+	if err != nil {
+		t.Fatal("error listing audit events:", err)
+	}
+	tokenEvents := page.Items
+
+	if len(tokenEvents) != 2 || tokenEvents[0].Action != data.AuditInsert || tokenEvents[1].Action != data.AuditDelete {
+		t.Fatalf("expected a token insert and delete to be recorded, got %d events", len(tokenEvents))
+	}
+
+	for _, event := range tokenEvents {
+		diff := auditDiff(t, event)
+		if c := diff["token_hash"]; c.Before != nil && c.Before != "[redacted]" || c.After != nil && c.After != "[redacted]" {
+			t.Errorf("%s: expected token_hash to be redacted, got %+v", event.Action, c)
+		}
+		if c, ok := diff["user_id"]; !ok || (c.Before != float64(u.ID) && c.After != float64(u.ID)) {
+			t.Errorf("%s: expected user_id %d in diff, got %+v", event.Action, u.ID, c)
+		}
+	}
+
+	if _, err := m.Users.Purge(-time.Hour); err != nil {
+		t.Fatal("failed to purge users:", err)
+	}
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("failed to delete user:", err)
+	}
+	if _, err := m.Users.Purge(-time.Hour); err != nil {
+		t.Fatal("failed to purge users:", err)
+	}
+
+	events = auditEvents(t, m, "users", u.ID)
+	if last := events[len(events)-1]; last.Action != data.AuditPurge {
+		t.Errorf("expected the purge to be recorded last, got %q", last.Action)
+	} else if c := auditDiff(t, last)["email"]; c.Before != "john.smith@test.com" || c.After != nil {
+		t.Errorf("purge: expected email to change from john.smith@test.com to nil, got %+v", c)
+	}
+}
+
+func testAuditEventsRollback(t *testing.T, m data.Models) {
+	err := m.WithTx(context.Background(), func(tx data.Models) error {
+		if _, err := insertUserWithToken(tx, "john.smith@test.com"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatal("expected the error returned inside the transaction, got", err)
+	}
+
+	page, err := m.AuditEvents.List(data.ListOptions{})
+	if err != nil {
+		t.Fatal("error listing audit events:", err)
+	}
+
+	if page.Total != 0 {
+		t.Errorf("expected the audit events of a rolled back transaction to be discarded, got %d", page.Total)
+	}
+
+	InsertUser(t, m, "jane.doe@test.com")
+
+	page, _ = m.AuditEvents.List(data.ListOptions{})
+	if page.Total != 1 || page.Items[0].ActorVia != data.ActorSystem {
+		t.Errorf("expected one event by the system actor without an actor in context, got %+v", page.Items)
+	}
+}
+
+func testOrganizations(t *testing.T, m data.Models) {
+	acme := InsertOrganization(t, m, "acme")
+
+	if org, err := m.Organizations.GetBySlug("acme"); err != nil || org.ID != acme.ID {
+		t.Errorf("expected to find acme by its slug, got %+v, %v", org, err)
+	}
+
+	if _, err := m.Organizations.Insert(data.Organization{Name: "Other", Slug: "acme"}); err == nil {
+		t.Error("inserting organization with duplicate slug, expected an error, received none")
+	}
+
+	globex := InsertOrganization(t, m, "globex")
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	if err := m.Organizations.AddMember(acme.ID, u.ID, data.RoleOwner); err != nil {
+		t.Fatal("failed to add member:", err)
+	}
+	if err := m.Organizations.AddMember(globex.ID, u.ID, data.RoleMember); err != nil {
+		t.Fatal("failed to add member:", err)
+	}
+
+	membership, err := m.Organizations.Membership(acme.ID, u.ID)
+	if err != nil || membership.Role != data.RoleOwner {
+		t.Errorf("expected the user to own acme, got %+v, %v", membership, err)
+	}
+
+	orgs, err := m.Organizations.ForUser(u.ID)
+	if err != nil {
+		t.Fatal("error getting organizations for user:", err)
+	}
+	if len(orgs) != 2 || orgs[0].Slug != "acme" || orgs[1].Slug != "globex" {
+		t.Errorf("expected the user to be in acme and globex, got %v", orgs)
+	}
+
+	if err := m.Organizations.RemoveMember(acme.ID, u.ID); err != nil {
+		t.Fatal("failed to remove member:", err)
+	}
+	if _, err := m.Organizations.Membership(acme.ID, u.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("membership after removing member, expected ErrNotFound, got", err)
+	}
+
+	if err := m.Organizations.Delete(globex.ID); err != nil {
+		t.Fatal("failed to delete organization:", err)
+	}
+	if orgs, _ := m.Organizations.ForUser(u.ID); len(orgs) != 0 {
+		t.Errorf("expected memberships to go with their organization, got %v", orgs)
+	}
+
+	if events := auditEvents(t, m, "organizations", globex.ID); len(events) != 2 || events[0].Action != data.AuditInsert || events[1].Action != data.AuditDelete {
+		t.Errorf("expected the insert and delete of globex to be recorded, got %d events", len(events))
+	}
+
+	page, err := m.AuditEvents.List(data.ListOptions{PageSize: data.MaxPageSize, Sort: "id", Filters: map[string]string{"entity": "memberships"}})
+	if err != nil {
+		t.Fatal("error listing audit events:", err)
+	}
+
+	var actions []string
+	for _, event := range page.Items {
+		actions = append(actions, event.Action)
+	}
+
+	// both memberships were added, then one removed and the other deleted with globex
+	expected := []string{data.AuditInsert, data.AuditInsert, data.AuditDelete, data.AuditDelete}
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Errorf("expected membership events %v, got %v", expected, actions)
+	} else if c := auditDiff(t, page.Items[2])["role"]; c.Before != data.RoleOwner || c.After != nil {
+		t.Errorf("remove member: expected role to change from owner to nil, got %+v", c)
+	}
+}
+
+func testTenantIsolation(t *testing.T, m data.Models) {
+	acmeOrg := InsertOrganization(t, m, "acme")
+	globexOrg := InsertOrganization(t, m, "globex")
+	acme, globex := InTenant(m, acmeOrg), InTenant(m, globexOrg)
+
+	alice := InsertUser(t, acme, "alice@acme.test")
+	bob := InsertUser(t, globex, "bob@globex.test")
+	aliceToken := InsertToken(t, acme, alice, time.Hour)
+	bobToken := InsertToken(t, globex, bob, time.Hour)
+
+	if all, err := m.Users.GetAll(); err != nil || len(all) != 2 {
+		t.Errorf("expected unscoped models to see both users, got %d, %v", len(all), err)
+	}
+
+	if all, _ := acme.Users.GetAll(); len(all) != 1 || all[0].ID != alice.ID {
+		t.Errorf("expected acme to only see alice, got %v", all)
+	}
+	if page, _ := acme.Users.List(data.ListOptions{}); page.Total != 1 {
+		t.Errorf("expected acme to list one user, got %d", page.Total)
+	}
+	if _, err := acme.Users.Get(bob.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting user of another tenant, expected ErrNotFound, got", err)
+	}
+	if _, err := acme.Users.GetByEmail(bob.Email); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting user of another tenant by email, expected ErrNotFound, got", err)
+	}
+
+	bob.LastName = "Hacked"
+	if err := acme.Users.Update(*bob); !errors.Is(err, data.ErrNotFound) {
+		t.Error("updating user of another tenant, expected ErrNotFound, got", err)
+	}
+	if err := acme.Users.ResetPassword(bob.ID, "hacked-by-acme-2024"); err == nil {
+		t.Error("resetting password of user of another tenant, expected an error, received none")
+	}
+	if err := acme.Users.Delete(bob.ID); err != nil {
+		t.Error("deleting user of another tenant failed:", err)
+	}
+	if u, err := globex.Users.Get(bob.ID); err != nil || u.LastName != "Smith" {
+		t.Errorf("user changed from another tenant, got %+v, %v", u, err)
+	}
+
+	if _, err := acme.Tokens.GetByToken(bobToken.PlainText); err == nil {
+		t.Error("found token of another tenant")
+	}
+	if ok, _ := acme.Tokens.ValidToken(bobToken.PlainText); ok {
+		t.Error("token of another tenant is valid")
+	}
+	if _, err := acme.Tokens.AuthenticateToken(bearerRequest(bobToken.PlainText)); err == nil {
+		t.Error("authenticated with token of another tenant")
+	}
+	if ok, err := acme.Tokens.ValidToken(aliceToken.PlainText); !ok {
+		t.Error("token of own tenant not valid:", err)
+	}
+	if tokens, _ := acme.Tokens.GetTokensForUser(bob.ID); len(tokens) != 0 {
+		t.Errorf("expected no tokens of user of another tenant, got %d", len(tokens))
+	}
+	if page, _ := acme.Tokens.List(data.ListOptions{}); page.Total != 1 {
+		t.Errorf("expected acme to list one token, got %d", page.Total)
+	}
+
+	stored, _ := m.Tokens.GetByToken(bobToken.PlainText)
+	if _, err := acme.Tokens.Get(stored.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting token of another tenant by id, expected ErrNotFound, got", err)
+	}
+
+	_ = acme.Tokens.Delete(stored.ID)
+	_ = acme.Tokens.DeleteByToken(bobToken.PlainText)
+	if ok, _ := globex.Tokens.ValidToken(bobToken.PlainText); !ok {
+		t.Error("token deleted from another tenant")
+	}
+
+	token, _ := acme.Tokens.GenerateToken(bob.ID, time.Hour)
+	if err := acme.Tokens.Insert(*token, *bob); !errors.Is(err, data.ErrNotFound) {
+		t.Error("inserting token for user of another tenant, expected ErrNotFound, got", err)
+	}
+
+	events, err := acme.AuditEvents.List(data.ListOptions{PageSize: data.MaxPageSize})
+	if err != nil {
+		t.Fatal("error listing audit events:", err)
+	}
+	for _, event := range events.Items {
+		if event.OrganizationID != acmeOrg.ID {
+			t.Errorf("acme sees audit event %d of organization %d", event.ID, event.OrganizationID)
+		}
+	}
+	if events.Total != 3 {
+		t.Errorf("expected acme to see the insert of alice, her membership and her token, got %d events", events.Total)
+	}
+
+	err = acme.WithTx(context.Background(), func(tx data.Models) error {
+		if _, err := tx.Users.Get(bob.ID); !errors.Is(err, data.ErrNotFound) {
+			t.Error("getting user of another tenant in transaction, expected ErrNotFound, got", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error running transaction:", err)
+	}
+
+	if err := m.Organizations.AddMember(acmeOrg.ID, bob.ID, data.RoleMember); err != nil {
+		t.Fatal("failed to add member:", err)
+	}
+	if _, err := acme.Users.Get(bob.ID); err != nil {
+		t.Error("user added to tenant not visible in it:", err)
+	}
+}
+
+func testNoOrganization(t *testing.T, m data.Models) {
+	org := InsertOrganization(t, m, "acme")
+	u := InsertUser(t, InTenant(m, org), "john.smith@test.com")
+	token := InsertToken(t, m, u, time.Hour)
+
+	roleID, err := m.Roles.Insert(data.Role{Name: "admin"})
+	if err != nil {
+		t.Fatal("failed to insert role:", err)
+	}
+	_ = m.Roles.Grant(roleID, "users.delete")
+	_ = m.Roles.Assign(u.ID, roleID)
+	_ = InTenant(m, org).Roles.Assign(u.ID, roleID)
+
+	none := m.WithContext(data.WithTenant(context.Background(), data.NoOrganization))
+
+	if all, err := none.Users.GetAll(); err != nil || len(all) != 0 {
+		t.Errorf("expected no users without an organization, got %d, %v", len(all), err)
+	}
+	if page, _ := none.Users.List(data.ListOptions{}); page == nil || page.Total != 0 {
+		t.Errorf("expected to list no users without an organization, got %+v", page)
+	}
+	if _, err := none.Users.Get(u.ID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting a user without an organization, expected ErrNotFound, got", err)
+	}
+	if _, err := none.Users.GetByEmail(u.Email); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting a user by email without an organization, expected ErrNotFound, got", err)
+	}
+	if ok, _ := none.Tokens.ValidToken(token.PlainText); ok {
+		t.Error("token valid without an organization")
+	}
+	if page, _ := none.Tokens.List(data.ListOptions{}); page == nil || page.Total != 0 {
+		t.Errorf("expected to list no tokens without an organization, got %+v", page)
+	}
+	if page, _ := none.AuditEvents.List(data.ListOptions{}); page == nil || page.Total != 0 {
+		t.Errorf("expected to see no audit events without an organization, got %+v", page)
+	}
+	if permissions, _ := none.Roles.PermissionsForUser(u.ID); len(permissions) != 0 {
+		t.Errorf("expected no permissions without an organization, got %v", permissions)
+	}
+	if err := none.Roles.Assign(u.ID, roleID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("assigning a role without an organization, expected ErrNotFound, got", err)
+	}
+
+	if _, err := none.Users.Insert(data.User{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@test.com", Password: Password}); !errors.Is(err, data.ErrNoOrganization) {
+		t.Error("inserting a user without an organization, expected ErrNoOrganization, got", err)
+	}
+	if _, err := m.Users.GetByEmail("jane.doe@test.com"); !errors.Is(err, data.ErrNotFound) {
+		t.Error("user inserted without an organization was stored")
+	}
+
+	newToken, _ := none.Tokens.GenerateToken(u.ID, time.Hour)
+	if err := none.Tokens.Insert(*newToken, *u); !errors.Is(err, data.ErrNotFound) {
+		t.Error("inserting a token without an organization, expected ErrNotFound, got", err)
+	}
+}
+
+func testRoles(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	adminID, err := m.Roles.Insert(data.Role{Name: "admin", Description: "Manages users"})
+	if err != nil {
+		t.Fatal("failed to insert role:", err)
+	}
+	editorID, err := m.Roles.Insert(data.Role{Name: "editor"})
+	if err != nil {
+		t.Fatal("failed to insert role:", err)
+	}
+
+	if _, err := m.Roles.Insert(data.Role{Name: "admin"}); err == nil {
+		t.Error("inserting role with duplicate name, expected an error, received none")
+	}
+
+	if role, err := m.Roles.GetByName("admin"); err != nil || role.ID != adminID || role.Description != "Manages users" {
+		t.Errorf("expected to find the admin role by name, got %+v, %v", role, err)
+	}
+
+	for _, grant := range []struct {
+		roleID     int
+		permission string
+	}{
+		{adminID, "users.delete"},
+		{adminID, "users.update"},
+		{adminID, "users.update"},
+		{editorID, "users.update"},
+		{editorID, "posts.publish"},
+	} {
+		if err := m.Roles.Grant(grant.roleID, grant.permission); err != nil {
+			t.Fatalf("failed to grant %s: %v", grant.permission, err)
+		}
+	}
+
+	if err := m.Roles.Grant(editorID+100, "users.delete"); !errors.Is(err, data.ErrNotFound) {
+		t.Error("granting permission to non-existent role, expected ErrNotFound, got", err)
+	}
+
+	if permissions, err := m.Roles.PermissionsForUser(u.ID); err != nil || len(permissions) != 0 {
+		t.Errorf("expected a user without roles to have no permissions, got %v, %v", permissions, err)
+	}
+
+	for _, roleID := range []int{adminID, editorID, editorID} {
+		if err := m.Roles.Assign(u.ID, roleID); err != nil {
+			t.Fatal("failed to assign role:", err)
+		}
+	}
+
+	if err := m.Roles.Assign(u.ID+100, adminID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("assigning role to non-existent user, expected ErrNotFound, got", err)
+	}
+
+	roles, err := m.Roles.ForUser(u.ID)
+	if err != nil {
+		t.Fatal("error getting roles for user:", err)
+	}
+	if len(roles) != 2 || roles[0].Name != "admin" || roles[1].Name != "editor" {
+		t.Errorf("expected the user to be admin and editor, got %v", roles)
+	}
+
+	permissions, err := m.Roles.PermissionsForUser(u.ID)
+	if err != nil {
+		t.Fatal("error getting permissions for user:", err)
+	}
+	if fmt.Sprint(permissions) != "[posts.publish users.delete users.update]" {
+		t.Errorf("expected the permissions of both roles once each, got %v", permissions)
+	}
+
+	if err := m.Roles.Revoke(editorID, "posts.publish"); err != nil {
+		t.Fatal("failed to revoke permission:", err)
+	}
+	if err := m.Roles.Unassign(u.ID, adminID); err != nil {
+		t.Fatal("failed to unassign role:", err)
+	}
+
+	if permissions, _ := m.Roles.PermissionsForUser(u.ID); fmt.Sprint(permissions) != "[users.update]" {
+		t.Errorf("expected only users.update after revoking, got %v", permissions)
+	}
+
+	org := InsertOrganization(t, m, "acme")
+	if permissions, _ := InTenant(m, org).Roles.PermissionsForUser(u.ID); len(permissions) != 0 {
+		t.Errorf("expected a user to have no permissions in an organization they are not in, got %v", permissions)
+	}
+	if err := InTenant(m, org).Roles.Assign(u.ID, adminID); !errors.Is(err, data.ErrNotFound) {
+		t.Error("assigning role to user outside the tenant, expected ErrNotFound, got", err)
+	}
+
+	if err := m.Roles.Delete(editorID); err != nil {
+		t.Fatal("failed to delete role:", err)
+	}
+	if roles, _ := m.Roles.ForUser(u.ID); len(roles) != 0 {
+		t.Errorf("expected a deleted role to be taken away from its users, got %v", roles)
+	}
+
+	if events := auditEvents(t, m, "roles", editorID); len(events) != 2 || events[0].Action != data.AuditInsert || events[1].Action != data.AuditDelete {
+		t.Errorf("expected the insert and delete of the editor role to be recorded, got %d events", len(events))
+	}
+}
+
+func testRolesPerOrganization(t *testing.T, m data.Models) {
+	acmeOrg := InsertOrganization(t, m, "acme")
+	globexOrg := InsertOrganization(t, m, "globex")
+	acme, globex := InTenant(m, acmeOrg), InTenant(m, globexOrg)
+
+	u := InsertUser(t, acme, "john.smith@test.com")
+	if err := m.Organizations.AddMember(globexOrg.ID, u.ID, data.RoleMember); err != nil {
+		t.Fatal("failed to add member:", err)
+	}
+
+	adminID, err := m.Roles.Insert(data.Role{Name: "admin"})
+	if err != nil {
+		t.Fatal("failed to insert role:", err)
+	}
+	if err := m.Roles.Grant(adminID, "users.delete"); err != nil {
+		t.Fatal("failed to grant permission:", err)
+	}
+
+	if err := acme.Roles.Assign(u.ID, adminID); err != nil {
+		t.Fatal("failed to assign role:", err)
+	}
+
+	if permissions, _ := acme.Roles.PermissionsForUser(u.ID); fmt.Sprint(permissions) != "[users.delete]" {
+		t.Errorf("expected the role to grant users.delete in acme, got %v", permissions)
+	}
+	if permissions, _ := globex.Roles.PermissionsForUser(u.ID); len(permissions) != 0 {
+		t.Errorf("expected a role assigned in acme to grant nothing in globex, got %v", permissions)
+	}
+	if roles, _ := globex.Roles.ForUser(u.ID); len(roles) != 0 {
+		t.Errorf("expected a role assigned in acme to be missing in globex, got %v", roles)
+	}
+	if permissions, _ := m.Roles.PermissionsForUser(u.ID); len(permissions) != 0 {
+		t.Errorf("expected a role assigned in acme to grant nothing outside of an organization, got %v", permissions)
+	}
+
+	if err := globex.Roles.Unassign(u.ID, adminID); err != nil {
+		t.Fatal("failed to unassign role:", err)
+	}
+	if roles, _ := acme.Roles.ForUser(u.ID); len(roles) != 1 {
+		t.Errorf("expected unassigning in globex to leave the role in acme, got %v", roles)
+	}
+
+	if err := m.Roles.Revoke(adminID, "users.delete"); err != nil {
+		t.Fatal("failed to revoke permission:", err)
+	}
+	if err := acme.Roles.Unassign(u.ID, adminID); err != nil {
+		t.Fatal("failed to unassign role:", err)
+	}
+
+	grants := auditEvents(t, m, "role_permissions", adminID)
+	if len(grants) != 2 || grants[0].Action != data.AuditInsert || grants[1].Action != data.AuditDelete {
+		t.Errorf("expected the grant and revoke to be recorded, got %d events", len(grants))
+	}
+
+	assignments := auditEvents(t, m, "user_roles", u.ID)
+	if len(assignments) != 2 || assignments[0].Action != data.AuditInsert || assignments[1].Action != data.AuditDelete {
+		t.Fatalf("expected the assign and unassign to be recorded, got %d events", len(assignments))
+	}
+	if c := auditDiff(t, assignments[0])["organization_id"]; c.After != float64(acmeOrg.ID) {
+		t.Errorf("assign: expected organization_id %d, got %+v", acmeOrg.ID, c)
+	}
+	if assignments[0].OrganizationID != acmeOrg.ID {
+		t.Errorf("expected the assignment to be recorded in acme, got organization %d", assignments[0].OrganizationID)
+	}
+
+	// the roles of a user in an organization go with their membership
+	if err := acme.Roles.Assign(u.ID, adminID); err != nil {
+		t.Fatal("failed to assign role:", err)
+	}
+	if err := m.Organizations.RemoveMember(acmeOrg.ID, u.ID); err != nil {
+		t.Fatal("failed to remove member:", err)
+	}
+	if err := m.Organizations.AddMember(acmeOrg.ID, u.ID, data.RoleMember); err != nil {
+		t.Fatal("failed to add member:", err)
+	}
+	if roles, _ := acme.Roles.ForUser(u.ID); len(roles) != 0 {
+		t.Errorf("expected the roles of a removed member to be gone when they are added again, got %v", roles)
+	}
+}
+
+func testUserIdentities(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	id, err := m.UserIdentities.Insert(data.UserIdentity{UserID: u.ID, Provider: "gitlab", ProviderUserID: "42", Email: u.Email})
+	if err != nil {
+		t.Fatal("error linking identity:", err)
+	}
+	if _, err := m.UserIdentities.Insert(data.UserIdentity{UserID: u.ID, Provider: "github", ProviderUserID: "42", Email: u.Email}); err != nil {
+		t.Fatal("error linking the same account id at another provider:", err)
+	}
+
+	other := InsertUser(t, m, "jane.doe@test.com")
+	if _, err := m.UserIdentities.Insert(data.UserIdentity{UserID: other.ID, Provider: "gitlab", ProviderUserID: "42"}); !errors.Is(err, data.ErrIdentityLinked) {
+		t.Error("linking an account twice, expected ErrIdentityLinked, got", err)
+	}
+	if _, err := m.UserIdentities.Insert(data.UserIdentity{UserID: other.ID + 100, Provider: "gitlab", ProviderUserID: "43"}); !errors.Is(err, data.ErrNotFound) {
+		t.Error("linking an account to a non-existent user, expected ErrNotFound, got", err)
+	}
+
+	identity, err := m.UserIdentities.Get("gitlab", "42")
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	if identity.ID != id || identity.UserID != u.ID || identity.Email != u.Email || identity.CreatedAt.IsZero() {
+		t.Errorf("wrong identity returned: %+v", identity)
+	}
+
+	if _, err := m.UserIdentities.Get("gitlab", "43"); !errors.Is(err, data.ErrNotFound) {
+		t.Error("getting an unknown identity, expected ErrNotFound, got", err)
+	}
+
+	identities, err := m.UserIdentities.ForUser(u.ID)
+	if err != nil {
+		t.Fatal("error listing identities:", err)
+	}
+	if len(identities) != 2 || identities[0].Provider != "github" || identities[1].Provider != "gitlab" {
+		t.Errorf("expected the github and gitlab identities in that order, got %+v", identities)
+	}
+
+	if err := m.UserIdentities.Delete(id); err != nil {
+		t.Fatal("error unlinking identity:", err)
+	}
+	if _, err := m.UserIdentities.Get("gitlab", "42"); !errors.Is(err, data.ErrNotFound) {
+		t.Error("identity still found after unlinking it:", err)
+	}
+	if err := m.UserIdentities.Delete(id); err != nil {
+		t.Error("unlinking an identity twice:", err)
+	}
+
+	if err := m.Users.Delete(u.ID); err != nil {
+		t.Fatal("error deleting user:", err)
+	}
+	if _, err := m.UserIdentities.Insert(data.UserIdentity{UserID: u.ID, Provider: "gitlab", ProviderUserID: "44"}); !errors.Is(err, data.ErrNotFound) {
+		t.Error("linking an account to a deleted user, expected ErrNotFound, got", err)
+	}
+}
+
+func testRememberTokensConcurrentRotate(t *testing.T, m data.Models) {
+	u := InsertUser(t, m, "john.smith@test.com")
+
+	token, err := m.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Fatal("error issuing remember token:", err)
+	}
+
+	// two requests carrying the same cookie must not both come away with a live token
+	const requests = 8
+	rotated := make(chan string, requests)
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if newToken, err := m.RememberTokens.Rotate(u.ID, token); err == nil {
+				rotated <- newToken
+			}
+		}()
+	}
+	wg.Wait()
+	close(rotated)
+
+	var live int
+	for newToken := range rotated {
+		if ok, _ := m.RememberTokens.Valid(u.ID, newToken); ok {
+			live++
+		}
+	}
+
+	if live > 1 {
+		t.Errorf("rotating one remember token concurrently left %d live tokens, expected at most 1", live)
+	}
+	if ok, _ := m.RememberTokens.Valid(u.ID, token); ok {
+		t.Error("rotated remember token still valid")
+	}
+}
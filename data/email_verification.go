@@ -0,0 +1,203 @@
+package data
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// EmailVerificationLifetime is how long the link in a verification email can be used
+const EmailVerificationLifetime = 24 * time.Hour
+
+// EmailVerificationInterval is how long a user waits between verification emails
+const EmailVerificationInterval = time.Minute
+
+// ErrThrottled is returned when another verification email is asked for too soon after the last one
+var ErrThrottled = errors.New("data: too many requests, try again later")
+
+// EmailVerification is the type for a row in the email_verifications table. Only the hex
+// encoded SHA-256 hash of a token is stored; the plain text is in the link emailed to the user.
+// PasswordHash, when set, is the password the user signed up with when the link was sent,
+// which verifying it gives them.
+type EmailVerification struct {
+	ID           int       `db:"id,omitempty"`
+	UserID       int       `db:"user_id"`
+	TokenHash    string    `db:"token_hash"`
+	PasswordHash string    `db:"password_hash"`
+	Expires      time.Time `db:"expiry"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// Expired reports whether the verification link can no longer be used
+func (v *EmailVerification) Expired() bool {
+	return v.Expires.Before(time.Now())
+}
+
+// Throttled reports whether it is too soon after v was issued to issue another one
+func (v *EmailVerification) Throttled() bool {
+	return v.CreatedAt.After(time.Now().Add(-EmailVerificationInterval))
+}
+
+// HashEmailVerification returns the value stored in the token_hash column for plainText
+func HashEmailVerification(plainText string) string {
+	return hex.EncodeToString(HashToken(plainText))
+}
+
+// emailVerificationModel is the SQL implementation of EmailVerificationRepository. Like
+// remember tokens, it never reads from a replica.
+type emailVerificationModel struct {
+	sqlSession
+}
+
+func (m *emailVerificationModel) Table() string {
+	return "email_verifications"
+}
+
+func (m *emailVerificationModel) Issue(userID int) (string, error) {
+	return m.issue(userID, "")
+}
+
+func (m *emailVerificationModel) IssueWithPassword(userID int, passwordHash string) (string, error) {
+	return m.issue(userID, passwordHash)
+}
+
+// issue stores a new token for the user that sets their password to passwordHash when it is
+// verified, unless that is empty, and returns its plain text
+func (m *emailVerificationModel) issue(userID int, passwordHash string) (string, error) {
+	plainText, err := RandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.atomically(func(tx sqlSession) error {
+		users, err := tx.collection("users")
+		if err != nil {
+			return err
+		}
+
+		if exists, err := users.Find(notDeleted, up.Cond{"id =": userID}).Exists(); err != nil {
+			return err
+		} else if !exists {
+			return ErrNotFound
+		}
+
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var latest EmailVerification
+		if err := collection.Find(up.Cond{"user_id =": userID}).OrderBy("-created_at").One(&latest); err != nil {
+			if !errors.Is(err, up.ErrNoMoreRows) {
+				return err
+			}
+		} else if latest.Throttled() {
+			return ErrThrottled
+		}
+
+		// only the newest link works, so a leaked older email cannot verify the address
+		if err := collection.Find(up.Cond{"user_id =": userID}).Delete(); err != nil {
+			return err
+		}
+
+		_, err = collection.Insert(EmailVerification{
+			UserID:       userID,
+			TokenHash:    HashEmailVerification(plainText),
+			PasswordHash: passwordHash,
+			Expires:      time.Now().Add(EmailVerificationLifetime),
+			CreatedAt:    time.Now(),
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+func (m *emailVerificationModel) Verify(plainText string) (int, error) {
+	var userID int
+
+	err := m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var verification EmailVerification
+		if err := collection.Find(up.Cond{"token_hash =": HashEmailVerification(plainText)}).One(&verification); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+
+		if verification.Expired() {
+			return ErrExpiredToken
+		}
+
+		users, err := tx.collection("users")
+		if err != nil {
+			return err
+		}
+
+		var before User
+		if err := users.Find(notDeleted, up.Cond{"id =": verification.UserID}).One(&before); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+
+		if err := collection.Find(up.Cond{"id =": verification.ID}).Delete(); err != nil {
+			return err
+		}
+
+		userID = before.ID
+		if before.Active == 1 {
+			return nil
+		}
+
+		// nobody had proved they own the address before, so whatever access was given to the
+		// account until now is revoked, and the password becomes the one sent with the link
+		tokens := tokenModel{tx}
+		if err := tokens.deleteWhere(up.Cond{"user_id =": before.ID}); err != nil {
+			return err
+		}
+
+		rememberTokens := rememberTokenModel{tx}
+		if err := rememberTokens.DeleteForUser(before.ID); err != nil {
+			return err
+		}
+
+		after := before
+		after.Active = 1
+		after.UpdatedAt = time.Now()
+		after.Version++
+
+		set := []any{"user_active", 1, "updated_at", after.UpdatedAt, "version", up.Raw("version + 1")}
+		if verification.PasswordHash != "" {
+			after.Password = verification.PasswordHash
+			set = append(set, "password", after.Password)
+		}
+
+		_, err = users.Session().SQL().
+			Update("users").
+			Set(set...).
+			Where(up.Cond{"id =": before.ID}).
+			Exec()
+		if err != nil {
+			return err
+		}
+
+		return tx.record(AuditUpdate, "users", before.ID, before, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,24 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailVerification_Expired(t *testing.T) {
+	v := EmailVerification{Expires: time.Now().Add(time.Minute), CreatedAt: time.Now()}
+	if v.Expired() {
+		t.Error("unexpired verification reported as expired")
+	}
+	if !v.Throttled() {
+		t.Error("verification issued just now does not throttle another")
+	}
+
+	v = EmailVerification{Expires: time.Now().Add(-time.Minute), CreatedAt: time.Now().Add(-EmailVerificationLifetime)}
+	if !v.Expired() {
+		t.Error("expired verification reported as unexpired")
+	}
+	if v.Throttled() {
+		t.Error("verification issued a day ago throttles another")
+	}
+}
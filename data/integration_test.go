@@ -4,9 +4,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"myapp/migrations"
 	"net/http"
 	"os"
 	"testing"
@@ -31,7 +34,7 @@ var dummyUser = User{
 	LastName:  "Smith",
 	Email:     "john.smith@test.com",
 	Active:    1,
-	Password:  "password",
+	Password:  "kettle-harbor-quilt-42",
 }
 
 var models Models
@@ -39,6 +42,9 @@ var testDB *sql.DB
 var resource *dockertest.Resource
 var pool *dockertest.Pool
 
+// plainTextToken holds the token issued in TestToken_Insert; only its hash is stored in the database
+var plainTextToken string
+
 func TestMain(m *testing.M) {
 	os.Setenv("DATABASE_TYPE", "postgres")
 	os.Setenv("UPPER_DB_LOG", "ERROR")
@@ -84,11 +90,19 @@ func TestMain(m *testing.M) {
 		log.Fatalf("could not connect to docker: %s", err)
 	}
 
-	if err = createTables(testDB); err != nil {
+	migrator, err := migrations.New(testDB, os.Getenv("DATABASE_TYPE"))
+	if err != nil {
+		log.Fatalf("error loading migrations: %s", err)
+	}
+
+	if _, err = migrator.Up(context.Background()); err != nil {
 		log.Fatalf("error creating tables: %s", err)
 	}
 
-	models = New(testDB)
+	models, err = New(testDB)
+	if err != nil {
+		log.Fatalf("error creating models: %s", err)
+	}
 
 	code := m.Run()
 
@@ -99,18 +113,6 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func createTables(db *sql.DB) error {
-	file, err := os.ReadFile("create-test-tables.sql")
-	if err != nil {
-		return err
-	}
-
-	stmt := string(file)
-
-	_, err = db.Exec(stmt)
-	return err
-}
-
 func TestUser_Table(t *testing.T) {
 	s := models.Users.Table()
 	if s != "users" {
@@ -151,6 +153,21 @@ func TestUser_GetAll(t *testing.T) {
 	}
 }
 
+func TestUser_List(t *testing.T) {
+	p, err := models.Users.List(ListOptions{PageSize: 1, Sort: "-created_at"})
+	if err != nil {
+		t.Fatal("failed to list users:", err)
+	}
+
+	if len(p.Items) != 1 || p.Total == 0 {
+		t.Errorf("expected a page of one user, got %d of %d", len(p.Items), p.Total)
+	}
+
+	if p.PrevCursor != "" {
+		t.Error("first page has a previous cursor")
+	}
+}
+
 func TestUser_GetByEmail(t *testing.T) {
 	u, err := models.Users.GetByEmail(dummyUser.Email)
 	if err != nil {
@@ -186,6 +203,23 @@ func TestUser_Update(t *testing.T) {
 	}
 }
 
+func TestUser_UpdateStale(t *testing.T) {
+	u, err := models.Users.Get(1)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+
+	stale := *u
+
+	if err := models.Users.Update(*u); err != nil {
+		t.Error("failed to update user:", err)
+	}
+
+	if err := models.Users.Update(stale); !errors.Is(err, ErrStaleRecord) {
+		t.Error("updating user changed since it was loaded, expected ErrStaleRecord, got", err)
+	}
+}
+
 func TestUser_PasswordMatches(t *testing.T) {
 	u, err := models.Users.Get(1)
 	if err != nil {
@@ -212,7 +246,7 @@ func TestUser_PasswordMatches(t *testing.T) {
 	}
 }
 func TestUser_ResetPassword(t *testing.T) {
-	newPassword := "newpassword"
+	newPassword := "lantern-meadow-cobalt-7"
 	if err := models.Users.ResetPassword(1, newPassword); err != nil {
 		t.Error("failed to reset password:", err)
 	}
@@ -231,6 +265,23 @@ func TestUser_Delete(t *testing.T) {
 	if err == nil {
 		t.Error("trying to retrieve record of deleted user, expected error, received none")
 	}
+
+	if err := models.Users.Restore(1); err != nil {
+		t.Error("failed to restore user:", err)
+	}
+
+	if _, err := models.Users.Get(1); err != nil {
+		t.Error("failed to get restored user:", err)
+	}
+
+	if err := models.Users.Delete(1); err != nil {
+		t.Error("failed to delete user:", err)
+	}
+
+	// purge the deleted user so the token tests can insert the dummy user again
+	if purged, err := models.Users.Purge(0); err != nil || purged != 1 {
+		t.Errorf("expected 1 user purged, got %d: %v", purged, err)
+	}
 }
 
 func TestToken_Table(t *testing.T) {
@@ -267,6 +318,8 @@ func TestToken_Insert(t *testing.T) {
 	if err != nil {
 		t.Error("error insering token: ", err)
 	}
+
+	plainTextToken = token.PlainText
 }
 
 func TestToken_GetUserForToken(t *testing.T) {
@@ -276,12 +329,7 @@ func TestToken_GetUserForToken(t *testing.T) {
 		t.Error("search with invalid token, error expected, none received", err)
 	}
 
-	u, err := models.Users.GetByEmail(dummyUser.Email)
-	if err != nil {
-		t.Error("failed to get user:", err)
-	}
-
-	if _, err := models.Tokens.GetUserForToken(u.Token.PlainText); err != nil {
+	if _, err := models.Tokens.GetUserForToken(plainTextToken); err != nil {
 		t.Error("using a valid token to search for a user, error received:", err)
 	}
 
@@ -315,12 +363,7 @@ func TestToken_Get(t *testing.T) {
 }
 
 func TestToken_GetByToken(t *testing.T) {
-	u, err := models.Users.GetByEmail(dummyUser.Email)
-	if err != nil {
-		t.Error("failed to get user:", err)
-	}
-
-	if _, err := models.Tokens.GetByToken(u.Token.PlainText); err != nil {
+	if _, err := models.Tokens.GetByToken(plainTextToken); err != nil {
 		t.Error("failed to get token data by token:", err)
 	}
 
@@ -346,11 +389,7 @@ func TestToken_AuthenticateToken(t *testing.T) {
 	for _, tt := range authData {
 		token := ""
 		if tt.email == dummyUser.Email {
-			user, err := models.Users.GetByEmail(tt.email)
-			if err != nil {
-				t.Error("failed to get user:", err)
-			}
-			token = user.Token.PlainText
+			token = plainTextToken
 		} else {
 			token = tt.token
 		}
@@ -368,12 +407,7 @@ func TestToken_AuthenticateToken(t *testing.T) {
 }
 
 func TestToken_Delete(t *testing.T) {
-	user, err := models.Users.GetByEmail(dummyUser.Email)
-	if err != nil {
-		t.Error("failed to get user:", err)
-	}
-
-	if err := models.Tokens.DeleteByToken(user.Token.PlainText); err != nil {
+	if err := models.Tokens.DeleteByToken(plainTextToken); err != nil {
 		t.Error("error deleting token:", err)
 	}
 }
@@ -503,8 +537,135 @@ func TestToken_ValidToken(t *testing.T) {
 		t.Error("failed to delete token:", err)
 	}
 
-	ok, err = models.Tokens.ValidToken(u.Token.PlainText)
+	ok, err = models.Tokens.ValidToken(newToken.PlainText)
 	if ok || err == nil {
 		t.Error("using deleted token, passed validation, expected to fail")
 	}
 }
+
+func TestRememberToken_Table(t *testing.T) {
+	s := models.RememberTokens.Table()
+	if s != "remember_tokens" {
+		t.Error("wrong table name returned for remember token")
+	}
+}
+
+func TestRememberToken_Valid(t *testing.T) {
+	u, err := models.Users.GetByEmail(dummyUser.Email)
+	if err != nil {
+		t.Error("failed to get user:", err)
+	}
+
+	token, err := models.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Error("error issuing remember token:", err)
+	}
+
+	if ok, err := models.RememberTokens.Valid(u.ID, token); !ok || err != nil {
+		t.Error("using valid remember token, failed validation, expected to pass", err)
+	}
+
+	if ok, _ := models.RememberTokens.Valid(u.ID+1, token); ok {
+		t.Error("using remember token of another user, passed validation, expected to fail")
+	}
+
+	if ok, _ := models.RememberTokens.Valid(u.ID, "invalidtoken"); ok {
+		t.Error("using invalid remember token, passed validation, expected to fail")
+	}
+}
+
+func TestRememberToken_Rotate(t *testing.T) {
+	u, err := models.Users.GetByEmail(dummyUser.Email)
+	if err != nil {
+		t.Error("failed to get user:", err)
+	}
+
+	token, err := models.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Error("error issuing remember token:", err)
+	}
+
+	newToken, err := models.RememberTokens.Rotate(u.ID, token)
+	if err != nil {
+		t.Error("error rotating remember token:", err)
+	}
+
+	if ok, _ := models.RememberTokens.Valid(u.ID, token); ok {
+		t.Error("using rotated remember token, passed validation, expected to fail")
+	}
+
+	if ok, _ := models.RememberTokens.Valid(u.ID, newToken); !ok {
+		t.Error("using new remember token, failed validation, expected to pass")
+	}
+
+	if _, err := models.RememberTokens.Rotate(u.ID, token); err == nil {
+		t.Error("rotating an already rotated remember token, expected error, received none")
+	}
+}
+
+func TestRememberToken_RevokedOnPasswordReset(t *testing.T) {
+	u, err := models.Users.GetByEmail(dummyUser.Email)
+	if err != nil {
+		t.Error("failed to get user:", err)
+	}
+
+	token, err := models.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Error("error issuing remember token:", err)
+	}
+
+	if err := models.Users.ResetPassword(u.ID, dummyUser.Password); err != nil {
+		t.Error("failed to reset password:", err)
+	}
+
+	if ok, _ := models.RememberTokens.Valid(u.ID, token); ok {
+		t.Error("using remember token after password reset, passed validation, expected to fail")
+	}
+}
+
+func TestModels_WithTx(t *testing.T) {
+	errRollback := errors.New("roll back")
+
+	err := models.WithTx(context.Background(), func(tx Models) error {
+		if _, err := tx.Users.Insert(User{FirstName: "Tx", LastName: "Outer", Email: "outer@tx.com", Password: "kettle-harbor-quilt-42"}); err != nil {
+			return err
+		}
+
+		err := tx.WithTx(context.Background(), func(inner Models) error {
+			if _, err := inner.Users.Insert(User{FirstName: "Tx", LastName: "Inner", Email: "inner@tx.com", Password: "kettle-harbor-quilt-42"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Error("expected the error returned inside the savepoint, got", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error running transaction:", err)
+	}
+
+	if _, err := models.Users.GetByEmail("outer@tx.com"); err != nil {
+		t.Error("user inserted in committed transaction not found:", err)
+	}
+
+	if _, err := models.Users.GetByEmail("inner@tx.com"); err == nil {
+		t.Error("user inserted in rolled back savepoint found")
+	}
+
+	err = models.WithTx(context.Background(), func(tx Models) error {
+		if _, err := tx.Users.Insert(User{FirstName: "Tx", LastName: "Failed", Email: "failed@tx.com", Password: "kettle-harbor-quilt-42"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Error("expected the error returned inside the transaction, got", err)
+	}
+
+	if _, err := models.Users.GetByEmail("failed@tx.com"); err == nil {
+		t.Error("user inserted in rolled back transaction found")
+	}
+}
@@ -0,0 +1,407 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+const (
+	// DefaultPageSize is the page size of a listing that does not ask for one
+	DefaultPageSize = 20
+	// MaxPageSize caps the page size a listing may ask for
+	MaxPageSize = 100
+)
+
+// ErrInvalidListOptions is returned for a listing with an unknown sort or filter field,
+// a malformed filter value or a cursor that does not belong to the listing
+var ErrInvalidListOptions = errors.New("data: invalid list options")
+
+// ListOptions selects a page of a listing. A Cursor taken from a previous page takes
+// precedence over the 1-based PageNumber. Sort names a sortable field, prefixed with "-" for
+// descending order, and Filters maps filterable fields to the value they must equal.
+type ListOptions struct {
+	PageSize   int
+	PageNumber int
+	Cursor     string
+	Sort       string
+	Filters    map[string]string
+}
+
+// Page is one page of a listing. NextCursor and PrevCursor are empty when there is no
+// page in that direction; Total counts every record matching the filters.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// ListOptionsFromQuery reads list options from a query string: page_size, page, cursor and
+// sort, with the parameters named after a filterable field of fields taken as filters. Other
+// parameters, such as a cache buster or the utm_ parameters of a tracked link, are ignored.
+func ListOptionsFromQuery[T any](query url.Values, fields Fields[T]) (ListOptions, error) {
+	opts := ListOptions{
+		Cursor:  query.Get("cursor"),
+		Sort:    query.Get("sort"),
+		Filters: make(map[string]string),
+	}
+
+	for name, target := range map[string]*int{"page_size": &opts.PageSize, "page": &opts.PageNumber} {
+		if query.Get(name) == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(query.Get(name))
+		if err != nil || n < 1 {
+			return ListOptions{}, fmt.Errorf("%w: %s must be a positive number", ErrInvalidListOptions, name)
+		}
+		*target = n
+	}
+
+	for name, field := range fields {
+		if field.Filterable && query.Get(name) != "" {
+			opts.Filters[name] = query.Get(name)
+		}
+	}
+
+	return opts, nil
+}
+
+// FieldKind is the type of the values of a listing field
+type FieldKind int
+
+const (
+	StringField FieldKind = iota
+	IntField
+	TimeField
+)
+
+// Field is a field a listing of T may be sorted or filtered by
+type Field[T any] struct {
+	Column     string
+	Kind       FieldKind
+	Sortable   bool
+	Filterable bool
+	Value      func(T) any
+}
+
+// Fields lists the fields of a listing by the name used in ListOptions. Every listing has
+// an "id" field, which breaks ties between records with the same sort value.
+type Fields[T any] map[string]Field[T]
+
+// UserFields are the fields user listings may be sorted and filtered by
+var UserFields = Fields[*User]{
+	"id":         {Column: "id", Kind: IntField, Sortable: true, Value: func(u *User) any { return u.ID }},
+	"first_name": {Column: "first_name", Kind: StringField, Sortable: true, Filterable: true, Value: func(u *User) any { return u.FirstName }},
+	"last_name":  {Column: "last_name", Kind: StringField, Sortable: true, Filterable: true, Value: func(u *User) any { return u.LastName }},
+	"email":      {Column: "email", Kind: StringField, Sortable: true, Filterable: true, Value: func(u *User) any { return u.Email }},
+	"active":     {Column: "user_active", Kind: IntField, Filterable: true, Value: func(u *User) any { return u.Active }},
+	"created_at": {Column: "created_at", Kind: TimeField, Sortable: true, Value: func(u *User) any { return u.CreatedAt }},
+}
+
+// TokenFields are the fields token listings may be sorted and filtered by
+var TokenFields = Fields[*Token]{
+	"id":         {Column: "id", Kind: IntField, Sortable: true, Value: func(t *Token) any { return t.ID }},
+	"user_id":    {Column: "user_id", Kind: IntField, Filterable: true, Value: func(t *Token) any { return t.UserID }},
+	"email":      {Column: "email", Kind: StringField, Filterable: true, Value: func(t *Token) any { return t.Email }},
+	"created_at": {Column: "created_at", Kind: TimeField, Sortable: true, Value: func(t *Token) any { return t.CreatedAt }},
+	"expiry":     {Column: "expiry", Kind: TimeField, Sortable: true, Value: func(t *Token) any { return t.Expires }},
+}
+
+// cursor is the decoded form of a keyset cursor. It points just past the record with the
+// given sort value and id, in the direction given by Before.
+type cursor struct {
+	Sort   string `json:"s"`
+	Value  string `json:"v"`
+	ID     int    `json:"i"`
+	Before bool   `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// listQuery is a validated ListOptions
+type listQuery[T any] struct {
+	pageSize int
+	offset   int
+	sortName string
+	sort     Field[T]
+	desc     bool
+	filters  map[string]any
+	cursor   *cursor
+	after    any
+}
+
+// query validates opts against the fields, sorting by defaultSort when opts has no sort
+func (f Fields[T]) query(opts ListOptions, defaultSort string) (*listQuery[T], error) {
+	q := &listQuery[T]{pageSize: opts.PageSize, filters: make(map[string]any)}
+
+	switch {
+	case q.pageSize <= 0:
+		q.pageSize = DefaultPageSize
+	case q.pageSize > MaxPageSize:
+		q.pageSize = MaxPageSize
+	}
+
+	q.sortName = opts.Sort
+	if q.sortName == "" {
+		q.sortName = defaultSort
+	}
+
+	q.desc = strings.HasPrefix(q.sortName, "-")
+
+	sortField, ok := f[strings.TrimPrefix(q.sortName, "-")]
+	if !ok || !sortField.Sortable {
+		return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidListOptions, q.sortName)
+	}
+	q.sort = sortField
+
+	for name, value := range opts.Filters {
+		field, ok := f[name]
+		if !ok || !field.Filterable {
+			return nil, fmt.Errorf("%w: cannot filter by %q", ErrInvalidListOptions, name)
+		}
+
+		v, err := parseFieldValue(field.Kind, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad value for %s: %v", ErrInvalidListOptions, name, err)
+		}
+		q.filters[name] = v
+	}
+
+	if opts.Cursor != "" {
+		js, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+		}
+
+		var c cursor
+		if err := json.Unmarshal(js, &c); err != nil || c.Sort != q.sortName {
+			return nil, fmt.Errorf("%w: cursor does not belong to this listing", ErrInvalidListOptions)
+		}
+
+		if q.after, err = parseFieldValue(q.sort.Kind, c.Value); err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+		}
+		q.cursor = &c
+	} else if opts.PageNumber > 1 {
+		q.offset = (opts.PageNumber - 1) * q.pageSize
+	}
+
+	return q, nil
+}
+
+// ascending reports whether records are fetched in ascending order, which is reversed
+// when paging backwards
+func (q *listQuery[T]) ascending() bool {
+	return q.desc == (q.cursor != nil && q.cursor.Before)
+}
+
+// page trims the records fetched for q, which include one extra record when there are more
+// in the direction of travel, and builds the page with its cursors
+func (q *listQuery[T]) page(fields Fields[T], items []T, total int) *Page[T] {
+	more := len(items) > q.pageSize
+	if more {
+		items = items[:q.pageSize]
+	}
+
+	backwards := q.cursor != nil && q.cursor.Before
+	if backwards {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	p := &Page[T]{Items: items, Total: total, PageSize: q.pageSize}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+
+	if len(items) == 0 {
+		return p
+	}
+
+	if more || backwards {
+		p.NextCursor = q.cursorFor(fields, items[len(items)-1], false)
+	}
+
+	if (backwards && more) || (!backwards && (q.cursor != nil || q.offset > 0)) {
+		p.PrevCursor = q.cursorFor(fields, items[0], true)
+	}
+
+	return p
+}
+
+func (q *listQuery[T]) cursorFor(fields Fields[T], item T, before bool) string {
+	return cursor{
+		Sort:   q.sortName,
+		Value:  formatFieldValue(q.sort.Value(item)),
+		ID:     fields["id"].Value(item).(int),
+		Before: before,
+	}.encode()
+}
+
+// listCollection returns the page of the records of collection matching base selected by opts
+func listCollection[T any](collection up.Collection, base up.LogicalExpr, fields Fields[T], opts ListOptions, defaultSort string) (*Page[T], error) {
+	q, err := fields.query(opts, defaultSort)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := up.Cond{}
+	for name, value := range q.filters {
+		filters[fields[name].Column] = value
+	}
+
+	total, err := collection.Find(base, filters).Count()
+	if err != nil {
+		return nil, err
+	}
+
+	op, order := " >", ""
+	if !q.ascending() {
+		op, order = " <", "-"
+	}
+
+	conditions := []up.LogicalExpr{base, filters}
+	if q.cursor != nil {
+		if q.sort.Column == "id" {
+			conditions = append(conditions, up.Cond{"id" + op: q.cursor.ID})
+		} else {
+			conditions = append(conditions, up.Or(
+				up.Cond{q.sort.Column + op: q.after},
+				up.And(up.Cond{q.sort.Column: q.after}, up.Cond{"id" + op: q.cursor.ID}),
+			))
+		}
+	}
+
+	var items []T
+
+	res := collection.Find(up.And(conditions...)).
+		OrderBy(order+q.sort.Column, order+"id").
+		Limit(q.pageSize + 1).
+		Offset(q.offset)
+	if err := res.All(&items); err != nil {
+		return nil, err
+	}
+
+	return q.page(fields, items, int(total)), nil
+}
+
+// ListSlice returns the page of items selected by opts, applying the same rules as the SQL
+// listings. It lets other implementations of the repositories offer the same listings.
+func ListSlice[T any](items []T, fields Fields[T], opts ListOptions, defaultSort string) (*Page[T], error) {
+	q, err := fields.query(opts, defaultSort)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []T
+
+	for _, item := range items {
+		matches := true
+		for name, value := range q.filters {
+			if compareFieldValues(fields[name].Value(item), value) != 0 {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			matching = append(matching, item)
+		}
+	}
+
+	id := fields["id"].Value
+	ascending := q.ascending()
+
+	// compare orders a before b in the order of the query
+	compare := func(a T, aValue any, bValue any, bID int) int {
+		c := compareFieldValues(aValue, bValue)
+		if c == 0 {
+			c = compareFieldValues(id(a), bID)
+		}
+		if !ascending {
+			c = -c
+		}
+		return c
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		return compare(matching[i], q.sort.Value(matching[i]), q.sort.Value(matching[j]), id(matching[j]).(int)) < 0
+	})
+
+	selected := matching
+	if q.cursor != nil {
+		selected = nil
+		for _, item := range matching {
+			if compare(item, q.sort.Value(item), q.after, q.cursor.ID) > 0 {
+				selected = append(selected, item)
+			}
+		}
+	}
+
+	if q.offset >= len(selected) {
+		selected = nil
+	} else {
+		selected = selected[q.offset:]
+	}
+
+	if len(selected) > q.pageSize+1 {
+		selected = selected[:q.pageSize+1]
+	}
+
+	return q.page(fields, append([]T(nil), selected...), len(matching)), nil
+}
+
+func parseFieldValue(kind FieldKind, value string) (any, error) {
+	switch kind {
+	case IntField:
+		return strconv.Atoi(value)
+	case TimeField:
+		return time.Parse(time.RFC3339Nano, value)
+	default:
+		return value, nil
+	}
+}
+
+func formatFieldValue(value any) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compareFieldValues compares two values of the same field kind
+func compareFieldValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		b := b.(int)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestListOptionsFromQuery(t *testing.T) {
+	query, _ := url.ParseQuery("page_size=10&page=2&cursor=abc&sort=-email&last_name=Smith&active=&id=3&utm_source=mail&_=1712")
+
+	opts, err := ListOptionsFromQuery(query, UserFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.PageSize != 10 || opts.PageNumber != 2 || opts.Cursor != "abc" || opts.Sort != "-email" {
+		t.Errorf("wrong options read from query: %+v", opts)
+	}
+
+	if len(opts.Filters) != 1 || opts.Filters["last_name"] != "Smith" {
+		t.Errorf("wrong filters read from query: %v", opts.Filters)
+	}
+
+	for _, raw := range []string{"page_size=ten", "page=-1", "page_size=0", "page=0"} {
+		query, _ := url.ParseQuery(raw)
+		if _, err := ListOptionsFromQuery(query, UserFields); !errors.Is(err, ErrInvalidListOptions) {
+			t.Errorf("%s: expected ErrInvalidListOptions, got %v", raw, err)
+		}
+	}
+}
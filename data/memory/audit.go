@@ -0,0 +1,27 @@
+package memory
+
+import "myapp/data"
+
+// auditEventRepository is the in-memory implementation of data.AuditEventRepository
+type auditEventRepository struct {
+	session
+}
+
+func (r *auditEventRepository) Table() string {
+	return "audit_events"
+}
+
+func (r *auditEventRepository) List(opts data.ListOptions) (*data.Page[*data.AuditEvent], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	events := make([]*data.AuditEvent, 0, len(r.s.auditEvents))
+	for _, event := range r.s.auditEvents {
+		if r.tenant == 0 || event.OrganizationID == r.tenant {
+			event := event
+			events = append(events, &event)
+		}
+	}
+
+	return data.ListSlice(events, data.AuditFields, opts, "-id")
+}
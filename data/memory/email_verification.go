@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"myapp/data"
+	"time"
+)
+
+// emailVerificationRepository is the in-memory implementation of data.EmailVerificationRepository
+type emailVerificationRepository struct {
+	session
+}
+
+func (r *emailVerificationRepository) Table() string {
+	return "email_verifications"
+}
+
+func (r *emailVerificationRepository) Issue(userID int) (string, error) {
+	return r.issue(userID, "")
+}
+
+func (r *emailVerificationRepository) IssueWithPassword(userID int, passwordHash string) (string, error) {
+	return r.issue(userID, passwordHash)
+}
+
+// issue stores a new token for the user that sets their password to passwordHash when it is
+// verified, unless that is empty, and returns its plain text
+func (r *emailVerificationRepository) issue(userID int, passwordHash string) (string, error) {
+	plainText, err := data.RandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if u, ok := r.s.users[userID]; !ok || u.Deleted() {
+		return "", data.ErrNotFound
+	}
+
+	for id, verification := range r.s.emailVerifications {
+		if verification.UserID != userID {
+			continue
+		}
+		if verification.Throttled() {
+			return "", data.ErrThrottled
+		}
+		delete(r.s.emailVerifications, id)
+	}
+
+	verification := data.EmailVerification{
+		ID:           r.s.nextID(r.Table()),
+		UserID:       userID,
+		TokenHash:    data.HashEmailVerification(plainText),
+		PasswordHash: passwordHash,
+		Expires:      time.Now().Add(data.EmailVerificationLifetime),
+		CreatedAt:    time.Now(),
+	}
+	r.s.emailVerifications[verification.ID] = verification
+
+	return plainText, nil
+}
+
+func (r *emailVerificationRepository) Verify(plainText string) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	hash := data.HashEmailVerification(plainText)
+
+	for id, verification := range r.s.emailVerifications {
+		if verification.TokenHash != hash {
+			continue
+		}
+
+		if verification.Expired() {
+			return 0, data.ErrExpiredToken
+		}
+
+		u, ok := r.s.users[verification.UserID]
+		if !ok || u.Deleted() {
+			return 0, data.ErrInvalidToken
+		}
+
+		delete(r.s.emailVerifications, id)
+
+		if u.Active == 1 {
+			return u.ID, nil
+		}
+
+		for tokenID, token := range r.s.tokens {
+			if token.UserID == u.ID {
+				delete(r.s.tokens, tokenID)
+				r.record(data.AuditDelete, "tokens", tokenID, token, nil)
+			}
+		}
+		r.deleteRememberTokens(u.ID)
+
+		before := u
+		u.Active = 1
+		u.UpdatedAt = time.Now()
+		u.Version++
+		if verification.PasswordHash != "" {
+			u.Password = verification.PasswordHash
+		}
+		r.s.users[u.ID] = u
+		r.record(data.AuditUpdate, "users", u.ID, before, u)
+
+		return u.ID, nil
+	}
+
+	return 0, data.ErrInvalidToken
+}
@@ -0,0 +1,206 @@
+// Package memory provides thread-safe in-memory implementations of the data repositories.
+// They behave like the SQL models, including cascading deletes, so handler and middleware
+// tests can use them in place of a database.
+package memory
+
+import (
+	"context"
+	"errors"
+	"myapp/data"
+	"sync"
+)
+
+// ErrDuplicate is returned when an insert would violate a unique column
+var ErrDuplicate = errors.New("memory: duplicate key")
+
+// store holds every table; the repositories share it so deletes can cascade
+type store struct {
+	mu                 sync.RWMutex
+	lastID             map[string]int
+	users              map[int]data.User
+	tokens             map[int]data.Token
+	rememberTokens     map[int]data.RememberToken
+	auditEvents        map[int]data.AuditEvent
+	organizations      map[int]data.Organization
+	memberships        map[int]data.Membership
+	roles              map[int]data.Role
+	permissions        map[int]data.Permission
+	grants             map[grant]bool
+	assignments        map[assignment]bool
+	emailVerifications map[int]data.EmailVerification
+	userIdentities     map[int]data.UserIdentity
+}
+
+// New returns models backed by a new, empty in-memory store
+func New() data.Models {
+	s := &store{
+		lastID:             make(map[string]int),
+		users:              make(map[int]data.User),
+		tokens:             make(map[int]data.Token),
+		rememberTokens:     make(map[int]data.RememberToken),
+		auditEvents:        make(map[int]data.AuditEvent),
+		organizations:      make(map[int]data.Organization),
+		memberships:        make(map[int]data.Membership),
+		roles:              make(map[int]data.Role),
+		permissions:        make(map[int]data.Permission),
+		grants:             make(map[grant]bool),
+		assignments:        make(map[assignment]bool),
+		emailVerifications: make(map[int]data.EmailVerification),
+		userIdentities:     make(map[int]data.UserIdentity),
+	}
+
+	return session{s: s}.models()
+}
+
+// session is the store as seen by one actor, whose changes it records in the audit log, and
+// scoped to the organization with the id tenant unless that is 0
+type session struct {
+	s      *store
+	actor  data.Actor
+	tenant int
+}
+
+func (b session) models() data.Models {
+	return data.Models{
+		Users:              &userRepository{b},
+		Tokens:             &tokenRepository{b},
+		RememberTokens:     &rememberTokenRepository{b},
+		AuditEvents:        &auditEventRepository{b},
+		Organizations:      &organizationRepository{b},
+		Roles:              &roleRepository{b},
+		EmailVerifications: &emailVerificationRepository{b},
+		UserIdentities:     &userIdentityRepository{b},
+		Backend:            b,
+	}
+}
+
+// WithContext returns models recording the actor in ctx as the author of their changes and
+// scoped to the tenant in ctx
+func (b session) WithContext(ctx context.Context) data.Models {
+	if actor, ok := data.ActorFrom(ctx); ok {
+		b.actor = actor
+	}
+	if tenant, ok := data.TenantFrom(ctx); ok {
+		b.tenant = tenant
+	}
+
+	return b.models()
+}
+
+func (s *store) nextID(table string) int {
+	s.lastID[table]++
+	return s.lastID[table]
+}
+
+// latestToken returns the most recently created unexpired token of the user. The caller must hold the lock.
+func (s *store) latestToken(userID int) data.Token {
+	var latest data.Token
+
+	for _, token := range s.tokens {
+		if token.UserID != userID || token.Expired() {
+			continue
+		}
+		if latest.ID == 0 || token.CreatedAt.After(latest.CreatedAt) ||
+			(token.CreatedAt.Equal(latest.CreatedAt) && token.ID > latest.ID) {
+			latest = token
+		}
+	}
+
+	return latest
+}
+
+// member reports whether the user with the given id belongs to the tenant of the session,
+// which every user does when there is none and nobody does for data.NoOrganization. The
+// caller must hold the lock.
+func (b session) member(userID int) bool {
+	if b.tenant == 0 {
+		return true
+	}
+
+	_, ok := b.s.membership(b.tenant, userID)
+	return ok
+}
+
+// activeUser returns the user with the given id unless they do not exist, were soft deleted
+// or are outside the tenant. The caller must hold the lock.
+func (b session) activeUser(id int) (data.User, bool) {
+	u, ok := b.s.users[id]
+	if !ok || u.Deleted() || !b.member(id) {
+		return data.User{}, false
+	}
+
+	return u, true
+}
+
+// usersWhere returns copies of the users in the tenant that are soft deleted or not, as
+// deleted says. The caller must hold the lock.
+func (b session) usersWhere(deleted bool) []*data.User {
+	users := make([]*data.User, 0, len(b.s.users))
+	for _, u := range b.s.users {
+		if u.Deleted() == deleted && b.member(u.ID) {
+			u := u
+			users = append(users, &u)
+		}
+	}
+
+	return users
+}
+
+// deleteUser removes a user and everything referencing them. The caller must hold the lock.
+func (s *store) deleteUser(id int) {
+	delete(s.users, id)
+
+	for tokenID, token := range s.tokens {
+		if token.UserID == id {
+			delete(s.tokens, tokenID)
+		}
+	}
+
+	s.deleteRememberTokens(id)
+
+	for membershipID, membership := range s.memberships {
+		if membership.UserID == id {
+			delete(s.memberships, membershipID)
+		}
+	}
+
+	for a := range s.assignments {
+		if a.UserID == id {
+			delete(s.assignments, a)
+		}
+	}
+
+	for verificationID, verification := range s.emailVerifications {
+		if verification.UserID == id {
+			delete(s.emailVerifications, verificationID)
+		}
+	}
+
+	for identityID, identity := range s.userIdentities {
+		if identity.UserID == id {
+			delete(s.userIdentities, identityID)
+		}
+	}
+}
+
+// deleteRememberTokens removes the remember tokens of a user. The caller must hold the lock.
+func (s *store) deleteRememberTokens(userID int) {
+	for id, token := range s.rememberTokens {
+		if token.UserID == userID {
+			delete(s.rememberTokens, id)
+		}
+	}
+}
+
+// record stores an audit event for a change made through the session. The caller must hold the lock.
+func (b session) record(action, entity string, entityID int, before, after any) {
+	event, err := data.NewAuditEvent(b.actor, action, entity, entityID, before, after)
+	if err != nil {
+		// the records are plain structs, so encoding their changes cannot fail
+		panic(err)
+	}
+
+	event.ID = b.s.nextID("audit_events")
+	event.OrganizationID = b.tenant
+	b.s.auditEvents[event.ID] = event
+}
@@ -0,0 +1,51 @@
+package memory_test
+
+import (
+	"fmt"
+	"myapp/data"
+	"myapp/data/datatest"
+	"myapp/data/memory"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	// hash the passwords the suite stores quickly
+	data.SetPasswordHasher(data.BcryptHasher{Cost: bcrypt.MinCost})
+}
+
+func TestMemory(t *testing.T) {
+	datatest.Run(t, func(t *testing.T) data.Models { return memory.New() })
+}
+
+func TestMemory_Concurrent(t *testing.T) {
+	m := memory.New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id, err := m.Users.Insert(data.User{Email: fmt.Sprintf("user%d@test.com", i), Password: "kettle-harbor-quilt-42"})
+			if err != nil {
+				t.Error("failed to insert new user record:", err)
+				return
+			}
+			if _, err := m.RememberTokens.Issue(id); err != nil {
+				t.Error("error issuing remember token:", err)
+			}
+			if _, err := m.Users.GetAll(); err != nil {
+				t.Error("failed to get all users:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all, _ := m.Users.GetAll()
+	if len(all) != 10 {
+		t.Errorf("expected 10 users, got %d", len(all))
+	}
+}
@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"myapp/data"
+	"sort"
+	"time"
+)
+
+// organizationRepository is the in-memory implementation of data.OrganizationRepository
+type organizationRepository struct {
+	session
+}
+
+func (r *organizationRepository) Table() string {
+	return "organizations"
+}
+
+func (r *organizationRepository) Get(id int) (*data.Organization, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	org, ok := r.s.organizations[id]
+	if !ok {
+		return nil, data.ErrNotFound
+	}
+
+	return &org, nil
+}
+
+func (r *organizationRepository) GetBySlug(slug string) (*data.Organization, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, org := range r.s.organizations {
+		if org.Slug == slug {
+			return &org, nil
+		}
+	}
+
+	return nil, data.ErrNotFound
+}
+
+func (r *organizationRepository) ForUser(userID int) ([]*data.Organization, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var orgs []*data.Organization
+	for _, membership := range r.s.memberships {
+		if membership.UserID == userID {
+			org := r.s.organizations[membership.OrganizationID]
+			orgs = append(orgs, &org)
+		}
+	}
+
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].ID < orgs[j].ID
+	})
+
+	return orgs, nil
+}
+
+func (r *organizationRepository) Insert(org data.Organization) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.organizations {
+		if existing.Slug == org.Slug {
+			return 0, ErrDuplicate
+		}
+	}
+
+	org.ID = r.s.nextID(r.Table())
+	org.CreatedAt = time.Now()
+	org.UpdatedAt = time.Now()
+	r.s.organizations[org.ID] = org
+	r.record(data.AuditInsert, r.Table(), org.ID, nil, org)
+
+	return org.ID, nil
+}
+
+func (r *organizationRepository) Delete(id int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	org, ok := r.s.organizations[id]
+	if !ok {
+		return nil
+	}
+
+	for membershipID, membership := range r.s.memberships {
+		if membership.OrganizationID == id {
+			delete(r.s.memberships, membershipID)
+			r.record(data.AuditDelete, "memberships", membershipID, membership, nil)
+		}
+	}
+
+	r.deleteAssignments(func(a assignment) bool { return a.OrganizationID == id })
+
+	delete(r.s.organizations, id)
+	r.record(data.AuditDelete, r.Table(), id, org, nil)
+
+	return nil
+}
+
+func (r *organizationRepository) AddMember(organizationID, userID int, role string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.organizations[organizationID]; !ok {
+		return data.ErrNotFound
+	}
+	if _, ok := r.s.users[userID]; !ok {
+		return data.ErrNotFound
+	}
+	if _, ok := r.s.membership(organizationID, userID); ok {
+		return ErrDuplicate
+	}
+
+	r.addMember(organizationID, userID, role)
+
+	return nil
+}
+
+func (r *organizationRepository) RemoveMember(organizationID, userID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.deleteAssignments(func(a assignment) bool {
+		return a.OrganizationID == organizationID && a.UserID == userID
+	})
+
+	if membership, ok := r.s.membership(organizationID, userID); ok {
+		delete(r.s.memberships, membership.ID)
+		r.record(data.AuditDelete, "memberships", membership.ID, membership, nil)
+	}
+
+	return nil
+}
+
+func (r *organizationRepository) Membership(organizationID, userID int) (*data.Membership, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	membership, ok := r.s.membership(organizationID, userID)
+	if !ok {
+		return nil, data.ErrNotFound
+	}
+
+	return &membership, nil
+}
+
+// membership finds the membership of a user in an organization. The caller must hold the lock.
+func (s *store) membership(organizationID, userID int) (data.Membership, bool) {
+	for _, membership := range s.memberships {
+		if membership.OrganizationID == organizationID && membership.UserID == userID {
+			return membership, true
+		}
+	}
+
+	return data.Membership{}, false
+}
+
+// addMember puts a user in an organization. The caller must hold the lock.
+func (b session) addMember(organizationID, userID int, role string) {
+	membership := data.Membership{
+		ID:             b.s.nextID("memberships"),
+		OrganizationID: organizationID,
+		UserID:         userID,
+		Role:           role,
+		CreatedAt:      time.Now(),
+	}
+	b.s.memberships[membership.ID] = membership
+	b.record(data.AuditInsert, "memberships", membership.ID, nil, membership)
+}
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"myapp/data"
+	"time"
+)
+
+// rememberTokenRepository is the in-memory implementation of data.RememberTokenRepository
+type rememberTokenRepository struct {
+	session
+}
+
+func (r *rememberTokenRepository) Table() string {
+	return "remember_tokens"
+}
+
+func (r *rememberTokenRepository) Issue(userID int) (string, error) {
+	plainText, err := data.NewRememberToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[userID]; !ok {
+		return "", data.ErrNotFound
+	}
+
+	token := data.RememberToken{
+		ID:            r.s.nextID(r.Table()),
+		UserID:        userID,
+		RememberToken: data.HashRememberToken(plainText),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	r.s.rememberTokens[token.ID] = token
+	r.record(data.AuditInsert, r.Table(), token.ID, nil, token)
+
+	return plainText, nil
+}
+
+func (r *rememberTokenRepository) Valid(userID int, plainText string) (bool, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	token, ok := r.s.rememberTokenByHash(data.HashRememberToken(plainText))
+
+	return ok && token.UserID == userID && !token.Expired(), nil
+}
+
+func (r *rememberTokenRepository) Rotate(userID int, plainText string) (string, error) {
+	newPlainText, err := data.NewRememberToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	token, ok := r.s.rememberTokenByHash(data.HashRememberToken(plainText))
+	if !ok || token.UserID != userID || token.Expired() {
+		return "", data.ErrInvalidToken
+	}
+
+	delete(r.s.rememberTokens, token.ID)
+	r.record(data.AuditDelete, r.Table(), token.ID, token, nil)
+
+	rotated := data.RememberToken{
+		ID:            r.s.nextID(r.Table()),
+		UserID:        userID,
+		RememberToken: data.HashRememberToken(newPlainText),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	r.s.rememberTokens[rotated.ID] = rotated
+	r.record(data.AuditInsert, r.Table(), rotated.ID, nil, rotated)
+
+	return newPlainText, nil
+}
+
+func (r *rememberTokenRepository) Delete(plainText string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if token, ok := r.s.rememberTokenByHash(data.HashRememberToken(plainText)); ok {
+		delete(r.s.rememberTokens, token.ID)
+		r.record(data.AuditDelete, r.Table(), token.ID, token, nil)
+	}
+
+	return nil
+}
+
+func (r *rememberTokenRepository) DeleteForUser(userID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.deleteRememberTokens(userID)
+
+	return nil
+}
+
+// deleteRememberTokens removes every remember token of the user, recording an audit event for
+// each of them. The caller must hold the lock.
+func (b session) deleteRememberTokens(userID int) {
+	for id, token := range b.s.rememberTokens {
+		if token.UserID == userID {
+			delete(b.s.rememberTokens, id)
+			b.record(data.AuditDelete, "remember_tokens", id, token, nil)
+		}
+	}
+}
+
+// rememberTokenByHash finds a remember token by its hash. The caller must hold the lock.
+func (s *store) rememberTokenByHash(hash string) (data.RememberToken, bool) {
+	for _, token := range s.rememberTokens {
+		if token.RememberToken == hash {
+			return token, true
+		}
+	}
+
+	return data.RememberToken{}, false
+}
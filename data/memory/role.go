@@ -0,0 +1,261 @@
+package memory
+
+import (
+	"myapp/data"
+	"sort"
+	"time"
+)
+
+// grant is a row of the role_permissions table
+type grant struct {
+	RoleID       int `db:"role_id"`
+	PermissionID int `db:"permission_id"`
+}
+
+// assignment is a row of the user_roles table. Organization 0 holds the roles assigned
+// outside of any organization.
+type assignment struct {
+	UserID         int `db:"user_id"`
+	RoleID         int `db:"role_id"`
+	OrganizationID int `db:"organization_id"`
+}
+
+// roleRepository is the in-memory implementation of data.RoleRepository
+type roleRepository struct {
+	session
+}
+
+func (r *roleRepository) Table() string {
+	return "roles"
+}
+
+func (r *roleRepository) Get(id int) (*data.Role, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	role, ok := r.s.roles[id]
+	if !ok {
+		return nil, data.ErrNotFound
+	}
+
+	return &role, nil
+}
+
+func (r *roleRepository) GetByName(name string) (*data.Role, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, role := range r.s.roles {
+		if role.Name == name {
+			return &role, nil
+		}
+	}
+
+	return nil, data.ErrNotFound
+}
+
+func (r *roleRepository) GetAll() ([]*data.Role, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	roles := make([]*data.Role, 0, len(r.s.roles))
+	for _, role := range r.s.roles {
+		role := role
+		roles = append(roles, &role)
+	}
+
+	sortRoles(roles)
+
+	return roles, nil
+}
+
+func (r *roleRepository) Insert(role data.Role) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.roles {
+		if existing.Name == role.Name {
+			return 0, ErrDuplicate
+		}
+	}
+
+	role.ID = r.s.nextID(r.Table())
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = time.Now()
+	r.s.roles[role.ID] = role
+	r.record(data.AuditInsert, r.Table(), role.ID, nil, role)
+
+	return role.ID, nil
+}
+
+func (r *roleRepository) Delete(id int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	role, ok := r.s.roles[id]
+	if !ok {
+		return nil
+	}
+
+	for g := range r.s.grants {
+		if g.RoleID == id {
+			delete(r.s.grants, g)
+			r.record(data.AuditDelete, "role_permissions", g.RoleID, g, nil)
+		}
+	}
+
+	r.deleteAssignments(func(a assignment) bool { return a.RoleID == id })
+
+	delete(r.s.roles, id)
+	r.record(data.AuditDelete, r.Table(), id, role, nil)
+
+	return nil
+}
+
+func (r *roleRepository) Grant(roleID int, permission string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.roles[roleID]; !ok {
+		return data.ErrNotFound
+	}
+
+	p, ok := r.s.permissionByName(permission)
+	if !ok {
+		p = data.Permission{ID: r.s.nextID("permissions"), Name: permission, CreatedAt: time.Now()}
+		r.s.permissions[p.ID] = p
+	}
+
+	g := grant{RoleID: roleID, PermissionID: p.ID}
+	if !r.s.grants[g] {
+		r.s.grants[g] = true
+		r.record(data.AuditInsert, "role_permissions", roleID, nil, g)
+	}
+
+	return nil
+}
+
+func (r *roleRepository) Revoke(roleID int, permission string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if p, ok := r.s.permissionByName(permission); ok {
+		g := grant{RoleID: roleID, PermissionID: p.ID}
+		if r.s.grants[g] {
+			delete(r.s.grants, g)
+			r.record(data.AuditDelete, "role_permissions", roleID, g, nil)
+		}
+	}
+
+	return nil
+}
+
+func (r *roleRepository) Assign(userID, roleID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.activeUser(userID); !ok {
+		return data.ErrNotFound
+	}
+	if _, ok := r.s.roles[roleID]; !ok {
+		return data.ErrNotFound
+	}
+
+	a := assignment{UserID: userID, RoleID: roleID, OrganizationID: r.tenant}
+	if !r.s.assignments[a] {
+		r.s.assignments[a] = true
+		r.record(data.AuditInsert, "user_roles", userID, nil, a)
+	}
+
+	return nil
+}
+
+func (r *roleRepository) Unassign(userID, roleID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.deleteAssignments(func(a assignment) bool {
+		return a.UserID == userID && a.RoleID == roleID && a.OrganizationID == r.tenant
+	})
+
+	return nil
+}
+
+func (r *roleRepository) ForUser(userID int) ([]*data.Role, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var roles []*data.Role
+	if !r.member(userID) {
+		return roles, nil
+	}
+
+	for a := range r.s.assignments {
+		if a.UserID == userID && a.OrganizationID == r.tenant {
+			role := r.s.roles[a.RoleID]
+			roles = append(roles, &role)
+		}
+	}
+
+	sortRoles(roles)
+
+	return roles, nil
+}
+
+func (r *roleRepository) PermissionsForUser(userID int) ([]string, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	names := []string{}
+	if !r.member(userID) {
+		return names, nil
+	}
+
+	seen := make(map[int]bool)
+	for a := range r.s.assignments {
+		if a.UserID != userID || a.OrganizationID != r.tenant {
+			continue
+		}
+		for g := range r.s.grants {
+			if g.RoleID == a.RoleID && !seen[g.PermissionID] {
+				seen[g.PermissionID] = true
+				names = append(names, r.s.permissions[g.PermissionID].Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// deleteAssignments removes the assignments matching, recording an audit event for each of
+// them. The caller must hold the lock.
+func (b session) deleteAssignments(matching func(a assignment) bool) {
+	for a := range b.s.assignments {
+		if matching(a) {
+			delete(b.s.assignments, a)
+			b.record(data.AuditDelete, "user_roles", a.UserID, a, nil)
+		}
+	}
+}
+
+// permissionByName finds a permission by its name. The caller must hold the lock.
+func (s *store) permissionByName(name string) (data.Permission, bool) {
+	for _, p := range s.permissions {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return data.Permission{}, false
+}
+
+func sortRoles(roles []*data.Role) {
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Name != roles[j].Name {
+			return roles[i].Name < roles[j].Name
+		}
+		return roles[i].ID < roles[j].ID
+	})
+}
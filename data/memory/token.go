@@ -0,0 +1,202 @@
+package memory
+
+import (
+	"bytes"
+	"myapp/data"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// tokenRepository is the in-memory implementation of data.TokenRepository
+type tokenRepository struct {
+	session
+}
+
+func (r *tokenRepository) Table() string {
+	return "tokens"
+}
+
+func (r *tokenRepository) GetUserForToken(plainText string) (*data.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	token, ok := r.s.tokenByHash(data.HashToken(plainText))
+	if !ok {
+		return nil, data.ErrInvalidToken
+	}
+
+	u, ok := r.activeUser(token.UserID)
+	if !ok {
+		return nil, data.ErrInvalidToken
+	}
+
+	token.PlainText = plainText
+	u.Token = token
+
+	return &u, nil
+}
+
+func (r *tokenRepository) GetTokensForUser(id int) ([]*data.Token, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var tokens []*data.Token
+	for _, token := range r.s.tokens {
+		if token.UserID == id && r.member(id) {
+			token := token
+			tokens = append(tokens, &token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID > tokens[j].ID
+	})
+
+	return tokens, nil
+}
+
+func (r *tokenRepository) List(opts data.ListOptions) (*data.Page[*data.Token], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	tokens := make([]*data.Token, 0, len(r.s.tokens))
+	for _, token := range r.s.tokens {
+		if r.member(token.UserID) {
+			token := token
+			tokens = append(tokens, &token)
+		}
+	}
+
+	return data.ListSlice(tokens, data.TokenFields, opts, "-created_at")
+}
+
+func (r *tokenRepository) Get(id int) (*data.Token, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	token, ok := r.s.tokens[id]
+	if !ok || !r.member(token.UserID) {
+		return nil, data.ErrNotFound
+	}
+
+	return &token, nil
+}
+
+func (r *tokenRepository) GetByToken(plainText string) (*data.Token, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	token, ok := r.s.tokenByHash(data.HashToken(plainText))
+	if !ok || !r.member(token.UserID) {
+		return nil, data.ErrInvalidToken
+	}
+
+	return &token, nil
+}
+
+func (r *tokenRepository) Delete(id int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if token, ok := r.s.tokens[id]; ok && r.member(token.UserID) {
+		delete(r.s.tokens, id)
+		r.record(data.AuditDelete, r.Table(), id, token, nil)
+	}
+
+	return nil
+}
+
+func (r *tokenRepository) DeleteByToken(plainText string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if token, ok := r.s.tokenByHash(data.HashToken(plainText)); ok && r.member(token.UserID) {
+		delete(r.s.tokens, token.ID)
+		r.record(data.AuditDelete, r.Table(), token.ID, token, nil)
+	}
+
+	return nil
+}
+
+func (r *tokenRepository) Insert(token data.Token, u data.User) error {
+	if token.UserID == 0 {
+		token.UserID = u.ID
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[token.UserID]; !ok || !r.member(token.UserID) {
+		return data.ErrNotFound
+	}
+
+	if _, ok := r.s.tokenByHash(token.Hash); ok {
+		return ErrDuplicate
+	}
+
+	token.ID = r.s.nextID(r.Table())
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = time.Now()
+	token.FirstName = u.FirstName
+	token.Email = u.Email
+	token.PlainText = ""
+	token.Hash = bytes.Clone(token.Hash)
+	r.s.tokens[token.ID] = token
+	r.record(data.AuditInsert, r.Table(), token.ID, nil, token)
+
+	return nil
+}
+
+func (r *tokenRepository) GenerateToken(userID int, ttl time.Duration) (*data.Token, error) {
+	return data.NewToken(userID, ttl)
+}
+
+func (r *tokenRepository) AuthenticateToken(req *http.Request) (*data.User, error) {
+	plainText, err := data.BearerToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := r.GetUserForToken(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Token.Expired() {
+		return nil, data.ErrExpiredToken
+	}
+
+	return u, nil
+}
+
+func (r *tokenRepository) ValidToken(plainText string) (bool, error) {
+	if !data.ValidTokenFormat(plainText) {
+		return false, data.ErrInvalidToken
+	}
+
+	u, err := r.GetUserForToken(plainText)
+	if err != nil {
+		return false, err
+	}
+
+	if u.Token.Expired() {
+		return false, data.ErrExpiredToken
+	}
+
+	return true, nil
+}
+
+// tokenByHash finds a token by its hash. The caller must hold the lock.
+func (s *store) tokenByHash(hash []byte) (data.Token, bool) {
+	for _, token := range s.tokens {
+		if bytes.Equal(token.Hash, hash) {
+			return token, true
+		}
+	}
+
+	return data.Token{}, false
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"myapp/data"
+)
+
+// snapshot is a copy of every table, taken when a transaction starts
+type snapshot struct {
+	lastID             map[string]int
+	users              map[int]data.User
+	tokens             map[int]data.Token
+	rememberTokens     map[int]data.RememberToken
+	auditEvents        map[int]data.AuditEvent
+	organizations      map[int]data.Organization
+	memberships        map[int]data.Membership
+	roles              map[int]data.Role
+	permissions        map[int]data.Permission
+	grants             map[grant]bool
+	assignments        map[assignment]bool
+	emailVerifications map[int]data.EmailVerification
+	userIdentities     map[int]data.UserIdentity
+}
+
+// WithTx runs fn and puts the store back the way it was if fn returns an error or panics.
+// Unlike a database transaction it does not hide the changes of fn from concurrent callers.
+func (b session) WithTx(ctx context.Context, fn func(tx data.Models) error) error {
+	s := b.s
+	before := s.snapshot()
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.restore(before)
+			panic(p)
+		}
+	}()
+
+	if err := fn(b.models()); err != nil {
+		s.restore(before)
+		return err
+	}
+
+	return nil
+}
+
+func (s *store) snapshot() snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return snapshot{
+		lastID:             maps.Clone(s.lastID),
+		users:              maps.Clone(s.users),
+		tokens:             maps.Clone(s.tokens),
+		rememberTokens:     maps.Clone(s.rememberTokens),
+		auditEvents:        maps.Clone(s.auditEvents),
+		organizations:      maps.Clone(s.organizations),
+		memberships:        maps.Clone(s.memberships),
+		roles:              maps.Clone(s.roles),
+		permissions:        maps.Clone(s.permissions),
+		grants:             maps.Clone(s.grants),
+		assignments:        maps.Clone(s.assignments),
+		emailVerifications: maps.Clone(s.emailVerifications),
+		userIdentities:     maps.Clone(s.userIdentities),
+	}
+}
+
+func (s *store) restore(before snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID = before.lastID
+	s.users = before.users
+	s.tokens = before.tokens
+	s.rememberTokens = before.rememberTokens
+	s.auditEvents = before.auditEvents
+	s.organizations = before.organizations
+	s.memberships = before.memberships
+	s.roles = before.roles
+	s.permissions = before.permissions
+	s.grants = before.grants
+	s.assignments = before.assignments
+	s.emailVerifications = before.emailVerifications
+	s.userIdentities = before.userIdentities
+}
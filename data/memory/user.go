@@ -0,0 +1,272 @@
+package memory
+
+import (
+	"myapp/data"
+	"sort"
+	"time"
+)
+
+// userRepository is the in-memory implementation of data.UserRepository
+type userRepository struct {
+	session
+}
+
+func (r *userRepository) Table() string {
+	return "users"
+}
+
+func (r *userRepository) GetAll() ([]*data.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	all := r.usersWhere(false)
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].LastName != all[j].LastName {
+			return all[i].LastName < all[j].LastName
+		}
+		return all[i].ID < all[j].ID
+	})
+
+	return all, nil
+}
+
+func (r *userRepository) List(opts data.ListOptions) (*data.Page[*data.User], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return data.ListSlice(r.usersWhere(false), data.UserFields, opts, "last_name")
+}
+
+func (r *userRepository) ListDeleted(opts data.ListOptions) (*data.Page[*data.User], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return data.ListSlice(r.usersWhere(true), data.UserFields, opts, "last_name")
+}
+
+func (r *userRepository) GetByEmail(email string) (*data.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, u := range r.s.users {
+		if u.Email == email && !u.Deleted() && r.member(u.ID) {
+			u.Token = r.s.latestToken(u.ID)
+			u.SetRepository(r)
+			return &u, nil
+		}
+	}
+
+	return nil, data.ErrNotFound
+}
+
+func (r *userRepository) Get(id int) (*data.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	u, ok := r.activeUser(id)
+	if !ok {
+		return nil, data.ErrNotFound
+	}
+
+	u.Token = r.s.latestToken(u.ID)
+	u.SetRepository(r)
+
+	return &u, nil
+}
+
+func (r *userRepository) Update(theUser data.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.activeUser(theUser.ID)
+	if !ok {
+		return data.ErrNotFound
+	}
+
+	if stored.Version != theUser.Version {
+		return data.ErrStaleRecord
+	}
+
+	for _, u := range r.s.users {
+		if u.Email == theUser.Email && u.ID != theUser.ID {
+			return ErrDuplicate
+		}
+	}
+
+	theUser.UpdatedAt = time.Now()
+	theUser.Version++
+	theUser.DeletedAt = nil
+	theUser.Token = data.Token{}
+	theUser.SetRepository(nil)
+	r.s.users[theUser.ID] = theUser
+	r.record(data.AuditUpdate, r.Table(), theUser.ID, stored, theUser)
+
+	return nil
+}
+
+func (r *userRepository) UpdatePasswordHash(id int, oldHash, newHash string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, ok := r.activeUser(id)
+	if !ok {
+		return data.ErrNotFound
+	}
+
+	if u.Password != oldHash {
+		return data.ErrStaleRecord
+	}
+
+	before := u
+	u.Password = newHash
+	u.UpdatedAt = time.Now()
+	r.s.users[id] = u
+	r.record(data.AuditUpdate, r.Table(), id, before, u)
+
+	return nil
+}
+
+func (r *userRepository) Delete(id int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, ok := r.activeUser(id)
+	if !ok {
+		return nil
+	}
+
+	for tokenID, token := range r.s.tokens {
+		if token.UserID == id {
+			delete(r.s.tokens, tokenID)
+			r.record(data.AuditDelete, "tokens", tokenID, token, nil)
+		}
+	}
+	r.deleteRememberTokens(id)
+
+	before := u
+	now := time.Now()
+	u.DeletedAt = &now
+	u.UpdatedAt = now
+	u.Version++
+	r.s.users[id] = u
+	r.record(data.AuditDelete, r.Table(), id, before, u)
+
+	return nil
+}
+
+func (r *userRepository) Restore(id int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, ok := r.s.users[id]
+	if !ok || !u.Deleted() || !r.member(id) {
+		return data.ErrNotFound
+	}
+
+	before := u
+	u.DeletedAt = nil
+	u.UpdatedAt = time.Now()
+	u.Version++
+	r.s.users[id] = u
+	r.record(data.AuditRestore, r.Table(), id, before, u)
+
+	return nil
+}
+
+func (r *userRepository) Purge(retention time.Duration) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	purged := 0
+	cutoff := time.Now().Add(-retention)
+
+	for id, u := range r.s.users {
+		if u.Deleted() && u.DeletedAt.Before(cutoff) && r.member(id) {
+			r.s.deleteUser(id)
+			r.record(data.AuditPurge, r.Table(), id, u, nil)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (r *userRepository) Insert(theUser data.User) (int, error) {
+	if err := data.ValidatePassword(theUser.Password, theUser); err != nil {
+		return 0, err
+	}
+
+	newHash, err := data.HashPassword(theUser.Password)
+	if err != nil {
+		return 0, err
+	}
+	theUser.Password = newHash
+
+	return r.InsertWithHash(theUser)
+}
+
+func (r *userRepository) InsertWithHash(theUser data.User) (int, error) {
+	if r.tenant == data.NoOrganization {
+		return 0, data.ErrNoOrganization
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, u := range r.s.users {
+		if u.Email == theUser.Email {
+			return 0, data.ErrDuplicateEmail
+		}
+	}
+
+	theUser.ID = r.s.nextID(r.Table())
+	theUser.CreatedAt = time.Now()
+	theUser.UpdatedAt = time.Now()
+	theUser.Version = 1
+	theUser.Token = data.Token{}
+	r.s.users[theUser.ID] = theUser
+
+	if r.tenant != 0 {
+		r.addMember(r.tenant, theUser.ID, data.RoleMember)
+	}
+
+	r.record(data.AuditInsert, r.Table(), theUser.ID, nil, theUser)
+
+	return theUser.ID, nil
+}
+
+func (r *userRepository) ResetPassword(id int, password string) error {
+	theUser, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := data.ValidatePassword(password, *theUser); err != nil {
+		return err
+	}
+
+	newHash, err := data.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, ok := r.activeUser(id)
+	if !ok {
+		return data.ErrNotFound
+	}
+
+	before := u
+	u.Password = newHash
+	u.UpdatedAt = time.Now()
+	u.Version++
+	r.s.users[id] = u
+	r.record(data.AuditUpdate, r.Table(), id, before, u)
+
+	r.deleteRememberTokens(id)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"myapp/data"
+	"sort"
+	"time"
+)
+
+// userIdentityRepository is the in-memory implementation of data.UserIdentityRepository
+type userIdentityRepository struct {
+	session
+}
+
+func (r *userIdentityRepository) Table() string {
+	return "user_identities"
+}
+
+func (r *userIdentityRepository) Get(provider, providerUserID string) (*data.UserIdentity, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, identity := range r.s.userIdentities {
+		if identity.Provider == provider && identity.ProviderUserID == providerUserID {
+			return &identity, nil
+		}
+	}
+
+	return nil, data.ErrNotFound
+}
+
+func (r *userIdentityRepository) ForUser(userID int) ([]*data.UserIdentity, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var identities []*data.UserIdentity
+	for _, identity := range r.s.userIdentities {
+		if identity.UserID == userID {
+			identities = append(identities, &identity)
+		}
+	}
+
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+
+	return identities, nil
+}
+
+func (r *userIdentityRepository) Insert(identity data.UserIdentity) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if u, ok := r.s.users[identity.UserID]; !ok || u.Deleted() {
+		return 0, data.ErrNotFound
+	}
+
+	for _, linked := range r.s.userIdentities {
+		if linked.Provider == identity.Provider && linked.ProviderUserID == identity.ProviderUserID {
+			return 0, data.ErrIdentityLinked
+		}
+	}
+
+	identity.ID = r.s.nextID(r.Table())
+	identity.CreatedAt = time.Now()
+	r.s.userIdentities[identity.ID] = identity
+	r.record(data.AuditInsert, r.Table(), identity.ID, nil, identity)
+
+	return identity.ID, nil
+}
+
+func (r *userIdentityRepository) Delete(id int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	identity, ok := r.s.userIdentities[id]
+	if !ok {
+		return nil
+	}
+
+	delete(r.s.userIdentities, id)
+	r.record(data.AuditDelete, r.Table(), id, identity, nil)
+
+	return nil
+}
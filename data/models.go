@@ -1,33 +1,291 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"sync/atomic"
 
 	db2 "github.com/upper/db/v4"
 	"github.com/upper/db/v4/adapter/mysql"
 	"github.com/upper/db/v4/adapter/postgresql"
+	"github.com/upper/db/v4/adapter/sqlite"
 )
 
-var db *sql.DB
-var upper db2.Session
+var (
+	// ErrNoDatabase is returned by every model method when the application runs without a database
+	ErrNoDatabase          = errors.New("data: application is running without a database")
+	ErrUnknownDatabaseType = errors.New("data: unknown DATABASE_TYPE")
+)
 
+// Models gives the rest of the application access to every repository. New and Open back them
+// with SQL; the memory package provides in-memory implementations for tests. Every Models value
+// carries its own database sessions, so several of them can be used side by side.
 type Models struct {
+	Users              UserRepository
+	Tokens             TokenRepository
+	RememberTokens     RememberTokenRepository
+	AuditEvents        AuditEventRepository
+	Organizations      OrganizationRepository
+	Roles              RoleRepository
+	EmailVerifications EmailVerificationRepository
+	UserIdentities     UserIdentityRepository
+
+	// Backend provides WithTx and WithContext for models without a SQL session
+	Backend Backend
+
+	session db2.Session
+	replica db2.Session
+	actor   Actor
+	tenant  int
+	inTx    bool
+	txDepth int
+}
+
+// Backend is implemented by models that are not backed by SQL, such as the in-memory fakes,
+// so that WithTx and WithContext work for them too
+type Backend interface {
+	WithTx(ctx context.Context, fn func(tx Models) error) error
+	WithContext(ctx context.Context) Models
+}
+
+// New returns the models backed by databasePool, using the upper adapter selected by the
+// DATABASE_TYPE environment variable. Leaving DATABASE_TYPE empty with a nil pool runs the
+// application without a database, in which case every model method returns ErrNoDatabase.
+// An optional replica pool of the same type takes the reads; see Open.
+func New(databasePool *sql.DB, replicaPool ...*sql.DB) (Models, error) {
+	databaseType := os.Getenv("DATABASE_TYPE")
+
+	switch {
+	case databaseType == "" && databasePool == nil:
+		return NoDatabase(), nil
+	case databaseType == "":
+		return Models{}, errors.New("data: a database pool was supplied but DATABASE_TYPE is not set")
+	}
+
+	return Open(databaseType, databasePool, replicaPool...)
 }
 
-func New(databasePool *sql.DB) Models {
-	db = databasePool
+// Open returns the models backed by databasePool using the upper adapter for databaseType,
+// without consulting the environment. When a replica pool is supplied, read-only model
+// methods use it; writes, and everything inside WithTx, always go to databasePool.
+func Open(databaseType string, databasePool *sql.DB, replicaPool ...*sql.DB) (Models, error) {
+	if databasePool == nil {
+		return Models{}, fmt.Errorf("data: database type is %q but no database pool was supplied", databaseType)
+	}
+
+	if len(replicaPool) > 1 {
+		return Models{}, errors.New("data: at most one replica pool can be supplied")
+	}
+
+	session, err := openSession(databaseType, databasePool)
+	if err != nil {
+		return Models{}, err
+	}
+
+	var replica db2.Session
+
+	if len(replicaPool) == 1 && replicaPool[0] != nil {
+		if replica, err = openSession(databaseType, replicaPool[0]); err != nil {
+			return Models{}, fmt.Errorf("data: replica: %w", err)
+		}
+	}
+
+	return newModels(session, replica), nil
+}
 
-	switch os.Getenv("DATABASE_TYPE") {
+// NoDatabase returns models for running the application without a database
+func NoDatabase() Models {
+	return newModels(nil, nil)
+}
+
+// WithContext returns a copy of the models for a single request. Changes made through it are
+// attributed to the actor in ctx in the audit log, and when ctx carries a tenant from
+// WithTenant the copy only sees the data of that organization. Once the copy has written to the primary,
+// its reads go there too, so a request sees its own changes even when the replica lags
+// behind; a context marked by WithPrimaryReads sends every read to the primary.
+func (m Models) WithContext(ctx context.Context) Models {
+	if m.session == nil {
+		if m.Backend != nil {
+			return m.Backend.WithContext(ctx)
+		}
+		return m
+	}
+
+	actor, ok := ActorFrom(ctx)
+	if !ok {
+		actor = m.actor
+	}
+
+	tenant, ok := TenantFrom(ctx)
+	if !ok {
+		tenant = m.tenant
+	}
+
+	replica := m.replica
+	if PrimaryReads(ctx) || m.inTx {
+		replica = nil
+	}
+
+	c := m
+	c.actor = actor
+	c.tenant = tenant
+
+	return c.withSQL(sqlSession{
+		session: m.session,
+		replica: replica,
+		wrote:   new(atomic.Bool),
+		actor:   actor,
+		tenant:  tenant,
+		inTx:    m.inTx,
+	})
+}
+
+// newModels returns the SQL models sharing session, which is nil when there is no database,
+// and reading from replica when there is one
+func newModels(session, replica db2.Session) Models {
+	m := Models{session: session, replica: replica}
+	return m.withSQL(sqlSession{session: session, replica: replica})
+}
+
+// withSQL returns a copy of m whose SQL models use s
+func (m Models) withSQL(s sqlSession) Models {
+	m.Users = &userModel{s}
+	m.Tokens = &tokenModel{s}
+	m.RememberTokens = &rememberTokenModel{s}
+	m.AuditEvents = &auditEventModel{s}
+	m.Organizations = &organizationModel{s}
+	m.Roles = &roleModel{s}
+	m.EmailVerifications = &emailVerificationModel{s}
+	m.UserIdentities = &userIdentityModel{s}
+
+	return m
+}
+
+// openSession opens an upper session on databasePool with the adapter for databaseType
+func openSession(databaseType string, databasePool *sql.DB) (db2.Session, error) {
+	if err := checkDriver(databaseType, databasePool); err != nil {
+		return nil, err
+	}
+
+	var session db2.Session
+	var err error
+
+	switch databaseType {
 	case "mysql", "mariadb":
-		upper, _ = mysql.New(databasePool)
+		session, err = mysql.New(databasePool)
 	case "postgres", "postgresql":
-		upper, _ = postgresql.New(databasePool)
+		session, err = postgresql.New(databasePool)
+	case "sqlite", "sqlite3":
+		session, err = sqlite.New(databasePool)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownDatabaseType, databaseType)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("data: opening %s session: %w", databaseType, err)
+	}
+
+	return session, nil
+}
+
+// checkDriver rejects a DATABASE_TYPE that does not match the driver of the pool. Drivers
+// it does not recognise, such as sqlmock in tests, are accepted as they are.
+func checkDriver(databaseType string, databasePool *sql.DB) error {
+	var expected []string
+
+	switch fmt.Sprintf("%T", databasePool.Driver()) {
+	case "*stdlib.Driver", "*pq.Driver":
+		expected = []string{"postgres", "postgresql"}
+	case "*mysql.MySQLDriver":
+		expected = []string{"mysql", "mariadb"}
+	case "*sqlite3.SQLiteDriver":
+		expected = []string{"sqlite", "sqlite3"}
 	default:
-		// do nothing
+		return nil
+	}
+
+	for _, t := range expected {
+		if t == databaseType {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("data: DATABASE_TYPE is %q but the database pool uses a %s driver", databaseType, expected[0])
+}
+
+// sqlSession is embedded by the SQL models to reach the sessions of the Models they belong to,
+// along with the actor their changes are attributed to and the tenant they are scoped to, which is
+// 0 when they are not. wrote is only set on the per-request copies made by WithContext.
+type sqlSession struct {
+	session db2.Session
+	replica db2.Session
+	wrote   *atomic.Bool
+	actor   Actor
+	tenant  int
+	inTx    bool
+}
+
+// collection returns the named collection on the primary, or ErrNoDatabase when the
+// application runs without a database
+func (s sqlSession) collection(name string) (db2.Collection, error) {
+	if s.session == nil {
+		return nil, ErrNoDatabase
+	}
+
+	return s.session.Collection(name), nil
+}
+
+// writeCollection returns the named collection on the primary for a write, after which
+// the reads of a per-request copy go to the primary as well
+func (s sqlSession) writeCollection(name string) (db2.Collection, error) {
+	if s.wrote != nil {
+		s.wrote.Store(true)
+	}
+
+	return s.collection(name)
+}
+
+// readCollection returns the named collection on the replica when reads may go there,
+// and on the primary otherwise
+func (s sqlSession) readCollection(name string) (db2.Collection, error) {
+	if s.session == nil {
+		return nil, ErrNoDatabase
 	}
 
-	return Models{}
+	if s.replica == nil || (s.wrote != nil && s.wrote.Load()) {
+		return s.session.Collection(name), nil
+	}
+
+	return s.replica.Collection(name), nil
+}
+
+// atomically runs fn with a copy bound to a transaction on the primary, so a change and its
+// audit events are stored together. Inside WithTx it uses the transaction already running.
+func (s sqlSession) atomically(fn func(tx sqlSession) error) error {
+	if s.session == nil {
+		return ErrNoDatabase
+	}
+
+	if s.wrote != nil {
+		s.wrote.Store(true)
+	}
+
+	if s.inTx {
+		return fn(s.primary())
+	}
+
+	return s.session.Tx(func(session db2.Session) error {
+		return fn(sqlSession{session: session, actor: s.actor, tenant: s.tenant, inTx: true})
+	})
+}
+
+// primary returns a copy that reads from the primary, for reads a write depends on
+func (s sqlSession) primary() sqlSession {
+	s.replica = nil
+	return s
 }
 
 func getInsertID(i db2.ID) int {
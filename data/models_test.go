@@ -1,6 +1,9 @@
 package data
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -10,24 +13,114 @@ import (
 )
 
 func TestNew(t *testing.T) {
-	mockDB, _, _ := sqlmock.New()
+	mockDB, mock, _ := sqlmock.New()
 	defer mockDB.Close()
 
+	// the upper adapters look up the name of the current database when a session is opened
+	mock.ExpectQuery("CURRENT_DATABASE").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("celeritas"))
+	mock.ExpectQuery("DATABASE").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("celeritas"))
+
 	_ = os.Setenv("DATABASE_TYPE", "postgres")
-	m := New(mockDB)
+	m, err := New(mockDB)
+	if err != nil {
+		t.Error("error creating models:", err)
+	}
 	if fmt.Sprintf("%T", m) != "data.Models" {
 		t.Error("wrong type returned", fmt.Sprintf("%T", m))
 
 	}
 
 	_ = os.Setenv("DATABASE_TYPE", "mysql")
-	m = New(mockDB)
+	m, err = New(mockDB)
+	if err != nil {
+		t.Error("error creating models:", err)
+	}
 	if fmt.Sprintf("%T", m) != "data.Models" {
 		t.Error("wrong type returned", fmt.Sprintf("%T", m))
 
 	}
 }
 
+var newErrorData = []struct {
+	name         string
+	databaseType string
+	withPool     bool
+	err          error
+}{
+	{"unknown_type", "oracle", true, ErrUnknownDatabaseType},
+	{"type_without_pool", "postgres", false, nil},
+	{"pool_without_type", "", true, nil},
+}
+
+func TestNew_Errors(t *testing.T) {
+	mockDB, _, _ := sqlmock.New()
+	defer mockDB.Close()
+
+	for _, tt := range newErrorData {
+		t.Setenv("DATABASE_TYPE", tt.databaseType)
+
+		pool := mockDB
+		if !tt.withPool {
+			pool = nil
+		}
+
+		_, err := New(pool)
+		if err == nil {
+			t.Errorf("%s: expected an error, received none", tt.name)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestNew_DriverMismatch(t *testing.T) {
+	sqliteDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqliteDB.Close()
+
+	t.Setenv("DATABASE_TYPE", "postgres")
+	if _, err := New(sqliteDB); err == nil {
+		t.Error("postgres DATABASE_TYPE accepted for a sqlite pool")
+	}
+
+	t.Setenv("DATABASE_TYPE", "sqlite")
+	if _, err := New(sqliteDB); err != nil {
+		t.Error("sqlite DATABASE_TYPE rejected for a sqlite pool:", err)
+	}
+}
+
+func TestNew_NoDatabase(t *testing.T) {
+	t.Setenv("DATABASE_TYPE", "")
+
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal("error creating database-less models:", err)
+	}
+
+	if _, err := m.Users.Get(1); !errors.Is(err, ErrNoDatabase) {
+		t.Error("expected ErrNoDatabase from Users.Get, got", err)
+	}
+
+	if _, err := m.Users.Insert(User{Password: "kettle-harbor-quilt-42"}); !errors.Is(err, ErrNoDatabase) {
+		t.Error("expected ErrNoDatabase from Users.Insert, got", err)
+	}
+
+	if _, err := m.Tokens.GetByToken("token"); !errors.Is(err, ErrNoDatabase) {
+		t.Error("expected ErrNoDatabase from Tokens.GetByToken, got", err)
+	}
+
+	if _, err := m.RememberTokens.Valid(1, "token"); !errors.Is(err, ErrNoDatabase) {
+		t.Error("expected ErrNoDatabase from RememberTokens.Valid, got", err)
+	}
+
+	if err := m.WithTx(context.Background(), func(tx Models) error { return nil }); !errors.Is(err, ErrNoDatabase) {
+		t.Error("expected ErrNoDatabase from WithTx, got", err)
+	}
+}
+
 func TestGetInsertID(t *testing.T) {
 	var id db2.ID = int64(1)
 
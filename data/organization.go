@@ -0,0 +1,237 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// The roles a user can have in an organization
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+// Organization is the type for a row in the organizations table. Each organization is a
+// tenant whose users and tokens are hidden from the others.
+type Organization struct {
+	ID        int       `db:"id,omitempty" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Slug      string    `db:"slug" json:"slug"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Membership is the type for a row in the memberships table, which puts a user in an organization
+type Membership struct {
+	ID             int       `db:"id,omitempty"`
+	OrganizationID int       `db:"organization_id"`
+	UserID         int       `db:"user_id"`
+	Role           string    `db:"role"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// organizationModel is the SQL implementation of OrganizationRepository
+type organizationModel struct {
+	sqlSession
+}
+
+func (m *organizationModel) Table() string {
+	return "organizations"
+}
+
+func (m *organizationModel) Get(id int) (*Organization, error) {
+	var org Organization
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Cond{"id =": id})
+	if err := res.One(&org); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+func (m *organizationModel) GetBySlug(slug string) (*Organization, error) {
+	var org Organization
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Cond{"slug =": slug})
+	if err := res.One(&org); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+func (m *organizationModel) ForUser(userID int) ([]*Organization, error) {
+	var orgs []*Organization
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Raw("id IN (SELECT organization_id FROM memberships WHERE user_id = ?)", userID)).OrderBy("name")
+	if err := res.All(&orgs); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+func (m *organizationModel) Insert(org Organization) (int, error) {
+	org.CreatedAt = time.Now()
+	org.UpdatedAt = time.Now()
+
+	err := m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		res, err := collection.Insert(org)
+		if err != nil {
+			return err
+		}
+
+		org.ID = getInsertID(res.ID())
+
+		return tx.record(AuditInsert, m.Table(), org.ID, nil, org)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return org.ID, nil
+}
+
+func (m *organizationModel) Delete(id int) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var org Organization
+		if err := collection.Find(up.Cond{"id =": id}).One(&org); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return nil
+			}
+			return err
+		}
+
+		// the memberships would go with the organization anyway, but each is recorded
+		if err := tx.deleteMemberships(up.Cond{"organization_id =": id}); err != nil {
+			return err
+		}
+		if err := tx.deleteAssignments(up.Cond{"organization_id =": id}); err != nil {
+			return err
+		}
+
+		if err := collection.Find(up.Cond{"id =": id}).Delete(); err != nil {
+			return err
+		}
+
+		return tx.record(AuditDelete, m.Table(), id, org, nil)
+	})
+}
+
+func (m *organizationModel) AddMember(organizationID, userID int, role string) error {
+	membership := Membership{
+		OrganizationID: organizationID,
+		UserID:         userID,
+		Role:           role,
+		CreatedAt:      time.Now(),
+	}
+
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection("memberships")
+		if err != nil {
+			return err
+		}
+
+		res, err := collection.Insert(membership)
+		if err != nil {
+			return err
+		}
+
+		membership.ID = getInsertID(res.ID())
+
+		return tx.record(AuditInsert, "memberships", membership.ID, nil, membership)
+	})
+}
+
+func (m *organizationModel) RemoveMember(organizationID, userID int) error {
+	return m.atomically(func(tx sqlSession) error {
+		// the roles of a user in an organization go with their membership, so they are not
+		// back when the user is added again
+		if err := tx.deleteAssignments(up.Cond{"organization_id =": organizationID, "user_id =": userID}); err != nil {
+			return err
+		}
+
+		return tx.deleteMemberships(up.Cond{"organization_id =": organizationID, "user_id =": userID})
+	})
+}
+
+// deleteMemberships removes the memberships matching cond, recording an audit event for each of them
+func (s sqlSession) deleteMemberships(cond up.Cond) error {
+	collection, err := s.collection("memberships")
+	if err != nil {
+		return err
+	}
+
+	var memberships []Membership
+	if err := collection.Find(cond).All(&memberships); err != nil {
+		return err
+	}
+
+	for _, membership := range memberships {
+		if err := collection.Find(up.Cond{"id =": membership.ID}).Delete(); err != nil {
+			return err
+		}
+
+		if err := s.record(AuditDelete, "memberships", membership.ID, membership, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *organizationModel) Membership(organizationID, userID int) (*Membership, error) {
+	var membership Membership
+
+	// membership decides what a user may see, so it is never read from a lagging replica
+	collection, err := m.collection("memberships")
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Cond{"organization_id =": organizationID, "user_id =": userID})
+	if err := res.One(&membership); err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// membersOnly limits a query to the rows whose column holds the id of a member of the tenant
+// the session is scoped to. It matches every row when there is no tenant, and none for
+// NoOrganization, which has no members.
+func (s sqlSession) membersOnly(column string) up.LogicalExpr {
+	if s.tenant == 0 {
+		return up.Cond{}
+	}
+
+	return up.Raw(column+" IN (SELECT user_id FROM memberships WHERE organization_id = ?)", s.tenant)
+}
@@ -0,0 +1,232 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the bcrypt cost used unless BCRYPT_COST says otherwise
+const DefaultBcryptCost = 12
+
+// ErrUnknownHash is returned when checking a password against a hash no Hasher produces
+var ErrUnknownHash = errors.New("data: unknown password hash format")
+
+// DefaultArgon2id holds the argon2id parameters used unless the ARGON2_* variables say otherwise
+var DefaultArgon2id = Argon2idHasher{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+// Hasher turns passwords into self-describing encoded hashes. Checking a password does not
+// need the Hasher that made its hash, so hashes made under an earlier policy keep working.
+type Hasher interface {
+	// Hash returns the encoded hash of plainText
+	Hash(plainText string) (string, error)
+	// NeedsRehash reports whether encoded was made by another algorithm or with other parameters
+	NeedsRehash(encoded string) bool
+}
+
+var passwordHasher atomic.Pointer[Hasher]
+
+func init() {
+	SetPasswordHasher(BcryptHasher{Cost: DefaultBcryptCost})
+}
+
+// SetPasswordHasher makes h hash every new password. Existing hashes are upgraded to it the
+// next time their user logs in.
+func SetPasswordHasher(h Hasher) {
+	passwordHasher.Store(&h)
+}
+
+// PasswordHasher returns the Hasher new passwords are hashed with
+func PasswordHasher() Hasher {
+	return *passwordHasher.Load()
+}
+
+// PasswordHasherFromEnv returns the Hasher configured by the environment. PASSWORD_HASHER is
+// bcrypt, the default, or argon2id. BCRYPT_COST sets the bcrypt cost; ARGON2_MEMORY (in KiB),
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM set the argon2id parameters.
+func PasswordHasherFromEnv() (Hasher, error) {
+	switch hasher := os.Getenv("PASSWORD_HASHER"); hasher {
+	case "", "bcrypt":
+		h := BcryptHasher{Cost: DefaultBcryptCost}
+		if err := envInt("BCRYPT_COST", &h.Cost); err != nil {
+			return nil, err
+		}
+		if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("data: BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return h, nil
+	case "argon2id":
+		h := DefaultArgon2id
+		memory, iterations, parallelism := int(h.Memory), int(h.Iterations), int(h.Parallelism)
+		for name, value := range map[string]*int{
+			"ARGON2_MEMORY":      &memory,
+			"ARGON2_ITERATIONS":  &iterations,
+			"ARGON2_PARALLELISM": &parallelism,
+		} {
+			if err := envInt(name, value); err != nil {
+				return nil, err
+			}
+			if *value < 1 {
+				return nil, fmt.Errorf("data: %s must be at least 1", name)
+			}
+		}
+		if parallelism > 255 {
+			return nil, errors.New("data: ARGON2_PARALLELISM must be at most 255")
+		}
+		h.Memory, h.Iterations, h.Parallelism = uint32(memory), uint32(iterations), uint8(parallelism)
+		return h, nil
+	default:
+		return nil, fmt.Errorf("data: unknown PASSWORD_HASHER %q", hasher)
+	}
+}
+
+// envInt sets *value to the integer in the named environment variable, if it is set
+func envInt(name string, value *int) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("data: %s is not a number: %q", name, s)
+	}
+
+	*value = i
+	return nil
+}
+
+// HashPassword returns the hash of plainText that is stored in the password column
+func HashPassword(plainText string) (string, error) {
+	return PasswordHasher().Hash(plainText)
+}
+
+// VerifyPassword reports whether plainText matches an encoded hash made by any of the hashers
+func VerifyPassword(encoded, plainText string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return verifyArgon2id(encoded, plainText)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plainText))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// BcryptHasher hashes passwords with bcrypt at the given cost, in the usual $2a$ format
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plainText string) (string, error) {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(plainText), h.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(newHash), nil
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+type Argon2idHasher struct {
+	// Memory is the memory used in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (h Argon2idHasher) Hash(plainText string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(plainText), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return argon2idHash{params: h, salt: salt, key: key}.encode(), nil
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	decoded, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return decoded.params != h
+}
+
+// argon2idHash is a decoded argon2id hash
+type argon2idHash struct {
+	params Argon2idHasher
+	salt   []byte
+	key    []byte
+}
+
+func (a argon2idHash) encode() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.params.Memory, a.params.Iterations, a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(a.salt), base64.RawStdEncoding.EncodeToString(a.key))
+}
+
+func decodeArgon2id(encoded string) (argon2idHash, error) {
+	var a argon2idHash
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return a, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return a, fmt.Errorf("data: unsupported argon2id version %q", parts[2])
+	}
+
+	p := &a.params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return a, fmt.Errorf("data: malformed argon2id parameters %q", parts[3])
+	}
+
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return a, fmt.Errorf("data: malformed argon2id salt: %w", err)
+	}
+	if a.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return a, fmt.Errorf("data: malformed argon2id hash: %w", err)
+	}
+
+	p.SaltLength, p.KeyLength = uint32(len(a.salt)), uint32(len(a.key))
+
+	return a, nil
+}
+
+func verifyArgon2id(encoded, plainText string) (bool, error) {
+	a, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(plainText), a.salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, a.key) == 1, nil
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]bool
+)
+
+// DefaultPasswordPolicy is the policy passwords are checked against unless SetPasswordPolicy says otherwise
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 12, MaxBytes: 72, RejectCommon: true, RejectPersonal: true}
+
+// PasswordPolicy decides which passwords users may choose
+type PasswordPolicy struct {
+	// MinLength is the least number of characters in a password
+	MinLength int
+	// MaxBytes is the most bytes in a password; bcrypt ignores everything past the 72nd
+	MaxBytes int
+	// RejectCommon rejects the passwords of the bundled list of common breached passwords
+	RejectCommon bool
+	// RejectPersonal rejects passwords made from the name or email address of the user
+	RejectPersonal bool
+}
+
+var passwordPolicy atomic.Pointer[PasswordPolicy]
+
+func init() {
+	SetPasswordPolicy(DefaultPasswordPolicy)
+}
+
+// SetPasswordPolicy makes p the policy new passwords are checked against
+func SetPasswordPolicy(p PasswordPolicy) {
+	passwordPolicy.Store(&p)
+}
+
+// ValidatePassword checks plainText against the current policy as the password of u. It
+// returns nil when the password is acceptable and otherwise FieldErrors for the password field.
+func ValidatePassword(plainText string, u User) error {
+	if message := passwordPolicy.Load().Check(plainText, u); message != "" {
+		return FieldErrors{"password": message}
+	}
+
+	return nil
+}
+
+// Check returns why plainText may not be the password of u, or an empty string when it may
+func (p PasswordPolicy) Check(plainText string, u User) string {
+	switch {
+	case utf8.RuneCountInString(plainText) < p.MinLength:
+		return fmt.Sprintf("Password must be at least %d characters long", p.MinLength)
+	case p.MaxBytes > 0 && len(plainText) > p.MaxBytes:
+		return fmt.Sprintf("Password must be at most %d bytes long; accented and other special characters take more than one", p.MaxBytes)
+	case p.RejectCommon && isCommonPassword(plainText):
+		return "Password is one of the most commonly used passwords; choose one that is harder to guess"
+	case p.RejectPersonal && isPersonalPassword(plainText, u):
+		return "Password must not be based on your name or email address"
+	}
+
+	return ""
+}
+
+// isCommonPassword reports whether plainText is on the common password list, ignoring case and
+// any digits or symbols tacked on the end such as in "Sunshine2024!"
+func isCommonPassword(plainText string) bool {
+	commonPasswordsOnce.Do(loadCommonPasswords)
+
+	lower := strings.ToLower(plainText)
+	if commonPasswords[lower] {
+		return true
+	}
+
+	stem := strings.TrimRightFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	return stem != "" && commonPasswords[stem]
+}
+
+func loadCommonPasswords() {
+	commonPasswords = make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			commonPasswords[line] = true
+		}
+	}
+}
+
+// isPersonalPassword reports whether plainText contains the email address of u, the part of it
+// before the @, or their full name, or is just their first or last name with digits or symbols
+func isPersonalPassword(plainText string, u User) bool {
+	password := letterDigits(plainText)
+	local, _, _ := strings.Cut(u.Email, "@")
+
+	for _, personal := range []string{u.Email, local, u.FirstName + u.LastName, u.LastName + u.FirstName} {
+		if p := letterDigits(personal); len(p) >= 3 && strings.Contains(password, p) {
+			return true
+		}
+	}
+
+	stem := letterDigits(strings.TrimRightFunc(plainText, func(r rune) bool { return !unicode.IsLetter(r) }))
+	for _, name := range []string{u.FirstName, u.LastName} {
+		if n := letterDigits(name); n != "" && stem == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// letterDigits returns s in lower case with everything but letters and digits removed
+func letterDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
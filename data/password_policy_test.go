@@ -0,0 +1,61 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	u := User{FirstName: "John", LastName: "Smith", Email: "jsmith@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		rejected string
+	}{
+		{"acceptable", "kettle-harbor-quilt-42", ""},
+		{"too short", "Xq7#mP2", "at least 12 characters"},
+		{"too short in characters", "ééééééééééé", "at least 12 characters"},
+		{"too long", strings.Repeat("kettle-harbor ", 6), "at most 72 bytes"},
+		{"common", "qwertyuiop123", "most commonly used"},
+		{"common in capitals", "PASSWORD1234", "most commonly used"},
+		{"common with a suffix", "Sunshine2024!!", "most commonly used"},
+		{"email", "my-jsmith@example.com", "name or email"},
+		{"email local part", "JSmith-is-great", "name or email"},
+		{"full name", "john.smith.1990", "name or email"},
+		{"last name with digits", "Smith1234567890", "name or email"},
+	}
+
+	for _, tt := range tests {
+		message := DefaultPasswordPolicy.Check(tt.password, u)
+		if tt.rejected == "" && message != "" {
+			t.Errorf("%s: expected %q to be accepted, got %q", tt.name, tt.password, message)
+		}
+		if !strings.Contains(message, tt.rejected) {
+			t.Errorf("%s: expected %q to be rejected with %q, got %q", tt.name, tt.password, tt.rejected, message)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	defer SetPasswordPolicy(DefaultPasswordPolicy)
+
+	if err := ValidatePassword("kettle-harbor-quilt-42", User{}); err != nil {
+		t.Error("acceptable password rejected:", err)
+	}
+
+	var fieldErrors FieldErrors
+	err := ValidatePassword("password", User{})
+	if !errors.As(err, &fieldErrors) || fieldErrors["password"] == "" {
+		t.Fatal("expected a password field error, got", err)
+	}
+	if err.Error() != "data: invalid password: "+fieldErrors["password"] {
+		t.Errorf("unexpected error text %q", err)
+	}
+
+	SetPasswordPolicy(PasswordPolicy{MinLength: 4})
+	if err := ValidatePassword("password", User{}); err != nil {
+		t.Error("password rejected by a policy allowing common passwords:", err)
+	}
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var cheapArgon2id = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashers(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher Hasher
+		prefix string
+	}{
+		{"bcrypt", BcryptHasher{Cost: bcrypt.MinCost}, "$2a$04$"},
+		{"argon2id", cheapArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$"},
+	}
+
+	for _, tt := range tests {
+		hash, err := tt.hasher.Hash("password")
+		if err != nil {
+			t.Fatalf("%s: failed to hash password: %s", tt.name, err)
+		}
+
+		if !strings.HasPrefix(hash, tt.prefix) {
+			t.Errorf("%s: expected hash to start with %q, got %q", tt.name, tt.prefix, hash)
+		}
+
+		if ok, err := VerifyPassword(hash, "password"); !ok || err != nil {
+			t.Errorf("%s: password does not match, expected a match: %v", tt.name, err)
+		}
+
+		if ok, err := VerifyPassword(hash, "wrongpassword"); ok || err != nil {
+			t.Errorf("%s: used incorrect password, expected no match: %v", tt.name, err)
+		}
+
+		if tt.hasher.NeedsRehash(hash) {
+			t.Errorf("%s: hash made with the current parameters needs a rehash", tt.name)
+		}
+	}
+}
+
+func TestHashers_NeedsRehash(t *testing.T) {
+	bcryptHash, _ := BcryptHasher{Cost: bcrypt.MinCost}.Hash("password")
+	argon2idHash, _ := cheapArgon2id.Hash("password")
+
+	stronger := cheapArgon2id
+	stronger.Iterations++
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+	}{
+		{"bcrypt cost", BcryptHasher{Cost: bcrypt.MinCost + 1}, bcryptHash},
+		{"bcrypt to argon2id", cheapArgon2id, bcryptHash},
+		{"argon2id parameters", stronger, argon2idHash},
+		{"argon2id to bcrypt", BcryptHasher{Cost: bcrypt.MinCost}, argon2idHash},
+	}
+
+	for _, tt := range tests {
+		if !tt.hasher.NeedsRehash(tt.hash) {
+			t.Errorf("%s: expected a rehash", tt.name)
+		}
+	}
+}
+
+func TestVerifyPassword_Malformed(t *testing.T) {
+	tests := []string{
+		"",
+		"not a hash",
+		"$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$not*base64$aGFzaA",
+		"$2a$04$short",
+	}
+
+	for _, hash := range tests {
+		if _, err := VerifyPassword(hash, "password"); err == nil {
+			t.Errorf("%q: expected an error, received none", hash)
+		}
+	}
+
+	if _, err := VerifyPassword("plain text", "password"); !errors.Is(err, ErrUnknownHash) {
+		t.Error("expected ErrUnknownHash, got", err)
+	}
+}
+
+func TestPasswordHasherFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_HASHER", "")
+	t.Setenv("BCRYPT_COST", "10")
+
+	h, err := PasswordHasherFromEnv()
+	if err != nil || h != (BcryptHasher{Cost: 10}) {
+		t.Errorf("expected bcrypt at cost 10, got %#v: %v", h, err)
+	}
+
+	t.Setenv("PASSWORD_HASHER", "argon2id")
+	t.Setenv("ARGON2_ITERATIONS", "4")
+
+	h, err = PasswordHasherFromEnv()
+	expected := DefaultArgon2id
+	expected.Iterations = 4
+	if err != nil || h != expected {
+		t.Errorf("expected %#v, got %#v: %v", expected, h, err)
+	}
+
+	for name, value := range map[string]string{
+		"PASSWORD_HASHER":    "md5",
+		"ARGON2_MEMORY":      "lots",
+		"ARGON2_PARALLELISM": "0",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := PasswordHasherFromEnv(); err == nil {
+				t.Errorf("%s=%s: expected an error, received none", name, value)
+			}
+		})
+	}
+
+	t.Setenv("PASSWORD_HASHER", "bcrypt")
+	t.Setenv("BCRYPT_COST", "99")
+	if _, err := PasswordHasherFromEnv(); err == nil {
+		t.Error("BCRYPT_COST=99: expected an error, received none")
+	}
+}
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// RememberTokenLifetime is how long a remember token keeps a user logged in without a session
+const RememberTokenLifetime = 30 * 24 * time.Hour
+
+// RememberToken is the type for a row in the remember_tokens table. Only the hex encoded
+// SHA-256 hash of a token is stored; the plain text lives in the user's remember cookie.
+type RememberToken struct {
+	ID            int       `db:"id,omitempty"`
+	UserID        int       `db:"user_id"`
+	RememberToken string    `db:"remember_token"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
+// Expired reports whether the token is too old to log its user back in
+func (t *RememberToken) Expired() bool {
+	return t.CreatedAt.Before(time.Now().Add(-RememberTokenLifetime))
+}
+
+// NewRememberToken returns a new random remember token in plain text
+func NewRememberToken() (string, error) {
+	return RandomToken()
+}
+
+// RandomToken returns a new random token in plain text: 32 random bytes, base32 encoded
+func RandomToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// HashRememberToken returns the value stored in the remember_token column for plainText
+func HashRememberToken(plainText string) string {
+	return hex.EncodeToString(HashToken(plainText))
+}
+
+// rememberTokenModel is the SQL implementation of RememberTokenRepository. It never reads
+// from a replica, so a revoked token cannot log anyone in while the replica catches up.
+type rememberTokenModel struct {
+	sqlSession
+}
+
+func (m *rememberTokenModel) Table() string {
+	return "remember_tokens"
+}
+
+func (m *rememberTokenModel) Issue(userID int) (string, error) {
+	plainText, err := NewRememberToken()
+	if err != nil {
+		return "", err
+	}
+
+	token := RememberToken{
+		UserID:        userID,
+		RememberToken: HashRememberToken(plainText),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	err = m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		res, err := collection.Insert(token)
+		if err != nil {
+			return err
+		}
+
+		token.ID = getInsertID(res.ID())
+
+		return tx.record(AuditInsert, m.Table(), token.ID, nil, token)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+func (m *rememberTokenModel) Valid(userID int, plainText string) (bool, error) {
+	collection, err := m.collection(m.Table())
+	if err != nil {
+		return false, err
+	}
+
+	res := collection.Find(up.Cond{
+		"user_id =":        userID,
+		"remember_token =": HashRememberToken(plainText),
+		"created_at >":     time.Now().Add(-RememberTokenLifetime),
+	})
+
+	count, err := res.Count()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (m *rememberTokenModel) Rotate(userID int, plainText string) (string, error) {
+	var rotated string
+
+	err := m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		current := up.Cond{
+			"user_id =":        userID,
+			"remember_token =": HashRememberToken(plainText),
+			"created_at >":     time.Now().Add(-RememberTokenLifetime),
+		}
+
+		var token RememberToken
+		if err := collection.Find(current).One(&token); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+
+		// deleting the token is what claims it, so when two requests rotate the same token
+		// only the one whose delete finds it gets a new one
+		res, err := collection.Session().SQL().
+			DeleteFrom(m.Table()).
+			Where(current, up.Cond{"id =": token.ID}).
+			Exec()
+		if err != nil {
+			return err
+		}
+
+		if deleted, err := res.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return ErrInvalidToken
+		}
+
+		if err := tx.record(AuditDelete, m.Table(), token.ID, token, nil); err != nil {
+			return err
+		}
+
+		tokens := rememberTokenModel{tx}
+		rotated, err = tokens.Issue(userID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return rotated, nil
+}
+
+func (m *rememberTokenModel) Delete(plainText string) error {
+	return m.deleteWhere(up.Cond{"remember_token =": HashRememberToken(plainText)})
+}
+
+func (m *rememberTokenModel) DeleteForUser(userID int) error {
+	return m.deleteWhere(up.Cond{"user_id =": userID})
+}
+
+// deleteWhere removes the remember tokens matching cond, recording an audit event for each of them
+func (m *rememberTokenModel) deleteWhere(cond up.Cond) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var tokens []RememberToken
+		if err := collection.Find(cond).All(&tokens); err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			if err := collection.Find(up.Cond{"id =": token.ID}).Delete(); err != nil {
+				return err
+			}
+
+			if err := tx.record(AuditDelete, m.Table(), token.ID, token, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = up.ErrNoMoreRows
+
+// ErrDuplicateEmail is returned when inserting a user with the email address of another user,
+// including one soft deleted but not yet purged
+var ErrDuplicateEmail = errors.New("data: email address belongs to another user")
+
+// ErrNoOrganization is returned when inserting a user through models scoped to NoOrganization,
+// which have no organization to add them to
+var ErrNoOrganization = errors.New("data: not in any organization")
+
+// ErrStaleRecord is returned when updating a record that was changed by someone else since it was loaded
+var ErrStaleRecord = errors.New("data: record was changed since it was loaded")
+
+// FieldErrors is returned when values given for a record are rejected. It maps the form
+// field of each rejected value to a message for the user.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for i, field := range fields {
+		fields[i] = field + ": " + e[field]
+	}
+
+	return "data: invalid " + strings.Join(fields, "; ")
+}
+
+// UserRepository stores users. Deleting a user only marks them deleted; every method except
+// ListDeleted, Restore and Purge treats soft deleted users as if they did not exist. Scoped
+// to a tenant, it only sees the members of that organization, and Insert adds new users to it;
+// scoped to NoOrganization, it sees nobody and Insert fails with ErrNoOrganization.
+type UserRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// GetAll returns every user ordered by last name
+	GetAll() ([]*User, error)
+	// List returns a page of users, ordered by last name unless opts sorts by a field of UserFields
+	List(opts ListOptions) (*Page[*User], error)
+	// GetByEmail returns the user with the given email, along with their most recent unexpired token
+	GetByEmail(email string) (*User, error)
+	// Get returns the user with the given id, along with their most recent unexpired token
+	Get(id int) (*User, error)
+	// Update saves every field of theUser to the user with the matching id. It fails with
+	// ErrStaleRecord unless theUser.Version is still the version stored for the user.
+	Update(theUser User) error
+	// UpdatePasswordHash replaces the password hash of the user with newHash, provided it is
+	// still oldHash, and fails with ErrStaleRecord otherwise. The version is left alone, since
+	// the password itself is unchanged.
+	UpdatePasswordHash(id int, oldHash, newHash string) error
+	// Delete soft deletes the user with the given id and revokes their tokens and remember tokens
+	Delete(id int) error
+	// ListDeleted returns a page of soft deleted users
+	ListDeleted(opts ListOptions) (*Page[*User], error)
+	// Restore undoes the soft deletion of the user with the given id; their tokens stay revoked
+	Restore(id int) error
+	// Purge permanently removes the users deleted more than retention ago and returns how many there were
+	Purge(retention time.Duration) (int, error)
+	// Insert hashes the password of theUser, stores the user and returns the new id. It fails
+	// with FieldErrors when the password is rejected by the password policy and ErrDuplicateEmail
+	// when another user, even a soft deleted one, has the email address.
+	Insert(theUser User) (int, error)
+	// InsertWithHash is Insert for a user whose Password already holds a hash, such as that of
+	// a generated password, which the password policy for passwords people choose does not apply to
+	InsertWithHash(theUser User) (int, error)
+	// ResetPassword replaces the password of the user and revokes all of their remember tokens.
+	// It fails with FieldErrors when the password is rejected by the password policy.
+	ResetPassword(id int, password string) error
+}
+
+// TokenRepository stores API tokens. Only the hash of a token is stored, so its plain
+// text is only available on the value returned by GenerateToken. Scoped to a tenant, it only
+// sees the tokens of members of that organization.
+type TokenRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// GetUserForToken returns the user owning the plain text token, with the token attached
+	GetUserForToken(plainText string) (*User, error)
+	// GetTokensForUser returns every token issued to the user, newest first
+	GetTokensForUser(id int) ([]*Token, error)
+	// List returns a page of tokens, newest first unless opts sorts by a field of TokenFields
+	List(opts ListOptions) (*Page[*Token], error)
+	// Get returns the token with the given id
+	Get(id int) (*Token, error)
+	// GetByToken returns the token whose hash matches the plain text token
+	GetByToken(plainText string) (*Token, error)
+	// Delete removes the token with the given id
+	Delete(id int) error
+	// DeleteByToken removes the token matching the plain text token, if it exists
+	DeleteByToken(plainText string) error
+	// Insert stores the hash of token for user u
+	Insert(token Token, u User) error
+	// GenerateToken creates a token for the user which expires after ttl, without storing it
+	GenerateToken(userID int, ttl time.Duration) (*Token, error)
+	// AuthenticateToken returns the user for the token in a "Bearer <token>" Authorization
+	// header, rejecting missing, malformed, unknown, expired and orphaned tokens
+	AuthenticateToken(r *http.Request) (*User, error)
+	// ValidToken reports whether the plain text token exists, has not expired and belongs to a user
+	ValidToken(plainText string) (bool, error)
+}
+
+// OrganizationRepository stores the organizations the application serves and their members.
+// It is not scoped to a tenant, since it is what a request's tenant is looked up in. Unlike
+// users, organizations have no version: they are never updated in place, and a membership is
+// only ever added or removed, so there is no edit for a concurrent one to overwrite.
+type OrganizationRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// Get returns the organization with the given id
+	Get(id int) (*Organization, error)
+	// GetBySlug returns the organization with the given slug
+	GetBySlug(slug string) (*Organization, error)
+	// ForUser returns the organizations the user is a member of, ordered by name
+	ForUser(userID int) ([]*Organization, error)
+	// Insert stores org and returns the new id
+	Insert(org Organization) (int, error)
+	// Delete removes the organization with the given id along with its memberships and the
+	// roles assigned within it
+	Delete(id int) error
+	// AddMember puts the user in the organization with the given role
+	AddMember(organizationID, userID int, role string) error
+	// RemoveMember takes the user out of the organization, if they are in it, along with the
+	// roles they were assigned there
+	RemoveMember(organizationID, userID int) error
+	// Membership returns the membership of the user in the organization, or ErrNotFound
+	Membership(organizationID, userID int) (*Membership, error)
+}
+
+// RoleRepository stores roles, the permissions they grant and the users they are assigned to.
+// Roles are assigned within the tenant the repository is scoped to and only apply there; the
+// roles assigned without a tenant only apply without one. Scoped to a tenant, users outside
+// the organization have no roles and cannot be assigned any.
+// Roles have no version, since they are never updated in place: granting, revoking, assigning
+// and unassigning each add or remove a single row, which concurrent changes cannot overwrite.
+type RoleRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// Get returns the role with the given id
+	Get(id int) (*Role, error)
+	// GetByName returns the role with the given name
+	GetByName(name string) (*Role, error)
+	// GetAll returns every role ordered by name
+	GetAll() ([]*Role, error)
+	// Insert stores role and returns the new id
+	Insert(role Role) (int, error)
+	// Delete removes the role with the given id, taking it away from its users
+	Delete(id int) error
+	// Grant gives the role the named permission, creating the permission if it is new
+	Grant(roleID int, permission string) error
+	// Revoke takes the named permission away from the role
+	Revoke(roleID int, permission string) error
+	// Assign gives the user the role; it fails with ErrNotFound for an unknown user
+	Assign(userID, roleID int) error
+	// Unassign takes the role away from the user
+	Unassign(userID, roleID int) error
+	// ForUser returns the roles of the user ordered by name
+	ForUser(userID int) ([]*Role, error)
+	// PermissionsForUser returns the names of every permission the roles of the user grant, sorted
+	PermissionsForUser(userID int) ([]string, error)
+}
+
+// AuditEventRepository reads the audit log. Events are written by the other repositories as a
+// side effect of every insert, update and delete of users, tokens, remember tokens, identities,
+// organizations, memberships and roles. Email verifications are left out: using one is recorded
+// as the update that activates its user. Scoped to a tenant, it only sees the events recorded
+// within that organization.
+type AuditEventRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// List returns a page of events, newest first unless opts sorts by a field of AuditFields
+	List(opts ListOptions) (*Page[*AuditEvent], error)
+}
+
+// RememberTokenRepository stores the tokens behind "remember me" cookies. They log a user in
+// before any tenant is known, so the repository is not scoped to one.
+type RememberTokenRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// Issue creates and stores a new remember token for the user and returns its plain text
+	Issue(userID int) (string, error)
+	// Valid reports whether plainText is an unexpired remember token issued to the user
+	Valid(userID int, plainText string) (bool, error)
+	// Rotate replaces a valid remember token of the user with a freshly issued one
+	Rotate(userID int, plainText string) (string, error)
+	// Delete removes the remember token matching plainText, if it exists
+	Delete(plainText string) error
+	// DeleteForUser revokes every remember token of the user, logging them out on all devices
+	DeleteForUser(userID int) error
+}
+
+// EmailVerificationRepository stores the tokens in the links that verify the email address of
+// a new user. They are used before the user has logged in, so it is not scoped to a tenant.
+type EmailVerificationRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// Issue creates a token for the user that expires after EmailVerificationLifetime, replacing
+	// any earlier one, and returns its plain text. It fails with ErrThrottled when the previous
+	// token was issued less than EmailVerificationInterval ago.
+	Issue(userID int) (string, error)
+	// IssueWithPassword is Issue for a token that also sets the password of the user to
+	// passwordHash when it activates them
+	IssueWithPassword(userID int, passwordHash string) (string, error)
+	// Verify uses up the plainText token, activates its user and returns their id. Activating
+	// revokes the tokens and remember tokens issued to the user so far. It fails with
+	// ErrInvalidToken for an unknown or used token and ErrExpiredToken for an expired one.
+	Verify(plainText string) (int, error)
+}
+
+// UserIdentityRepository stores the links between users and their accounts at social login
+// providers. They log users in, so it is not scoped to a tenant.
+type UserIdentityRepository interface {
+	// Table returns the name of the table backing the repository
+	Table() string
+	// Get returns the identity of the account with the given id at the provider, or ErrNotFound
+	Get(provider, providerUserID string) (*UserIdentity, error)
+	// ForUser returns the identities of the user ordered by provider
+	ForUser(userID int) ([]*UserIdentity, error)
+	// Insert links identity to its user and returns the new id. It fails with ErrNotFound for
+	// an unknown user and ErrIdentityLinked when the account is already linked.
+	Insert(identity UserIdentity) (int, error)
+	// Delete unlinks the identity with the given id, if it exists
+	Delete(id int) error
+}
@@ -0,0 +1,387 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// Role is the type for a row in the roles table. A role grants its users a set of
+// permissions, named after what they allow such as "users.delete".
+type Role struct {
+	ID          int       `db:"id,omitempty" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Permission is the type for a row in the permissions table
+type Permission struct {
+	ID        int       `db:"id,omitempty"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// rolePermission is the type for a row in the role_permissions table
+type rolePermission struct {
+	RoleID       int `db:"role_id"`
+	PermissionID int `db:"permission_id"`
+}
+
+// userRole is the type for a row in the user_roles table. A role is assigned within an
+// organization, and organization 0 holds the roles assigned outside of any.
+type userRole struct {
+	UserID         int       `db:"user_id"`
+	RoleID         int       `db:"role_id"`
+	OrganizationID int       `db:"organization_id"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// roleModel is the SQL implementation of RoleRepository
+type roleModel struct {
+	sqlSession
+}
+
+func (m *roleModel) Table() string {
+	return "roles"
+}
+
+func (m *roleModel) Get(id int) (*Role, error) {
+	var role Role
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := collection.Find(up.Cond{"id =": id}).One(&role); err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (m *roleModel) GetByName(name string) (*Role, error) {
+	var role Role
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := collection.Find(up.Cond{"name =": name}).One(&role); err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (m *roleModel) GetAll() ([]*Role, error) {
+	var roles []*Role
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := collection.Find().OrderBy("name").All(&roles); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (m *roleModel) Insert(role Role) (int, error) {
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = time.Now()
+
+	err := m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		res, err := collection.Insert(role)
+		if err != nil {
+			return err
+		}
+
+		role.ID = getInsertID(res.ID())
+
+		return tx.record(AuditInsert, m.Table(), role.ID, nil, role)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return role.ID, nil
+}
+
+func (m *roleModel) Delete(id int) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var role Role
+		if err := collection.Find(up.Cond{"id =": id}).One(&role); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return nil
+			}
+			return err
+		}
+
+		// the grants and assignments would go with the role anyway, but each is recorded
+		if err := tx.deleteGrants(up.Cond{"role_id =": id}); err != nil {
+			return err
+		}
+		if err := tx.deleteAssignments(up.Cond{"role_id =": id}); err != nil {
+			return err
+		}
+
+		if err := collection.Find(up.Cond{"id =": id}).Delete(); err != nil {
+			return err
+		}
+
+		return tx.record(AuditDelete, m.Table(), id, role, nil)
+	})
+}
+
+func (m *roleModel) Grant(roleID int, permission string) error {
+	return m.atomically(func(tx sqlSession) error {
+		if err := tx.roleExists(roleID); err != nil {
+			return err
+		}
+
+		permissions, err := tx.collection("permissions")
+		if err != nil {
+			return err
+		}
+
+		var p Permission
+		if err := permissions.Find(up.Cond{"name =": permission}).One(&p); err != nil {
+			if !errors.Is(err, up.ErrNoMoreRows) {
+				return err
+			}
+
+			res, err := permissions.Insert(Permission{Name: permission, CreatedAt: time.Now()})
+			if err != nil {
+				return err
+			}
+			p.ID = getInsertID(res.ID())
+		}
+
+		grants, err := tx.collection("role_permissions")
+		if err != nil {
+			return err
+		}
+
+		grant := up.Cond{"role_id =": roleID, "permission_id =": p.ID}
+		if granted, err := grants.Find(grant).Exists(); err != nil || granted {
+			return err
+		}
+
+		granted := rolePermission{RoleID: roleID, PermissionID: p.ID}
+		if _, err := grants.Insert(granted); err != nil {
+			return err
+		}
+
+		return tx.record(AuditInsert, "role_permissions", roleID, nil, granted)
+	})
+}
+
+func (m *roleModel) Revoke(roleID int, permission string) error {
+	return m.atomically(func(tx sqlSession) error {
+		permissions, err := tx.collection("permissions")
+		if err != nil {
+			return err
+		}
+
+		var p Permission
+		if err := permissions.Find(up.Cond{"name =": permission}).One(&p); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return nil
+			}
+			return err
+		}
+
+		return tx.deleteGrants(up.Cond{"role_id =": roleID, "permission_id =": p.ID})
+	})
+}
+
+func (m *roleModel) Assign(userID, roleID int) error {
+	return m.atomically(func(tx sqlSession) error {
+		users, err := tx.collection("users")
+		if err != nil {
+			return err
+		}
+
+		if exists, err := users.Find(notDeleted, up.Cond{"id =": userID}, tx.membersOnly("id")).Exists(); err != nil {
+			return err
+		} else if !exists {
+			return ErrNotFound
+		}
+
+		if err := tx.roleExists(roleID); err != nil {
+			return err
+		}
+
+		assignments, err := tx.collection("user_roles")
+		if err != nil {
+			return err
+		}
+
+		assigned := userRole{UserID: userID, RoleID: roleID, OrganizationID: tx.tenant, CreatedAt: time.Now()}
+
+		exists, err := assignments.Find(up.Cond{"user_id =": userID, "role_id =": roleID, "organization_id =": tx.tenant}).Exists()
+		if err != nil || exists {
+			return err
+		}
+
+		if _, err := assignments.Insert(assigned); err != nil {
+			return err
+		}
+
+		return tx.record(AuditInsert, "user_roles", userID, nil, assigned)
+	})
+}
+
+func (m *roleModel) Unassign(userID, roleID int) error {
+	return m.atomically(func(tx sqlSession) error {
+		return tx.deleteAssignments(up.Cond{"user_id =": userID, "role_id =": roleID, "organization_id =": tx.tenant})
+	})
+}
+
+func (m *roleModel) ForUser(userID int) ([]*Role, error) {
+	var roles []*Role
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(
+		up.Raw("id IN (SELECT role_id FROM user_roles WHERE user_id = ? AND organization_id = ?)", userID, m.tenant),
+		m.usersRoles(userID),
+	).OrderBy("name")
+	if err := res.All(&roles); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (m *roleModel) PermissionsForUser(userID int) ([]string, error) {
+	var rows []struct {
+		Name string `db:"name"`
+	}
+
+	// permissions decide what a user may do, so they are never read from a lagging replica
+	collection, err := m.collection("permissions")
+	if err != nil {
+		return nil, err
+	}
+
+	err = collection.Session().SQL().
+		Select(up.Raw("DISTINCT permissions.name AS name")).
+		From("permissions").
+		Join("role_permissions").On("role_permissions.permission_id = permissions.id").
+		Join("user_roles").On("user_roles.role_id = role_permissions.role_id").
+		Where(up.Cond{"user_roles.user_id": userID, "user_roles.organization_id": m.tenant}, m.usersRoles(userID)).
+		OrderBy("permissions.name").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.Name)
+	}
+
+	return names, nil
+}
+
+// roleExists returns ErrNotFound unless there is a role with the given id
+func (s sqlSession) roleExists(id int) error {
+	collection, err := s.collection("roles")
+	if err != nil {
+		return err
+	}
+
+	if exists, err := collection.Find(up.Cond{"id =": id}).Exists(); err != nil {
+		return err
+	} else if !exists {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// deleteGrants removes the grants matching cond, recording an audit event for each of them
+func (s sqlSession) deleteGrants(cond up.Cond) error {
+	collection, err := s.collection("role_permissions")
+	if err != nil {
+		return err
+	}
+
+	var grants []rolePermission
+	if err := collection.Find(cond).All(&grants); err != nil {
+		return err
+	}
+
+	for _, grant := range grants {
+		if err := collection.Find(up.Cond{"role_id =": grant.RoleID, "permission_id =": grant.PermissionID}).Delete(); err != nil {
+			return err
+		}
+
+		if err := s.record(AuditDelete, "role_permissions", grant.RoleID, grant, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteAssignments removes the assignments matching cond, recording an audit event for each of them
+func (s sqlSession) deleteAssignments(cond up.Cond) error {
+	collection, err := s.collection("user_roles")
+	if err != nil {
+		return err
+	}
+
+	var assignments []userRole
+	if err := collection.Find(cond).All(&assignments); err != nil {
+		return err
+	}
+
+	for _, assigned := range assignments {
+		err := collection.Find(up.Cond{
+			"user_id =":         assigned.UserID,
+			"role_id =":         assigned.RoleID,
+			"organization_id =": assigned.OrganizationID,
+		}).Delete()
+		if err != nil {
+			return err
+		}
+
+		if err := s.record(AuditDelete, "user_roles", assigned.UserID, assigned, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// usersRoles matches nothing when the user is outside the tenant of the session, so they have
+// no roles there, and everything otherwise
+func (m *roleModel) usersRoles(userID int) up.LogicalExpr {
+	if m.tenant == 0 {
+		return up.Cond{}
+	}
+
+	return up.Raw("? IN (SELECT user_id FROM memberships WHERE organization_id = ?)", userID, m.tenant)
+}
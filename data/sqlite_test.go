@@ -0,0 +1,243 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"myapp/data"
+	"myapp/data/datatest"
+	"myapp/migrations"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	// production cost makes every stored password take a noticeable moment to hash; only the
+	// tests of the hashers themselves need it
+	data.SetPasswordHasher(data.BcryptHasher{Cost: bcrypt.MinCost})
+}
+
+// sqliteDSN returns the DSN of the named in-memory sqlite database, shared by every connection to it
+func sqliteDSN(name string) string {
+	return fmt.Sprintf("file:%s?mode=memory&cache=shared&_fk=1", strings.ReplaceAll(name, "/", "_"))
+}
+
+// newSQLiteModels returns models backed by a migrated in-memory sqlite database
+// that lives for the duration of the test
+func newSQLiteModels(t *testing.T) data.Models {
+	t.Helper()
+
+	t.Setenv("DATABASE_TYPE", "sqlite")
+
+	m, err := data.New(openSQLite(t, sqliteDSN(t.Name())))
+	if err != nil {
+		t.Fatal("error creating models:", err)
+	}
+
+	return m
+}
+
+// openSQLite opens and migrates the sqlite database at dsn, closing it when the test ends
+func openSQLite(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+
+	testDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+
+	migrator, err := migrations.New(testDB, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal("error creating tables:", err)
+	}
+
+	return testDB
+}
+
+func TestSQLite(t *testing.T) {
+	datatest.Run(t, newSQLiteModels)
+}
+
+func TestOpen_SideBySide(t *testing.T) {
+	first, err := data.Open("sqlite", openSQLite(t, sqliteDSN(t.Name()+"_first")))
+	if err != nil {
+		t.Fatal("error creating models:", err)
+	}
+
+	second, err := data.Open("sqlite", openSQLite(t, sqliteDSN(t.Name()+"_second")))
+	if err != nil {
+		t.Fatal("error creating models:", err)
+	}
+
+	u := datatest.InsertUser(t, first, "john.smith@test.com")
+
+	if _, err := second.Users.GetByEmail(u.Email); err == nil {
+		t.Error("user inserted through one Models found through another")
+	}
+
+	datatest.InsertUser(t, second, "jane.doe@test.com")
+
+	if _, err := first.Users.GetByEmail(u.Email); err != nil {
+		t.Error("opening a second Models replaced the session of the first:", err)
+	}
+
+	if all, _ := first.Users.GetAll(); len(all) != 1 {
+		t.Errorf("expected 1 user in the first database, got %d", len(all))
+	}
+}
+
+func TestSQLite_MigrateDown(t *testing.T) {
+	m := newSQLiteModels(t)
+	u := datatest.InsertUser(t, m, "john.smith@test.com")
+
+	testDB, err := sql.Open("sqlite3", sqliteDSN(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testDB.Close()
+
+	migrator, _ := migrations.New(testDB, "sqlite")
+
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a hash longer than the column of 0009 was widened from stops its rollback
+	if _, err := testDB.Exec("UPDATE users SET password = ? WHERE id = ?", strings.Repeat("x", 97), u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Down(context.Background(), len(statuses)); err == nil || !strings.Contains(err.Error(), "0009_") {
+		t.Fatalf("expected the rollback of 0009 to fail with a long hash stored, got %v", err)
+	}
+	if _, err := testDB.Exec("UPDATE users SET password = ? WHERE id = ?", strings.Repeat("x", 60), u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := migrator.Down(context.Background(), len(statuses))
+	if err != nil || len(reverted) != 9 { // 0001 to 0009
+		t.Fatalf("failed to roll back the remaining migrations: %v", err)
+	}
+
+	if _, err := testDB.Exec("SELECT id FROM users"); err == nil {
+		t.Error("users table still exists after rolling back all migrations")
+	}
+}
+
+func TestSQLite_AdoptExistingSchema(t *testing.T) {
+	testDB, err := sql.Open("sqlite3", sqliteDSN(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testDB.Close()
+
+	// the users and remember_tokens tables as they were made before migrations existed
+	_, err = testDB.Exec(`CREATE TABLE users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    first_name varchar(255) NOT NULL,
+    last_name varchar(255) NOT NULL,
+    user_active integer NOT NULL DEFAULT 0,
+    email varchar(255) NOT NULL UNIQUE,
+    password varchar(60) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE remember_tokens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    remember_token varchar(100) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO users (first_name, last_name, user_active, email, password)
+VALUES ('John', 'Smith', 1, 'existing@test.com', 'not a real hash');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := data.Open("sqlite", openSQLite(t, sqliteDSN(t.Name())))
+	if err != nil {
+		t.Fatal("error creating models:", err)
+	}
+
+	u, err := m.Users.GetByEmail("existing@test.com")
+	if err != nil {
+		t.Fatal("user of the adopted schema lost:", err)
+	}
+	if u.Version != 1 {
+		t.Errorf("expected the adopted user at version 1, got %d", u.Version)
+	}
+
+	datatest.InsertUser(t, m, "john.smith@test.com")
+}
+
+func TestOpen_Replica(t *testing.T) {
+	primaryDB := openSQLite(t, sqliteDSN(t.Name()+"_primary"))
+	replicaDB := openSQLite(t, sqliteDSN(t.Name()+"_replica"))
+
+	m, err := data.Open("sqlite", primaryDB, replicaDB)
+	if err != nil {
+		t.Fatal("error creating models:", err)
+	}
+
+	// the databases are not replicated, so whether a read finds the user shows where it went
+	id, err := m.Users.Insert(data.User{FirstName: "John", LastName: "Smith", Email: "john.smith@test.com", Password: "kettle-harbor-quilt-42"})
+	if err != nil {
+		t.Fatal("failed to insert new user record:", err)
+	}
+
+	if _, err := m.Users.Get(id); err == nil {
+		t.Error("read went to the primary, expected the replica")
+	}
+
+	if all, _ := m.Users.GetAll(); len(all) != 0 {
+		t.Error("listing went to the primary, expected the replica")
+	}
+
+	if err := m.Users.ResetPassword(id, "lantern-meadow-cobalt-7"); err != nil {
+		t.Error("write depending on a read went to the replica:", err)
+	}
+
+	err = m.WithTx(context.Background(), func(tx data.Models) error {
+		_, err := tx.Users.Get(id)
+		return err
+	})
+	if err != nil {
+		t.Error("read inside a transaction went to the replica:", err)
+	}
+
+	if _, err := m.WithContext(data.WithPrimaryReads(context.Background())).Users.Get(id); err != nil {
+		t.Error("read with primary reads requested went to the replica:", err)
+	}
+
+	perRequest := m.WithContext(context.Background())
+
+	if _, err := perRequest.Users.Get(id); err == nil {
+		t.Error("read before any write went to the primary, expected the replica")
+	}
+
+	u, _ := m.WithContext(data.WithPrimaryReads(context.Background())).Users.Get(id)
+	u.LastName = "Jones"
+	if err := perRequest.Users.Update(*u); err != nil {
+		t.Fatal("failed to update user:", err)
+	}
+
+	if u, err := perRequest.Users.Get(id); err != nil || u.LastName != "Jones" {
+		t.Error("read after a write did not see the write:", err)
+	}
+
+	if _, err := m.Users.Get(id); err == nil {
+		t.Error("write through a per-request copy sent the reads of the shared models to the primary")
+	}
+
+	if _, err := data.Open("sqlite", primaryDB, replicaDB, replicaDB); err == nil {
+		t.Error("two replica pools accepted, expected an error")
+	}
+}
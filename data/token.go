@@ -0,0 +1,322 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// tokenLength is the length of a base32 encoded plain text token generated from 16 random bytes
+const tokenLength = 26
+
+var (
+	ErrNoAuthHeader      = errors.New("no authorization header received")
+	ErrInvalidAuthHeader = errors.New("malformed authorization header")
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrExpiredToken      = errors.New("token has expired")
+)
+
+// Token is the type for a row in the tokens table. Only the SHA-256 hash of a token
+// is stored; the plain text is only available on the value returned by GenerateToken.
+// Scopes lists what the token may be used for, separated by spaces; a token without any may
+// be used for everything, as tokens could before scopes existed.
+type Token struct {
+	ID        int       `db:"id,omitempty" json:"id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	FirstName string    `db:"first_name" json:"first_name"`
+	Email     string    `db:"email" json:"email"`
+	PlainText string    `db:"-" json:"token"`
+	Hash      []byte    `db:"token_hash" json:"-"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	Expires   time.Time `db:"expiry" json:"expiry"`
+	Scopes    string    `db:"scopes" json:"scopes"`
+}
+
+// Expired reports whether the token can no longer be used
+func (t *Token) Expired() bool {
+	return t.Expires.Before(time.Now())
+}
+
+// ScopeList returns the scopes of the token
+func (t *Token) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// HasScope reports whether the token may be used for scope
+func (t *Token) HasScope(scope string) bool {
+	scopes := t.ScopeList()
+	return len(scopes) == 0 || slices.Contains(scopes, scope)
+}
+
+// NewToken creates a new random token for the given user which expires after ttl
+func NewToken(userID int, ttl time.Duration) (*Token, error) {
+	token := &Token{
+		UserID:  userID,
+		Expires: time.Now().Add(ttl),
+	}
+
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	token.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Hash = HashToken(token.PlainText)
+
+	return token, nil
+}
+
+// HashToken returns the SHA-256 hash under which a plain text token is stored
+func HashToken(plainText string) []byte {
+	hash := sha256.Sum256([]byte(plainText))
+	return hash[:]
+}
+
+// ValidTokenFormat reports whether plainText could have been generated by NewToken
+func ValidTokenFormat(plainText string) bool {
+	if len(plainText) != tokenLength {
+		return false
+	}
+
+	_, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(plainText)
+	return err == nil
+}
+
+// BearerToken extracts the plain text token from a "Bearer <token>" Authorization header
+func BearerToken(r *http.Request) (string, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader == "" {
+		return "", ErrNoAuthHeader
+	}
+
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", ErrInvalidAuthHeader
+	}
+
+	if !ValidTokenFormat(headerParts[1]) {
+		return "", ErrInvalidToken
+	}
+
+	return headerParts[1], nil
+}
+
+// tokenModel is the SQL implementation of TokenRepository. Looking a token up by its plain
+// text always reads from the primary, so a deleted token stops working straight away.
+type tokenModel struct {
+	sqlSession
+}
+
+func (m *tokenModel) Table() string {
+	return "tokens"
+}
+
+func (m *tokenModel) GetUserForToken(plainText string) (*User, error) {
+	theToken, err := m.GetByToken(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	users := userModel{m.sqlSession}
+	var u User
+
+	collection, err := m.collection(users.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(notDeleted, up.Cond{"id =": theToken.UserID}, m.membersOnly("id"))
+	if err := res.One(&u); err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	theToken.PlainText = plainText
+	u.Token = *theToken
+
+	return &u, nil
+}
+
+func (m *tokenModel) GetTokensForUser(id int) ([]*Token, error) {
+	var tokens []*Token
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Cond{"user_id =": id}, m.membersOnly("user_id")).OrderBy("created_at desc")
+	if err := res.All(&tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m *tokenModel) List(opts ListOptions) (*Page[*Token], error) {
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	return listCollection(collection, m.membersOnly("user_id"), TokenFields, opts, "-created_at")
+}
+
+func (m *tokenModel) Get(id int) (*Token, error) {
+	var token Token
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Cond{"id =": id}, m.membersOnly("user_id"))
+	if err := res.One(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (m *tokenModel) GetByToken(plainText string) (*Token, error) {
+	var token Token
+
+	collection, err := m.collection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(up.Cond{"token_hash =": HashToken(plainText)}, m.membersOnly("user_id"))
+	if err := res.One(&token); err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (m *tokenModel) Delete(id int) error {
+	return m.deleteWhere(up.Cond{"id =": id})
+}
+
+func (m *tokenModel) DeleteByToken(plainText string) error {
+	return m.deleteWhere(up.Cond{"token_hash =": HashToken(plainText)})
+}
+
+// deleteWhere removes the tokens matching cond, recording an audit event for each of them
+func (m *tokenModel) deleteWhere(cond up.Cond) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var tokens []Token
+		if err := collection.Find(cond, tx.membersOnly("user_id")).All(&tokens); err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			if err := collection.Find(up.Cond{"id =": token.ID}).Delete(); err != nil {
+				return err
+			}
+
+			if err := tx.record(AuditDelete, m.Table(), token.ID, token, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *tokenModel) Insert(token Token, u User) error {
+	if token.UserID == 0 {
+		token.UserID = u.ID
+	}
+
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = time.Now()
+	token.FirstName = u.FirstName
+	token.Email = u.Email
+
+	return m.atomically(func(tx sqlSession) error {
+		if tx.tenant != 0 {
+			users, err := tx.collection("users")
+			if err != nil {
+				return err
+			}
+
+			if member, err := users.Find(up.Cond{"id =": token.UserID}, tx.membersOnly("id")).Exists(); err != nil {
+				return err
+			} else if !member {
+				return ErrNotFound
+			}
+		}
+
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		res, err := collection.Insert(token)
+		if err != nil {
+			return err
+		}
+
+		token.ID = getInsertID(res.ID())
+
+		return tx.record(AuditInsert, m.Table(), token.ID, nil, token)
+	})
+}
+
+func (m *tokenModel) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
+	return NewToken(userID, ttl)
+}
+
+func (m *tokenModel) AuthenticateToken(r *http.Request) (*User, error) {
+	plainText, err := BearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := m.GetUserForToken(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Token.Expired() {
+		return nil, ErrExpiredToken
+	}
+
+	return u, nil
+}
+
+func (m *tokenModel) ValidToken(plainText string) (bool, error) {
+	if !ValidTokenFormat(plainText) {
+		return false, ErrInvalidToken
+	}
+
+	u, err := m.GetUserForToken(plainText)
+	if err != nil {
+		return false, err
+	}
+
+	if u.Token.Expired() {
+		return false, ErrExpiredToken
+	}
+
+	return true, nil
+}
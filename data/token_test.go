@@ -0,0 +1,84 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewToken(t *testing.T) {
+	token, err := NewToken(1, time.Hour)
+	if err != nil {
+		t.Fatal("error generating token:", err)
+	}
+
+	if len(token.PlainText) != tokenLength {
+		t.Errorf("wrong token length; expected %d, got %d", tokenLength, len(token.PlainText))
+	}
+
+	hash := sha256.Sum256([]byte(token.PlainText))
+	if !bytes.Equal(token.Hash, hash[:]) {
+		t.Error("token hash is not the SHA-256 of the plain text")
+	}
+
+	if token.UserID != 1 || token.Expired() {
+		t.Error("token generated with wrong user id or expiry")
+	}
+
+	other, _ := NewToken(1, time.Hour)
+	if other.PlainText == token.PlainText {
+		t.Error("two generated tokens are identical")
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	token := Token{Scopes: "users:read  tokens:write"}
+
+	if got := token.ScopeList(); len(got) != 2 || got[0] != "users:read" || got[1] != "tokens:write" {
+		t.Errorf("wrong scope list %q", got)
+	}
+	if !token.HasScope("users:read") || !token.HasScope("tokens:write") {
+		t.Error("token lacks a scope it was given")
+	}
+	if token.HasScope("users:write") || token.HasScope("users") {
+		t.Error("token has a scope it was not given")
+	}
+
+	unscoped := Token{}
+	if !unscoped.HasScope("users:write") {
+		t.Error("token without scopes refused a scope")
+	}
+}
+
+var headerData = []struct {
+	name   string
+	header string
+	err    error
+}{
+	{"missing", "", ErrNoAuthHeader},
+	{"no_scheme", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", ErrInvalidAuthHeader},
+	{"wrong_scheme", "Basic ABCDEFGHIJKLMNOPQRSTUVWXYZ", ErrInvalidAuthHeader},
+	{"lowercase_scheme", "bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", ErrInvalidAuthHeader},
+	{"extra_space", "Bearer  ABCDEFGHIJKLMNOPQRSTUVWXYZ", ErrInvalidAuthHeader},
+	{"extra_part", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ x", ErrInvalidAuthHeader},
+	{"short_token", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXY", ErrInvalidToken},
+	{"not_base32", "Bearer abcdefghijklmnopqrstuvwxyz", ErrInvalidToken},
+	{"valid", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", nil},
+}
+
+func TestBearerToken(t *testing.T) {
+	for _, tt := range headerData {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Add("Authorization", tt.header)
+		}
+
+		_, err := BearerToken(req)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	db2 "github.com/upper/db/v4"
+)
+
+// errTxPanic rolls a transaction back when the function running in it panics
+var errTxPanic = errors.New("data: panic in transaction")
+
+// WithTx runs fn with a copy of every model bound to a single transaction. The transaction
+// is committed when fn returns nil and rolled back when it returns an error or panics; a
+// panic is passed on once the rollback is done. Calling WithTx on the models handed to fn
+// runs the inner function in a savepoint, so only its own changes are undone on failure.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	switch {
+	case m.session == nil && m.Backend != nil:
+		return m.Backend.WithTx(ctx, fn)
+	case m.session == nil:
+		return ErrNoDatabase
+	case m.inTx:
+		return m.withSavepoint(ctx, fn)
+	}
+
+	var panicked any
+
+	err := m.session.TxContext(ctx, func(session db2.Session) error {
+		tx := Models{session: session, actor: m.actor, tenant: m.tenant, inTx: true}
+		tx = tx.withSQL(sqlSession{session: session, actor: m.actor, tenant: m.tenant, inTx: true})
+
+		return runTx(tx, fn, &panicked)
+	}, nil)
+
+	if panicked != nil {
+		panic(panicked)
+	}
+
+	return err
+}
+
+// withSavepoint runs fn inside a savepoint of the transaction the models are bound to
+func (m Models) withSavepoint(ctx context.Context, fn func(tx Models) error) error {
+	nested := m
+	nested.txDepth++
+
+	name := fmt.Sprintf("models_savepoint_%d", nested.txDepth)
+
+	if _, err := m.session.SQL().ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	var panicked any
+
+	if err := runTx(nested, fn, &panicked); err != nil {
+		if _, rollbackErr := m.session.SQL().ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rollbackErr != nil {
+			err = fmt.Errorf("%v: %w", rollbackErr, err)
+		}
+
+		if panicked != nil {
+			panic(panicked)
+		}
+
+		return err
+	}
+
+	_, err := m.session.SQL().ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// runTx calls fn, turning a panic into an error so the caller rolls back before re-panicking
+func runTx(tx Models, fn func(tx Models) error, panicked *any) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			*panicked = p
+			err = errTxPanic
+		}
+	}()
+
+	return fn(tx)
+}
@@ -0,0 +1,471 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// notDeleted limits a query on the users table to users that have not been soft deleted
+var notDeleted = up.Cond{"deleted_at IS": nil}
+
+// User is the type for a row in the users table
+type User struct {
+	ID        int        `db:"id,omitempty"`
+	FirstName string     `db:"first_name"`
+	LastName  string     `db:"last_name"`
+	Email     string     `db:"email"`
+	Active    int        `db:"user_active"`
+	Password  string     `db:"password"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	Version   int        `db:"version"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	Token     Token      `db:"-"`
+
+	// users is the repository the user was loaded from, where PasswordMatches saves upgraded hashes
+	users UserRepository
+}
+
+// Deleted reports whether the user has been soft deleted
+func (u *User) Deleted() bool {
+	return u.DeletedAt != nil
+}
+
+// SetRepository attaches the repository the user was loaded from. Repositories call it on the
+// users they return, so a successful PasswordMatches can upgrade an outdated hash.
+func (u *User) SetRepository(users UserRepository) {
+	u.users = users
+}
+
+// errDetachedUser is returned by the methods of a user that was not loaded from a repository
+var errDetachedUser = errors.New("data: user was not loaded from a repository")
+
+// Update saves theUser through the repository u was loaded from, as UserRepository.Update does
+func (u *User) Update(theUser User) error {
+	if u.users == nil {
+		return errDetachedUser
+	}
+
+	return u.users.Update(theUser)
+}
+
+// PasswordMatches compares plainText with the password hash stored for the user. When it
+// matches a hash made by another algorithm or with other parameters than the current
+// PasswordHasher, the password is rehashed and saved. A failed upgrade is retried at the next match.
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	matches, err := VerifyPassword(u.Password, plainText)
+	if err != nil || !matches {
+		return false, err
+	}
+
+	if u.users != nil && PasswordHasher().NeedsRehash(u.Password) {
+		if newHash, err := HashPassword(plainText); err == nil {
+			if err := u.users.UpdatePasswordHash(u.ID, u.Password, newHash); err == nil {
+				u.Password = newHash
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// userModel is the SQL implementation of UserRepository
+type userModel struct {
+	sqlSession
+}
+
+func (m *userModel) Table() string {
+	return "users"
+}
+
+func (m *userModel) GetAll() ([]*User, error) {
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*User
+
+	res := collection.Find(notDeleted, m.membersOnly("id")).OrderBy("last_name")
+	if err := res.All(&all); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func (m *userModel) List(opts ListOptions) (*Page[*User], error) {
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	return listCollection(collection, up.And(notDeleted, m.membersOnly("id")), UserFields, opts, "last_name")
+}
+
+func (m *userModel) ListDeleted(opts ListOptions) (*Page[*User], error) {
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	return listCollection(collection, up.And(up.Cond{"deleted_at IS NOT": nil}, m.membersOnly("id")), UserFields, opts, "last_name")
+}
+
+func (m *userModel) GetByEmail(email string) (*User, error) {
+	var theUser User
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(notDeleted, up.Cond{"email =": email}, m.membersOnly("id"))
+	if err := res.One(&theUser); err != nil {
+		return nil, err
+	}
+
+	if err := m.loadToken(&theUser); err != nil {
+		return nil, err
+	}
+	theUser.SetRepository(m)
+
+	return &theUser, nil
+}
+
+func (m *userModel) Get(id int) (*User, error) {
+	var theUser User
+
+	collection, err := m.readCollection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	res := collection.Find(notDeleted, up.Cond{"id =": id}, m.membersOnly("id"))
+	if err := res.One(&theUser); err != nil {
+		return nil, err
+	}
+
+	if err := m.loadToken(&theUser); err != nil {
+		return nil, err
+	}
+	theUser.SetRepository(m)
+
+	return &theUser, nil
+}
+
+func (m *userModel) Update(theUser User) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var before User
+		if err := collection.Find(notDeleted, up.Cond{"id =": theUser.ID}, tx.membersOnly("id")).One(&before); err != nil {
+			return err
+		}
+
+		if before.Version != theUser.Version {
+			return ErrStaleRecord
+		}
+
+		theUser.UpdatedAt = time.Now()
+		theUser.Version++
+
+		res, err := collection.Session().SQL().
+			Update(m.Table()).
+			Set(theUser).
+			Where(notDeleted, up.Cond{"id =": theUser.ID, "version =": before.Version}).
+			Exec()
+		if err != nil {
+			return err
+		}
+
+		if updated, err := res.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return ErrStaleRecord
+		}
+
+		return tx.record(AuditUpdate, m.Table(), theUser.ID, before, theUser)
+	})
+}
+
+func (m *userModel) UpdatePasswordHash(id int, oldHash, newHash string) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var before User
+		if err := collection.Find(notDeleted, up.Cond{"id =": id}, tx.membersOnly("id")).One(&before); err != nil {
+			return err
+		}
+
+		after := before
+		after.Password = newHash
+		after.UpdatedAt = time.Now()
+
+		res, err := collection.Session().SQL().
+			Update(m.Table()).
+			Set("password", newHash, "updated_at", after.UpdatedAt).
+			Where(notDeleted, up.Cond{"id =": id, "password =": oldHash}).
+			Exec()
+		if err != nil {
+			return err
+		}
+
+		if updated, err := res.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return ErrStaleRecord
+		}
+
+		return tx.record(AuditUpdate, m.Table(), id, before, after)
+	})
+}
+
+func (m *userModel) Delete(id int) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var before User
+		if err := collection.Find(notDeleted, up.Cond{"id =": id}, tx.membersOnly("id")).One(&before); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return nil
+			}
+			return err
+		}
+
+		// revoke access first, so a failure part way never leaves a deleted user able to log in
+		tokens := tokenModel{tx}
+		if err := tokens.deleteWhere(up.Cond{"user_id =": id}); err != nil {
+			return err
+		}
+
+		rememberTokens := rememberTokenModel{tx}
+		if err := rememberTokens.DeleteForUser(id); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		after := before
+		after.DeletedAt = &now
+		after.UpdatedAt = now
+		after.Version++
+
+		_, err = collection.Session().SQL().
+			Update(m.Table()).
+			Set("deleted_at", now, "updated_at", now, "version", up.Raw("version + 1")).
+			Where(notDeleted, up.Cond{"id =": id}).
+			Exec()
+		if err != nil {
+			return err
+		}
+
+		return tx.record(AuditDelete, m.Table(), id, before, after)
+	})
+}
+
+func (m *userModel) Restore(id int) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var before User
+		if err := collection.Find(up.Cond{"id =": id, "deleted_at IS NOT": nil}, tx.membersOnly("id")).One(&before); err != nil {
+			return err
+		}
+
+		after := before
+		after.DeletedAt = nil
+		after.UpdatedAt = time.Now()
+		after.Version++
+
+		_, err = collection.Session().SQL().
+			Update(m.Table()).
+			Set("deleted_at", nil, "updated_at", after.UpdatedAt, "version", up.Raw("version + 1")).
+			Where(up.Cond{"id =": id, "deleted_at IS NOT": nil}).
+			Exec()
+		if err != nil {
+			return err
+		}
+
+		return tx.record(AuditRestore, m.Table(), id, before, after)
+	})
+}
+
+func (m *userModel) Purge(retention time.Duration) (int, error) {
+	var purged []User
+
+	err := m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		res := collection.Find(up.Cond{"deleted_at <": time.Now().Add(-retention)}, tx.membersOnly("id"))
+		if err := res.All(&purged); err != nil {
+			return err
+		}
+
+		for _, u := range purged {
+			if err := collection.Find(up.Cond{"id =": u.ID}).Delete(); err != nil {
+				return err
+			}
+
+			if err := tx.record(AuditPurge, m.Table(), u.ID, u, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(purged), nil
+}
+
+func (m *userModel) Insert(theUser User) (int, error) {
+	if err := ValidatePassword(theUser.Password, theUser); err != nil {
+		return 0, err
+	}
+
+	newHash, err := HashPassword(theUser.Password)
+	if err != nil {
+		return 0, err
+	}
+	theUser.Password = newHash
+
+	return m.InsertWithHash(theUser)
+}
+
+func (m *userModel) InsertWithHash(theUser User) (int, error) {
+	theUser.CreatedAt = time.Now()
+	theUser.UpdatedAt = time.Now()
+	theUser.Version = 1
+
+	err := m.atomically(func(tx sqlSession) error {
+		if tx.tenant == NoOrganization {
+			return ErrNoOrganization
+		}
+
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		// soft deleted users keep their address until they are purged, so they can be restored
+		if taken, err := collection.Find(up.Cond{"email =": theUser.Email}).Exists(); err != nil {
+			return err
+		} else if taken {
+			return ErrDuplicateEmail
+		}
+
+		res, err := collection.Insert(theUser)
+		if err != nil {
+			return err
+		}
+
+		theUser.ID = getInsertID(res.ID())
+
+		if tx.tenant != 0 {
+			orgs := organizationModel{tx}
+			if err := orgs.AddMember(tx.tenant, theUser.ID, RoleMember); err != nil {
+				return err
+			}
+		}
+
+		return tx.record(AuditInsert, m.Table(), theUser.ID, nil, theUser)
+	})
+	if err != nil && !errors.Is(err, ErrDuplicateEmail) && !errors.Is(err, ErrNoOrganization) && m.emailTaken(theUser.Email) {
+		// another insert with the address won the race and the unique index refused this one
+		return 0, ErrDuplicateEmail
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return theUser.ID, nil
+}
+
+// emailTaken reports whether a user, soft deleted or not, has the email address on the primary
+func (m *userModel) emailTaken(email string) bool {
+	collection, err := m.collection(m.Table())
+	if err != nil {
+		return false
+	}
+
+	taken, err := collection.Find(up.Cond{"email =": email}).Exists()
+	return err == nil && taken
+}
+
+func (m *userModel) ResetPassword(id int, password string) error {
+	// the password is checked against the user before hashing it, outside the transaction,
+	// but read from the primary like the rest of the write
+	current := userModel{m.primary()}
+	theUser, err := current.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidatePassword(password, *theUser); err != nil {
+		return err
+	}
+
+	newHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return m.atomically(func(tx sqlSession) error {
+		users := userModel{tx}
+
+		theUser, err := users.Get(id)
+		if err != nil {
+			return err
+		}
+
+		theUser.Password = newHash
+
+		if err := users.Update(*theUser); err != nil {
+			return err
+		}
+
+		rememberTokens := rememberTokenModel{tx}
+		return rememberTokens.DeleteForUser(id)
+	})
+}
+
+// loadToken attaches the most recently created unexpired token of the user, if there is one
+func (m *userModel) loadToken(u *User) error {
+	tokens := tokenModel{m.sqlSession}
+	var token Token
+
+	collection, err := m.readCollection(tokens.Table())
+	if err != nil {
+		return err
+	}
+
+	res := collection.Find(up.Cond{"user_id =": u.ID, "expiry >": time.Now()}).OrderBy("created_at desc")
+	if err := res.One(&token); err != nil {
+		if !errors.Is(err, up.ErrNilRecord) && !errors.Is(err, up.ErrNoMoreRows) {
+			return err
+		}
+	}
+
+	u.Token = token
+
+	return nil
+}
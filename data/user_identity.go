@@ -0,0 +1,127 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	up "github.com/upper/db/v4"
+)
+
+// ErrIdentityLinked is returned when linking an account at a provider that is already linked to a user
+var ErrIdentityLinked = errors.New("data: provider account is already linked to a user")
+
+// UserIdentity is the type for a row in the user_identities table. It links a user to their
+// account at a social login provider, identified by the id the provider gives it.
+type UserIdentity struct {
+	ID             int       `db:"id,omitempty"`
+	UserID         int       `db:"user_id"`
+	Provider       string    `db:"provider"`
+	ProviderUserID string    `db:"provider_user_id"`
+	Email          string    `db:"email"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// userIdentityModel is the SQL implementation of UserIdentityRepository. Like remember tokens,
+// identities log users in, so it never reads from a replica.
+type userIdentityModel struct {
+	sqlSession
+}
+
+func (m *userIdentityModel) Table() string {
+	return "user_identities"
+}
+
+func (m *userIdentityModel) Get(provider, providerUserID string) (*UserIdentity, error) {
+	collection, err := m.collection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	var identity UserIdentity
+	if err := collection.Find(up.Cond{"provider =": provider, "provider_user_id =": providerUserID}).One(&identity); err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (m *userIdentityModel) ForUser(userID int) ([]*UserIdentity, error) {
+	collection, err := m.collection(m.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []*UserIdentity
+	if err := collection.Find(up.Cond{"user_id =": userID}).OrderBy("provider").All(&identities); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (m *userIdentityModel) Insert(identity UserIdentity) (int, error) {
+	identity.CreatedAt = time.Now()
+
+	err := m.atomically(func(tx sqlSession) error {
+		users, err := tx.collection("users")
+		if err != nil {
+			return err
+		}
+
+		if exists, err := users.Find(notDeleted, up.Cond{"id =": identity.UserID}).Exists(); err != nil {
+			return err
+		} else if !exists {
+			return ErrNotFound
+		}
+
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		linked, err := collection.Find(up.Cond{"provider =": identity.Provider, "provider_user_id =": identity.ProviderUserID}).Exists()
+		if err != nil {
+			return err
+		}
+		if linked {
+			return ErrIdentityLinked
+		}
+
+		res, err := collection.Insert(identity)
+		if err != nil {
+			return err
+		}
+
+		identity.ID = getInsertID(res.ID())
+
+		return tx.record(AuditInsert, m.Table(), identity.ID, nil, identity)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return identity.ID, nil
+}
+
+func (m *userIdentityModel) Delete(id int) error {
+	return m.atomically(func(tx sqlSession) error {
+		collection, err := tx.collection(m.Table())
+		if err != nil {
+			return err
+		}
+
+		var identity UserIdentity
+		if err := collection.Find(up.Cond{"id =": id}).One(&identity); err != nil {
+			if errors.Is(err, up.ErrNoMoreRows) {
+				return nil
+			}
+			return err
+		}
+
+		if err := collection.Find(up.Cond{"id =": id}).Delete(); err != nil {
+			return err
+		}
+
+		return tx.record(AuditDelete, m.Table(), id, identity, nil)
+	})
+}
@@ -0,0 +1,37 @@
+package data
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUser_PasswordMatchesHash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := User{Password: string(hash)}
+
+	matches, err := u.PasswordMatches("password")
+	if err != nil {
+		t.Error("failed to validate password:", err)
+	}
+	if !matches {
+		t.Error("password does not match, expected a match")
+	}
+
+	matches, err = u.PasswordMatches("wrongpassword")
+	if err != nil {
+		t.Error("failed to validate password:", err)
+	}
+	if matches {
+		t.Error("used incorrect password, expected no match, got a match")
+	}
+
+	u.Password = "not a bcrypt hash"
+	if _, err := u.PasswordMatches("password"); err == nil {
+		t.Error("used malformed hash, expected an error, received none")
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/data"
+	"myapp/middleware"
+	"net/http"
+	"time"
+)
+
+// apiUser is a user as the API shows it, without their password
+type apiUser struct {
+	ID        int       `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Active    int       `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+// newAPIUser returns u as the API shows it
+func newAPIUser(u *data.User) apiUser {
+	return apiUser{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Email:     u.Email,
+		Active:    u.Active,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
+	}
+}
+
+// ApiMe answers with the user and scopes of the API token the request was made with
+func (h *Handlers) ApiMe(w http.ResponseWriter, r *http.Request) {
+	u, ok := userFrom(r)
+	if !ok {
+		h.App.ErrorUnauthorized(w, r)
+		return
+	}
+
+	var payload struct {
+		ID        int       `json:"id"`
+		FirstName string    `json:"first_name"`
+		LastName  string    `json:"last_name"`
+		Email     string    `json:"email"`
+		Scopes    []string  `json:"scopes"`
+		Expires   time.Time `json:"token_expiry"`
+	}
+	payload.ID = u.ID
+	payload.FirstName = u.FirstName
+	payload.LastName = u.LastName
+	payload.Email = u.Email
+	payload.Scopes = u.Token.ScopeList()
+	payload.Expires = u.Token.Expires
+
+	if err := h.App.WriteJSON(w, http.StatusOK, payload); err != nil {
+		h.App.ErrorLog.Println(err)
+	}
+}
+
+// ApiUsers answers with a page of the users, selected by the page_size, page, cursor and sort
+// query parameters and filtered by those naming a filterable field of data.UserFields, such as
+// ?last_name=Smith&sort=-email. Other parameters are ignored.
+func (h *Handlers) ApiUsers(w http.ResponseWriter, r *http.Request) {
+	var page *data.Page[*data.User]
+
+	opts, err := data.ListOptionsFromQuery(r.URL.Query(), data.UserFields)
+	if err == nil {
+		page, err = h.models(r).Users.List(opts)
+	}
+
+	switch {
+	case errors.Is(err, data.ErrInvalidListOptions):
+		middleware.WriteJSONError(h.App, w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error listing users:", err)
+		middleware.WriteJSONError(h.App, w, http.StatusInternalServerError, "Something went wrong.")
+		return
+	}
+
+	payload := data.Page[apiUser]{
+		Items:      make([]apiUser, 0, len(page.Items)),
+		Total:      page.Total,
+		PageSize:   page.PageSize,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+	for _, u := range page.Items {
+		payload.Items = append(payload.Items, newAPIUser(u))
+	}
+
+	if err := h.App.WriteJSON(w, http.StatusOK, payload); err != nil {
+		h.App.ErrorLog.Println(err)
+	}
+}
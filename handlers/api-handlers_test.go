@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestApiMe(t *testing.T) {
+	u := insertUser(t, "me@api.test", true)
+	u.Token.Scopes = "users:read"
+
+	req, _ := http.NewRequest("GET", "/api/me", nil)
+	rr := httptest.NewRecorder()
+	testHandlers.ApiMe(rr, req.WithContext(data.WithUser(req.Context(), u)))
+
+	var payload struct {
+		ID     int      `json:"id"`
+		Email  string   `json:"email"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal("response is not JSON:", err)
+	}
+	if rr.Code != http.StatusOK || payload.ID != u.ID || payload.Email != u.Email || len(payload.Scopes) != 1 {
+		t.Errorf("wrong answer for the token user: %d %+v", rr.Code, payload)
+	}
+
+	rr = httptest.NewRecorder()
+	testHandlers.ApiMe(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 without a token user, got %d", rr.Code)
+	}
+}
+
+// apiToken returns a new API token of the user with the given scopes, in plain text
+func apiToken(t *testing.T, u *data.User, scopes string) string {
+	t.Helper()
+
+	token, err := testHandlers.Models.Tokens.GenerateToken(u.ID, time.Hour)
+	if err != nil {
+		t.Fatal("error generating token:", err)
+	}
+	token.Scopes = scopes
+
+	if err := testHandlers.Models.Tokens.Insert(*token, *u); err != nil {
+		t.Fatal("error inserting token:", err)
+	}
+
+	return token.PlainText
+}
+
+// getAPI makes a GET request for target through the API routes with the token
+func getAPI(target, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	getAPIRoutes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+// inTenant returns the models of the handler tests scoped to the organization with the id
+func inTenant(organizationID int) data.Models {
+	return testHandlers.Models.WithContext(data.WithTenant(context.Background(), organizationID))
+}
+
+func TestApiUsers(t *testing.T) {
+	orgID, err := testHandlers.Models.Organizations.Insert(data.Organization{Name: "Listers", Slug: "listers"})
+	if err != nil {
+		t.Fatal("failed to insert organization:", err)
+	}
+	otherID, err := testHandlers.Models.Organizations.Insert(data.Organization{Name: "Others", Slug: "others"})
+	if err != nil {
+		t.Fatal("failed to insert organization:", err)
+	}
+	org, other := inTenant(orgID), inTenant(otherID)
+
+	roleID, err := testHandlers.Models.Roles.Insert(data.Role{Name: "user-lister"})
+	if err != nil {
+		t.Fatal("failed to insert role:", err)
+	}
+	_ = testHandlers.Models.Roles.Grant(roleID, "users.read")
+
+	insert := func(m data.Models, u data.User) *data.User {
+		t.Helper()
+
+		u.Active, u.Password = 1, testPassword
+		id, err := m.Users.Insert(u)
+		if err != nil {
+			t.Fatal("failed to insert user:", err)
+		}
+		stored, _ := m.Users.Get(id)
+		return stored
+	}
+
+	admin := insert(org, data.User{FirstName: "Admin", LastName: "Admin", Email: "lister@api.test"})
+	if err := org.Roles.Assign(admin.ID, roleID); err != nil {
+		t.Fatal("failed to assign role:", err)
+	}
+
+	for _, email := range []string{"carol@list.test", "alice@list.test", "bob@list.test"} {
+		insert(org, data.User{FirstName: "Listed", LastName: "Lister", Email: email})
+	}
+	// a lister of another organization is never listed by this one's admin, nor the other way round
+	otherAdmin := insert(other, data.User{FirstName: "Listed", LastName: "Lister", Email: "dave@other.test"})
+	if err := other.Roles.Assign(otherAdmin.ID, roleID); err != nil {
+		t.Fatal("failed to assign role:", err)
+	}
+
+	token := apiToken(t, admin, "users:read")
+
+	type usersPage struct {
+		Items []struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		} `json:"items"`
+		Total      int    `json:"total"`
+		PageSize   int    `json:"page_size"`
+		NextCursor string `json:"next_cursor"`
+	}
+
+	list := func(query url.Values) (int, usersPage) {
+		t.Helper()
+
+		rr := getAPI("/api/users?"+query.Encode(), token)
+
+		var page usersPage
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+				t.Fatal("response is not JSON:", err)
+			}
+		}
+
+		return rr.Code, page
+	}
+
+	status, first := list(url.Values{"last_name": {"Lister"}, "sort": {"email"}, "page_size": {"2"}})
+	if status != http.StatusOK {
+		t.Fatalf("expected a 200 for the first page, got %d", status)
+	}
+	if first.Total != 3 || first.PageSize != 2 || len(first.Items) != 2 || first.NextCursor == "" {
+		t.Fatalf("expected 2 of 3 users and a cursor to the next page, got %+v", first)
+	}
+	if first.Items[0].Email != "alice@list.test" || first.Items[1].Email != "bob@list.test" {
+		t.Errorf("expected the first page sorted by email, got %+v", first.Items)
+	}
+	if first.Items[0].Password != "" {
+		t.Error("password hash exposed in the listing")
+	}
+
+	_, next := list(url.Values{"last_name": {"Lister"}, "sort": {"email"}, "page_size": {"2"}, "cursor": {first.NextCursor}})
+	if len(next.Items) != 1 || next.Items[0].Email != "carol@list.test" {
+		t.Errorf("expected the cursor to lead to carol, got %+v", next.Items)
+	}
+
+	_, second := list(url.Values{"last_name": {"Lister"}, "sort": {"-email"}, "page_size": {"1"}, "page": {"2"}})
+	if len(second.Items) != 1 || second.Items[0].Email != "bob@list.test" {
+		t.Errorf("expected page 2 of the descending listing to hold bob, got %+v", second.Items)
+	}
+
+	for _, bad := range []url.Values{
+		{"page_size": {"-1"}},
+		{"page_size": {"0"}},
+		{"page": {"two"}},
+		{"sort": {"password"}},
+		{"cursor": {"not-a-cursor"}},
+	} {
+		if status, _ := list(bad); status != http.StatusBadRequest {
+			t.Errorf("%s: expected a 400, got %d", bad.Encode(), status)
+		}
+	}
+
+	// parameters that are not list options, or name a field that cannot be filtered by, are ignored
+	status, tracked := list(url.Values{"last_name": {"Lister"}, "utm_source": {"newsletter"}, "_": {"1712"}, "password": {"x"}})
+	if status != http.StatusOK || tracked.Total != 3 {
+		t.Errorf("expected other parameters to be ignored, got %d and %+v", status, tracked)
+	}
+
+	if rr := getAPI("/api/users", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 without a token, got %d", rr.Code)
+	}
+	if rr := getAPI("/api/users", apiToken(t, admin, "tokens:write")); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 for a token without the users:read scope, got %d", rr.Code)
+	}
+
+	token = apiToken(t, otherAdmin, "users:read")
+	_, others := list(url.Values{"last_name": {"Lister"}})
+	if others.Total != 1 || len(others.Items) != 1 || others.Items[0].Email != "dave@other.test" {
+		t.Errorf("expected the admin of the other organization to list only its user, got %+v", others)
+	}
+
+	outsider := insertUser(t, "unlisted@api.test", true)
+	if rr := getAPI("/api/users", apiToken(t, outsider, "users:read")); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 for a user without the users.read permission, got %d", rr.Code)
+	}
+
+	// a role assigned outside any organization gives no permission within one
+	global := insertUser(t, "global@api.test", true)
+	_ = testHandlers.Models.Roles.Assign(global.ID, roleID)
+	if rr := getAPI("/api/users", apiToken(t, global, "users:read")); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 for a user in no organization, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"myapp/data"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/s-petr/celeritas/mailer"
+)
+
+var (
+	// errInvalidCredentials is returned by authenticate for an unknown email or a wrong password
+	errInvalidCredentials = errors.New("handlers: invalid email or password")
+	// errInactive is returned by authenticate for a user who has not verified their email address
+	errInactive = errors.New("handlers: email address not verified")
+)
+
+// DefaultPasswordResetLifetime is how long a password reset link can be used unless the handlers say otherwise
+const DefaultPasswordResetLifetime = time.Hour
+
+// inactiveMessage is the flash shown to a user who logs in before verifying their email address
+const inactiveMessage = "Please verify your email address before logging in. Follow the link in the email we sent you, or ask for a new one."
+
+// verificationMail is the data of the verify-email mail templates
+type verificationMail struct {
+	FirstName string
+	Link      string
+	Hours     int
+}
+
+// passwordResetMail is the data of the password-reset mail templates
+type passwordResetMail struct {
+	FirstName string
+	Link      string
+	Minutes   int
+}
+
+// dummyHash is a hash of nobody's password, made by the current password hasher
+var dummyHash struct {
+	sync.Mutex
+	encoded string
+}
+
+// dummyPasswordHash returns dummyHash, made anew whenever the password hasher has changed
+func dummyPasswordHash() (string, error) {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+
+	if dummyHash.encoded == "" || data.PasswordHasher().NeedsRehash(dummyHash.encoded) {
+		encoded, err := data.HashPassword("not the password of anyone")
+		if err != nil {
+			return "", err
+		}
+		dummyHash.encoded = encoded
+	}
+
+	return dummyHash.encoded, nil
+}
+
+// authenticate returns the user with the given email and password. Users who have not verified
+// their email address are refused with errInactive, but only once their password matched, so
+// the error does not give away which addresses have accounts.
+func (h *Handlers) authenticate(r *http.Request, email, password string) (*data.User, error) {
+	u, err := h.models(r).Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			// the password is compared with a dummy hash, or answering sooner than for a known
+			// address would give away which addresses have accounts
+			encoded, err := dummyPasswordHash()
+			if err != nil {
+				return nil, err
+			}
+			_, _ = data.VerifyPassword(encoded, password)
+
+			return nil, errInvalidCredentials
+		}
+		return nil, err
+	}
+
+	matches, err := u.PasswordMatches(password)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return nil, errInvalidCredentials
+	}
+
+	if u.Active != 1 {
+		return nil, errInactive
+	}
+
+	return u, nil
+}
+
+// sendVerification emails u a link that activates their account, in the background, and gives
+// it the password hashed as passwordHash unless that is empty. It fails with data.ErrThrottled
+// when the previous link was sent too recently.
+func (h *Handlers) sendVerification(r *http.Request, u *data.User, passwordHash string) error {
+	verifications := h.models(r).EmailVerifications
+
+	var token string
+	var err error
+	if passwordHash == "" {
+		token, err = verifications.Issue(u.ID)
+	} else {
+		token, err = verifications.IssueWithPassword(u.ID, passwordHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.sendMailInBackground(mailer.Message{
+		To:       u.Email,
+		Subject:  "Verify your email address",
+		Template: "verify-email",
+		Data: verificationMail{
+			FirstName: u.FirstName,
+			Link:      fmt.Sprintf("%s/users/verify?token=%s", h.App.Server.URL, url.QueryEscape(token)),
+			Hours:     int(data.EmailVerificationLifetime.Hours()),
+		},
+	}, "verification")
+
+	return nil
+}
+
+// VerifyEmail activates the account of the user the verification link in the query was sent to
+func (h *Handlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	_, err := h.models(r).EmailVerifications.Verify(r.URL.Query().Get("token"))
+
+	switch {
+	case err == nil:
+		h.sessionPut(r.Context(), "flash", "Your email address is verified. You can log in now.")
+		http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+	case errors.Is(err, data.ErrExpiredToken):
+		h.sessionPut(r.Context(), "error", "This verification link has expired. Enter your email address to get a new one.")
+		http.Redirect(w, r, "/users/verify/resend", http.StatusSeeOther)
+	case errors.Is(err, data.ErrInvalidToken):
+		h.sessionPut(r.Context(), "error", "This verification link is not valid. It may have been used already, or replaced by a newer one.")
+		http.Redirect(w, r, "/users/verify/resend", http.StatusSeeOther)
+	default:
+		h.App.ErrorLog.Println("error verifying email:", err)
+		h.App.Error500(w, r)
+	}
+}
+
+// ResendVerification shows the form asking for another verification email
+func (h *Handlers) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if err := h.render(w, r, "verify-resend", nil, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// PostResendVerification sends a new verification link to the email address in the form. It
+// answers the same whether or not the address belongs to an unverified account, or was
+// throttled, so it cannot be used to find out who has an account.
+func (h *Handlers) PostResendVerification(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.App.ErrorLog.Println(err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+
+	validator := h.App.Validator(nil)
+	validator.Required(r, "email")
+	validator.IsEmail("email", email)
+
+	if !validator.Valid() {
+		vars := make(jet.VarMap)
+		vars.Set("validator", validator)
+		vars.Set("email", email)
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := h.render(w, r, "verify-resend", vars, nil); err != nil {
+			h.App.ErrorLog.Println("error rendering:", err)
+		}
+		return
+	}
+
+	u, err := h.models(r).Users.GetByEmail(email)
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+	case err != nil:
+		h.App.ErrorLog.Println("error getting user:", err)
+		h.App.Error500(w, r)
+		return
+	case u.Active != 1:
+		if err := h.sendVerification(r, u, ""); err != nil && !errors.Is(err, data.ErrThrottled) {
+			h.App.ErrorLog.Println("error sending verification email:", err)
+			h.App.Error500(w, r)
+			return
+		}
+	}
+
+	h.sessionPut(r.Context(), "flash", "If "+email+" belongs to an account that still needs verifying, we have sent it a new link.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ForgotPassword shows the form asking for a password reset link
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := h.render(w, r, "forgot-password", nil, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// PostForgotPassword emails a password reset link to the address in the form when it belongs
+// to a user. The answer is the same either way and the email goes out in the background, so
+// neither the response nor its timing tell whether the address has an account.
+func (h *Handlers) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.App.ErrorLog.Println(err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+
+	validator := h.App.Validator(nil)
+	validator.Required(r, "email")
+	validator.IsEmail("email", email)
+
+	if !validator.Valid() {
+		vars := make(jet.VarMap)
+		vars.Set("validator", validator)
+		vars.Set("email", email)
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := h.render(w, r, "forgot-password", vars, nil); err != nil {
+			h.App.ErrorLog.Println("error rendering:", err)
+		}
+		return
+	}
+
+	u, err := h.models(r).Users.GetByEmail(email)
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+	case err != nil:
+		h.App.ErrorLog.Println("error getting user:", err)
+		h.App.Error500(w, r)
+		return
+	default:
+		lifetime := h.passwordResetLifetime()
+		msg := mailer.Message{
+			To:       u.Email,
+			Subject:  "Reset your password",
+			Template: "password-reset",
+			Data: passwordResetMail{
+				FirstName: u.FirstName,
+				Link:      h.App.Server.URL + "/users/reset-password?" + h.passwordResetQuery(u, time.Now().Add(lifetime)).Encode(),
+				Minutes:   int(lifetime.Minutes()),
+			},
+		}
+
+		h.sendMailInBackground(msg, "password reset")
+	}
+
+	h.sessionPut(r.Context(), "flash", "If "+email+" belongs to an account, we have sent it a link to reset the password.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form for a new password to the user a valid password reset link was sent to
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.passwordResetUser(r, r.URL.Query()); !ok {
+		h.invalidPasswordReset(w, r)
+		return
+	}
+
+	vars := make(jet.VarMap)
+	vars.Set("link", r.URL.Query())
+
+	if err := h.render(w, r, "reset-password", vars, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// PostResetPassword replaces the password of the user a valid password reset link was sent to.
+// Changing the password changes its hash, which makes the link stop working.
+func (h *Handlers) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.App.ErrorLog.Println(err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	u, ok := h.passwordResetUser(r, r.Form)
+	if !ok {
+		h.invalidPasswordReset(w, r)
+		return
+	}
+
+	password := r.Form.Get("password")
+
+	validator := h.App.Validator(nil)
+	validator.Required(r, "password", "verify-password")
+	validator.Check(password == r.Form.Get("verify-password"), "verify-password", "Passwords do not match")
+
+	if validator.Valid() {
+		err := h.models(r).Users.ResetPassword(u.ID, password)
+		if err == nil {
+			h.sessionPut(r.Context(), "flash", "Your password has been reset. You can log in with your new password now.")
+			http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+			return
+		}
+
+		if !h.addFieldErrors(validator, err) {
+			h.App.ErrorLog.Println("error resetting password:", err)
+			h.App.Error500(w, r)
+			return
+		}
+	}
+
+	vars := make(jet.VarMap)
+	vars.Set("validator", validator)
+	vars.Set("link", r.Form)
+
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := h.render(w, r, "reset-password", vars, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// invalidPasswordReset sends the user of a forged, expired or used password reset link back to ask for a new one
+func (h *Handlers) invalidPasswordReset(w http.ResponseWriter, r *http.Request) {
+	h.sessionPut(r.Context(), "error", "This password reset link has expired or has already been used. Enter your email address to get a new one.")
+	http.Redirect(w, r, "/users/forgot-password", http.StatusSeeOther)
+}
+
+func (h *Handlers) passwordResetLifetime() time.Duration {
+	if h.PasswordResetLifetime > 0 {
+		return h.PasswordResetLifetime
+	}
+
+	return DefaultPasswordResetLifetime
+}
+
+// passwordResetQuery returns the query of a password reset link for u that works until expires
+func (h *Handlers) passwordResetQuery(u *data.User, expires time.Time) url.Values {
+	return url.Values{
+		"id":        {strconv.Itoa(u.ID)},
+		"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+		"signature": {h.passwordResetSignature(u, expires.Unix())},
+	}
+}
+
+// passwordResetSignature signs a password reset link with the encryption key of the application.
+// The current password hash of the user is signed too, so the link only works until it changes.
+func (h *Handlers) passwordResetSignature(u *data.User, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(h.App.EncryptionKey))
+	fmt.Fprintf(mac, "password-reset|%d|%d|%s", u.ID, expires, u.Password)
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// passwordResetUser returns the user the password reset link in values was sent to, or false
+// when the link is forged, has expired or the password was changed since it was sent
+func (h *Handlers) passwordResetUser(r *http.Request, values url.Values) (*data.User, bool) {
+	id, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return nil, false
+	}
+
+	expires, err := strconv.ParseInt(values.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, false
+	}
+
+	u, err := h.models(r).Users.Get(id)
+	if err != nil {
+		return nil, false
+	}
+
+	if !hmac.Equal([]byte(values.Get("signature")), []byte(h.passwordResetSignature(u, expires))) {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// Login shows the login form. The Auth middleware sends visitors here with the page they asked
+// for in return_to, which the form passes on so they end up there after logging in.
+func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	vars := make(jet.VarMap)
+	vars.Set("returnTo", localPath(r.URL.Query().Get("return_to")))
+	vars.Set("socialProviders", socialProviders())
+
+	if err := h.render(w, r, "login", vars, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// PostLogin logs in the user with the email and password in the form. The session token is
+// renewed before the user id goes in the session, so a token planted before logging in is useless.
+func (h *Handlers) PostLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.App.ErrorLog.Println(err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+	password := r.Form.Get("password")
+
+	validator := h.App.Validator(nil)
+	validator.Required(r, "email", "password")
+	validator.IsEmail("email", email)
+
+	returnTo := localPath(r.Form.Get("return_to"))
+
+	vars := make(jet.VarMap)
+	vars.Set("validator", validator)
+	vars.Set("email", email)
+	vars.Set("returnTo", returnTo)
+	vars.Set("socialProviders", socialProviders())
+
+	if !validator.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := h.render(w, r, "login", vars, nil); err != nil {
+			h.App.ErrorLog.Println("error rendering:", err)
+		}
+		return
+	}
+
+	u, err := h.authenticate(r, email, password)
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		h.sessionPut(r.Context(), "error", "Invalid email or password")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := h.render(w, r, "login", vars, nil); err != nil {
+			h.App.ErrorLog.Println("error rendering:", err)
+		}
+		return
+	case errors.Is(err, errInactive):
+		h.sessionPut(r.Context(), "error", inactiveMessage)
+		http.Redirect(w, r, "/users/verify/resend", http.StatusSeeOther)
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error authenticating:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	if err := h.logIn(w, r, u); err != nil {
+		h.App.ErrorLog.Println("error renewing session token:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	if r.Form.Get("remember") == "remember" && h.Remember != nil {
+		token, err := h.models(r).RememberTokens.Issue(u.ID)
+		if err != nil {
+			h.App.ErrorLog.Println("error issuing remember token:", err)
+		} else {
+			http.SetCookie(w, h.Remember.RememberCookie(u.ID, token))
+		}
+	}
+
+	h.sessionPut(r.Context(), "flash", "Welcome back, "+u.FirstName+".")
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// logIn puts u in the session under new session and CSRF tokens, so tokens planted in the
+// browser before logging in are of no use afterwards
+func (h *Handlers) logIn(w http.ResponseWriter, r *http.Request, u *data.User) error {
+	if err := h.sessionRenew(r.Context()); err != nil {
+		return err
+	}
+	h.regenerateCSRFToken(w, r)
+
+	// an organization chosen by whoever used the session before is not this user's to keep
+	h.sessionRemove(r.Context(), "organizationID")
+	h.sessionPut(r.Context(), "userID", u.ID)
+
+	return nil
+}
+
+// Logout logs the user out, revoking the remember tokens of all their devices, and starts a new session
+// with a new CSRF token. It takes a POST from the logout form, so another site cannot log users out.
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if userID, ok := h.sessionGet(r.Context(), "userID").(int); ok {
+		if err := h.models(r).RememberTokens.DeleteForUser(userID); err != nil {
+			h.App.ErrorLog.Println("error deleting remember tokens:", err)
+		}
+	}
+	if h.Remember != nil {
+		http.SetCookie(w, h.Remember.ForgetCookie())
+	}
+
+	if err := h.sessionDestroy(r.Context()); err != nil {
+		h.App.ErrorLog.Println("error destroying session:", err)
+		h.App.Error500(w, r)
+		return
+	}
+	h.regenerateCSRFToken(w, r)
+
+	h.sessionPut(r.Context(), "flash", "You have been logged out.")
+	http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+}
+
+// Register shows the sign up form
+func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
+	if err := h.render(w, r, "register", nil, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// PostRegister creates an inactive account from the form and emails a link to verify its
+// address. Signing up with the address of an existing account looks the same, though only an
+// unverified one is sent a new link, so the form cannot be used to find out who has an account.
+func (h *Handlers) PostRegister(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.App.ErrorLog.Println(err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	u := data.User{
+		FirstName: strings.TrimSpace(r.Form.Get("first_name")),
+		LastName:  strings.TrimSpace(r.Form.Get("last_name")),
+		Email:     strings.TrimSpace(r.Form.Get("email")),
+		Password:  r.Form.Get("password"),
+	}
+
+	validator := h.App.Validator(nil)
+	validator.Required(r, "first_name", "last_name", "email", "password", "verify-password")
+	validator.IsEmail("email", u.Email)
+	validator.Check(u.Password == r.Form.Get("verify-password"), "verify-password", "Passwords do not match")
+
+	if validator.Valid() {
+		err := h.register(r, u)
+		if err == nil {
+			h.sessionPut(r.Context(), "flash", "Thanks for signing up. Follow the link we have sent to "+u.Email+" to verify your address, then log in.")
+			http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+			return
+		}
+
+		if !h.addFieldErrors(validator, err) {
+			h.App.ErrorLog.Println("error registering user:", err)
+			h.App.Error500(w, r)
+			return
+		}
+	}
+
+	vars := make(jet.VarMap)
+	vars.Set("validator", validator)
+	vars.Set("user", u)
+
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := h.render(w, r, "register", vars, nil); err != nil {
+		h.App.ErrorLog.Println("error rendering:", err)
+	}
+}
+
+// register stores u as a new inactive user and sends them a verification link. When the email
+// already has a verified account, it is sent nothing. An unverified one is sent a new link,
+// which replaces any sent before and gives the account the password of u once followed, since
+// whoever signed up first never proved they own the address.
+func (h *Handlers) register(r *http.Request, u data.User) error {
+	// the password is checked before the address is looked up, so a weak one is refused in the
+	// same way whether or not the address has an account
+	if err := data.ValidatePassword(u.Password, u); err != nil {
+		return err
+	}
+
+	existing, err := h.models(r).Users.GetByEmail(u.Email)
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		// hashing the password takes up most of creating a user, so it is done for an existing
+		// account too, or the quicker response would give the account away
+		hash, err := data.HashPassword(u.Password)
+		if err != nil {
+			return err
+		}
+
+		if existing.Active == 1 {
+			return nil
+		}
+
+		// the email greets whoever signed up this time, though the stored name stays the first one
+		existing.FirstName = u.FirstName
+		if err := h.sendVerification(r, existing, hash); err != nil && !errors.Is(err, data.ErrThrottled) {
+			return err
+		}
+		return nil
+	}
+
+	u.Active = 0
+	id, err := h.models(r).Users.Insert(u)
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		// the address belongs to a deleted user, or to someone registering it at the same moment
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	u.ID = id
+
+	return h.sendVerification(r, &u, "")
+}
@@ -0,0 +1,600 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinas/nosurf"
+	"github.com/s-petr/celeritas/mailer"
+)
+
+// testPassword is the password of the users inserted by the handler tests
+const testPassword = "kettle-harbor-quilt-42"
+
+// insertUser stores a user with the given email and testPassword, active or not
+func insertUser(t *testing.T, email string, active bool) *data.User {
+	t.Helper()
+
+	u := data.User{FirstName: "John", LastName: "Smith", Email: email, Password: testPassword}
+	if active {
+		u.Active = 1
+	}
+
+	id, err := testHandlers.Models.Users.Insert(u)
+	if err != nil {
+		t.Fatal("failed to insert user:", err)
+	}
+
+	stored, err := testHandlers.Models.Users.Get(id)
+	if err != nil {
+		t.Fatal("failed to get user:", err)
+	}
+
+	return stored
+}
+
+// postForm calls handler with a POST of form, returning the response and the session context
+func postForm(handler http.HandlerFunc, target string, form url.Values) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr, req
+}
+
+// waitForMail returns the messages sent to the address once there are count of them, for mail sent in the background
+func waitForMail(t *testing.T, to string, count int) []mailer.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sent := testMailbox.sentTo(to)
+		if len(sent) >= count || time.Now().After(deadline) {
+			return sent
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// verificationLink returns the link in the last verification email sent to the address
+func verificationLink(t *testing.T, email string) string {
+	t.Helper()
+
+	sent := waitForMail(t, email, 1)
+	if len(sent) == 0 {
+		t.Fatal("no verification email sent to", email)
+	}
+
+	msg := sent[len(sent)-1]
+	if msg.Template != "verify-email" {
+		t.Fatalf("expected the verify-email template, got %q", msg.Template)
+	}
+
+	return msg.Data.(verificationMail).Link
+}
+
+func TestAuthenticate(t *testing.T) {
+	active := insertUser(t, "active@authenticate.test", true)
+	insertUser(t, "inactive@authenticate.test", false)
+
+	req, _ := http.NewRequest("POST", "/users/login", nil)
+
+	u, err := testHandlers.authenticate(req, active.Email, testPassword)
+	if err != nil || u.ID != active.ID {
+		t.Error("failed to authenticate active user:", err)
+	}
+
+	tests := []struct {
+		email, password string
+		expected        error
+	}{
+		{active.Email, "wrong-password-123", errInvalidCredentials},
+		{"nobody@authenticate.test", testPassword, errInvalidCredentials},
+		{"inactive@authenticate.test", "wrong-password-123", errInvalidCredentials},
+		{"inactive@authenticate.test", testPassword, errInactive},
+	}
+
+	for _, tt := range tests {
+		if _, err := testHandlers.authenticate(req, tt.email, tt.password); !errors.Is(err, tt.expected) {
+			t.Errorf("%s with %q: expected %v, got %v", tt.email, tt.password, tt.expected, err)
+		}
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	u := insertUser(t, "new@verify.test", false)
+
+	rr, _ := postForm(testHandlers.PostResendVerification, "/users/verify/resend", url.Values{"email": {u.Email}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect after asking for a verification email, got %d", rr.Code)
+	}
+
+	link, err := url.Parse(verificationLink(t, u.Email))
+	if err != nil || link.Path != "/users/verify" {
+		t.Fatalf("malformed verification link %q: %v", link, err)
+	}
+
+	req, _ := http.NewRequest("GET", link.RequestURI(), nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	testHandlers.VerifyEmail(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Errorf("expected a redirect to log in after verifying, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if !strings.Contains(cel.Session.GetString(ctx, "flash"), "verified") {
+		t.Error("no flash confirming the verification")
+	}
+
+	if u, _ := testHandlers.Models.Users.Get(u.ID); u == nil || u.Active != 1 {
+		t.Error("user not activated by following the verification link")
+	}
+
+	req, _ = http.NewRequest("GET", link.RequestURI(), nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	testHandlers.VerifyEmail(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/verify/resend" {
+		t.Errorf("expected a used link to be sent to the resend form, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cel.Session.GetString(ctx, "error") == "" {
+		t.Error("no error explaining the used link")
+	}
+}
+
+func TestPostResendVerification(t *testing.T) {
+	u := insertUser(t, "throttled@verify.test", false)
+	active := insertUser(t, "active@verify.test", true)
+
+	var flashes []string
+	for _, email := range []string{u.Email, u.Email, active.Email, "nobody@verify.test"} {
+		rr, req := postForm(testHandlers.PostResendVerification, "/users/verify/resend", url.Values{"email": {email}})
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected a redirect, got %d", email, rr.Code)
+		}
+
+		flash := cel.Session.GetString(req.Context(), "flash")
+		flashes = append(flashes, strings.Replace(flash, email, "EMAIL", 1))
+	}
+
+	for _, flash := range flashes[1:] {
+		if flash != flashes[0] {
+			t.Errorf("answer gives away whether an account exists: %q and %q", flashes[0], flash)
+		}
+	}
+
+	if sent := waitForMail(t, u.Email, 1); len(sent) != 1 {
+		t.Errorf("expected one email for two requests in a row, got %d", len(sent))
+	}
+	if sent := testMailbox.sentTo(active.Email); len(sent) != 0 {
+		t.Error("verification email sent to an active user")
+	}
+
+	rr, _ := postForm(testHandlers.PostResendVerification, "/users/verify/resend", url.Values{"email": {""}})
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "is-invalid") {
+		t.Errorf("expected the form back with an error for a missing email, got %d", rr.Code)
+	}
+}
+
+// get calls handler with a GET of target, returning the response and the session context
+func get(handler http.HandlerFunc, target string) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest("GET", target, nil)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr, req
+}
+
+func TestPostForgotPassword(t *testing.T) {
+	u := insertUser(t, "forgot@reset.test", true)
+
+	var flashes []string
+	for _, email := range []string{u.Email, "nobody@reset.test"} {
+		rr, req := postForm(testHandlers.PostForgotPassword, "/users/forgot-password", url.Values{"email": {email}})
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected a redirect, got %d", email, rr.Code)
+		}
+
+		flash := cel.Session.GetString(req.Context(), "flash")
+		flashes = append(flashes, strings.Replace(flash, email, "EMAIL", 1))
+	}
+
+	if flashes[0] != flashes[1] {
+		t.Errorf("answer gives away whether an account exists: %q and %q", flashes[0], flashes[1])
+	}
+
+	sent := waitForMail(t, u.Email, 1)
+	if len(sent) != 1 || sent[0].Template != "password-reset" {
+		t.Fatal("no password reset email sent")
+	}
+
+	link := sent[0].Data.(passwordResetMail).Link
+	if !strings.Contains(link, "/users/reset-password?") || !strings.Contains(link, "signature=") {
+		t.Errorf("malformed password reset link %q", link)
+	}
+
+	if len(testMailbox.sentTo("nobody@reset.test")) != 0 {
+		t.Error("password reset email sent to an unknown address")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	u := insertUser(t, "reset@reset.test", true)
+	link := testHandlers.passwordResetQuery(u, time.Now().Add(time.Hour))
+
+	rr, _ := get(testHandlers.ResetPassword, "/users/reset-password?"+link.Encode())
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), link.Get("signature")) {
+		t.Fatalf("expected the reset form for a valid link, got %d", rr.Code)
+	}
+
+	form := func(password, verify string) url.Values {
+		values := url.Values{"password": {password}, "verify-password": {verify}}
+		for key := range link {
+			values.Set(key, link.Get(key))
+		}
+		return values
+	}
+
+	rr, _ = postForm(testHandlers.PostResetPassword, "/users/reset-password", form("lantern-meadow-cobalt-7", "lantern-meadow-cobalt-8"))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "do not match") {
+		t.Errorf("expected the form back for passwords that do not match, got %d", rr.Code)
+	}
+
+	rr, _ = postForm(testHandlers.PostResetPassword, "/users/reset-password", form("Password1234!", "Password1234!"))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "commonly used") {
+		t.Errorf("expected the form back for a common password, got %d", rr.Code)
+	}
+
+	rr, req := postForm(testHandlers.PostResetPassword, "/users/reset-password", form("lantern-meadow-cobalt-7", "lantern-meadow-cobalt-7"))
+	if rr.Code != http.StatusSeeOther || cel.Session.GetString(req.Context(), "flash") == "" {
+		t.Fatalf("expected a redirect with a flash after resetting, got %d", rr.Code)
+	}
+
+	if _, err := testHandlers.authenticate(req, u.Email, "lantern-meadow-cobalt-7"); err != nil {
+		t.Error("new password does not work after the reset:", err)
+	}
+
+	rr, _ = postForm(testHandlers.PostResetPassword, "/users/reset-password", form("kettle-harbor-quilt-43", "kettle-harbor-quilt-43"))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/forgot-password" {
+		t.Errorf("expected a used link to be refused, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestResetPassword_InvalidLinks(t *testing.T) {
+	u := insertUser(t, "invalid@reset.test", true)
+
+	expired := testHandlers.passwordResetQuery(u, time.Now().Add(-time.Minute))
+
+	tampered := testHandlers.passwordResetQuery(u, time.Now().Add(time.Hour))
+	tampered.Set("expires", "99999999999")
+
+	other := insertUser(t, "other@reset.test", true)
+	otherUser := testHandlers.passwordResetQuery(u, time.Now().Add(time.Hour))
+	otherUser.Set("id", fmt.Sprint(other.ID))
+
+	changed := testHandlers.passwordResetQuery(u, time.Now().Add(time.Hour))
+	if err := testHandlers.Models.Users.ResetPassword(u.ID, "lantern-meadow-cobalt-7"); err != nil {
+		t.Fatal("failed to reset password:", err)
+	}
+
+	for name, link := range map[string]url.Values{
+		"expired":          expired,
+		"tampered":         tampered,
+		"other user":       otherUser,
+		"password changed": changed,
+		"empty":            {},
+	} {
+		rr, req := get(testHandlers.ResetPassword, "/users/reset-password?"+link.Encode())
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/forgot-password" {
+			t.Errorf("%s: expected the link to be refused, got %d to %q", name, rr.Code, rr.Header().Get("Location"))
+		}
+		if cel.Session.GetString(req.Context(), "error") == "" {
+			t.Errorf("%s: no error explaining the refused link", name)
+		}
+	}
+}
+
+// sessionToken commits a session holding the values and returns its token, for requests to send in X-Session
+func sessionToken(t *testing.T, values map[string]any) string {
+	t.Helper()
+
+	ctx, err := testSession.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		testSession.Put(ctx, key, value)
+	}
+
+	token, _, err := testSession.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestPostLogin(t *testing.T) {
+	u := insertUser(t, "login@auth.test", true)
+	insertUser(t, "inactive@auth.test", false)
+
+	token := sessionToken(t, map[string]any{"organizationID": 99})
+
+	form := url.Values{"email": {u.Email}, "password": {testPassword}, "remember": {"remember"}}
+	req, _ := http.NewRequest("POST", "/users/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Session", token)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	testHandlers.PostLogin(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("expected a redirect home after logging in, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cel.Session.GetInt(req.Context(), "userID") != u.ID {
+		t.Error("user id not stored in the session")
+	}
+	if testSession.Token(req.Context()) == token {
+		t.Error("session token not renewed when logging in")
+	}
+	if cel.Session.Exists(req.Context(), "organizationID") {
+		t.Error("organization of the session from before logging in was kept")
+	}
+
+	remembered := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		remembered.AddCookie(cookie)
+	}
+	rememberToken, ok := testHandlers.Remember.RememberedToken(remembered)
+	if !ok {
+		t.Fatal("no remember cookie set when asked to be remembered")
+	}
+	if valid, err := testHandlers.Models.RememberTokens.Valid(u.ID, rememberToken); err != nil || !valid {
+		t.Error("remember cookie does not hold a valid remember token:", err)
+	}
+
+	for name, tt := range map[string]struct {
+		form     url.Values
+		code     int
+		location string
+	}{
+		"wrong password": {url.Values{"email": {u.Email}, "password": {"not-the-password"}}, http.StatusUnprocessableEntity, ""},
+		"unknown email":  {url.Values{"email": {"nobody@auth.test"}, "password": {testPassword}}, http.StatusUnprocessableEntity, ""},
+		"missing fields": {url.Values{"email": {"not an email"}}, http.StatusUnprocessableEntity, ""},
+		"inactive":       {url.Values{"email": {"inactive@auth.test"}, "password": {testPassword}}, http.StatusSeeOther, "/users/verify/resend"},
+	} {
+		rr, req := postForm(testHandlers.PostLogin, "/users/login", tt.form)
+		if rr.Code != tt.code || rr.Header().Get("Location") != tt.location {
+			t.Errorf("%s: expected %d to %q, got %d to %q", name, tt.code, tt.location, rr.Code, rr.Header().Get("Location"))
+		}
+		if cel.Session.Exists(req.Context(), "userID") {
+			t.Errorf("%s: user logged in", name)
+		}
+	}
+}
+
+func TestPostLogin_CSRFToken(t *testing.T) {
+	u := insertUser(t, "csrf@auth.test", true)
+
+	var token string
+	form := testMiddleware.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { token = nosurf.Token(r) }))
+	rr, _ := get(form.ServeHTTP, "/users/login")
+	cookies := rr.Result().Cookies()
+
+	values := url.Values{"email": {u.Email}, "password": {testPassword}, "csrf_token": {token}}
+	req, _ := http.NewRequest("POST", "/users/login", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req = req.WithContext(getCtx(req))
+
+	rr = httptest.NewRecorder()
+	testMiddleware.CSRF(http.HandlerFunc(testHandlers.PostLogin)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect after logging in, got %d", rr.Code)
+	}
+
+	renewed := false
+	for _, cookie := range rr.Result().Cookies() {
+		for _, before := range cookies {
+			if cookie.Name == before.Name && cookie.Value != before.Value {
+				renewed = true
+			}
+		}
+	}
+	if !renewed {
+		t.Error("CSRF token not renewed when logging in")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	u := insertUser(t, "logout@auth.test", true)
+
+	rememberToken, err := testHandlers.Models.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDevice, err := testHandlers.Models.RememberTokens.Issue(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := sessionToken(t, map[string]any{"userID": u.ID})
+
+	req, _ := http.NewRequest("POST", "/users/logout", nil)
+	req.Header.Set("X-Session", token)
+	req.AddCookie(testHandlers.Remember.RememberCookie(u.ID, rememberToken))
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	testHandlers.Logout(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Errorf("expected a redirect to log in, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cel.Session.Exists(req.Context(), "userID") {
+		t.Error("user still logged in after logging out")
+	}
+	if testSession.Token(req.Context()) == token {
+		t.Error("session kept after logging out")
+	}
+
+	if valid, _ := testHandlers.Models.RememberTokens.Valid(u.ID, rememberToken); valid {
+		t.Error("remember token still valid after logging out")
+	}
+	if valid, _ := testHandlers.Models.RememberTokens.Valid(u.ID, otherDevice); valid {
+		t.Error("remember token of another device still valid after logging out")
+	}
+
+	forgotten := false
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == testHandlers.Remember.ForgetCookie().Name && cookie.MaxAge < 0 {
+			forgotten = true
+		}
+	}
+	if !forgotten {
+		t.Error("remember cookie not removed from the browser")
+	}
+}
+
+func TestPostRegister(t *testing.T) {
+	form := url.Values{
+		"first_name":      {"Jane"},
+		"last_name":       {"Doe"},
+		"email":           {"register@auth.test"},
+		"password":        {"lantern-meadow-cobalt-7"},
+		"verify-password": {"lantern-meadow-cobalt-7"},
+	}
+
+	rr, req := postForm(testHandlers.PostRegister, "/users/register", form)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Fatalf("expected a redirect to log in after signing up, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	flash := cel.Session.GetString(req.Context(), "flash")
+
+	u, err := testHandlers.Models.Users.GetByEmail("register@auth.test")
+	if err != nil {
+		t.Fatal("user not stored:", err)
+	}
+	if u.Active != 0 {
+		t.Error("new user is active before verifying their email address")
+	}
+	if len(waitForMail(t, u.Email, 1)) != 1 {
+		t.Error("no verification email sent to the new user")
+	}
+
+	rr, req = postForm(testHandlers.PostRegister, "/users/register", form)
+	if rr.Code != http.StatusSeeOther || cel.Session.GetString(req.Context(), "flash") != flash {
+		t.Errorf("signing up again gives away that the account exists, got %d", rr.Code)
+	}
+
+	// nobody has verified the address yet, so whoever signs up again once the first link may
+	// be replaced is sent a link giving the account their password, and the first link stops working
+	first, err := url.Parse(verificationLink(t, u.Email))
+	if err != nil {
+		t.Fatal("malformed verification link:", err)
+	}
+	if _, err := cel.DB.Pool.Exec("UPDATE email_verifications SET created_at = ? WHERE user_id = ?", time.Now().Add(-2*data.EmailVerificationInterval), u.ID); err != nil {
+		t.Fatal(err)
+	}
+	again := url.Values{}
+	for key := range form {
+		again.Set(key, form.Get(key))
+	}
+	again.Set("password", "harbor-violet-anchor-3")
+	again.Set("verify-password", "harbor-violet-anchor-3")
+	if rr, _ := postForm(testHandlers.PostRegister, "/users/register", again); rr.Code != http.StatusSeeOther {
+		t.Fatalf("signing up again with another password, expected a redirect, got %d", rr.Code)
+	}
+	if len(waitForMail(t, u.Email, 2)) != 2 {
+		t.Fatal("no verification email sent for signing up again")
+	}
+	second, err := url.Parse(verificationLink(t, u.Email))
+	if err != nil {
+		t.Fatal("malformed verification link:", err)
+	}
+
+	if rr, _ := get(testHandlers.VerifyEmail, first.RequestURI()); rr.Header().Get("Location") != "/users/verify/resend" {
+		t.Errorf("verifying with the replaced link, expected to be sent to the resend form, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	rr, _ = get(testHandlers.VerifyEmail, second.RequestURI())
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Fatalf("verifying with the second link, expected a redirect to log in, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if _, err := testHandlers.authenticate(req, u.Email, "lantern-meadow-cobalt-7"); !errors.Is(err, errInvalidCredentials) {
+		t.Error("password of the first sign up logs in after verifying the second link, got", err)
+	}
+	if _, err := testHandlers.authenticate(req, u.Email, "harbor-violet-anchor-3"); err != nil {
+		t.Error("password of the second sign up does not log in after verifying its link:", err)
+	}
+	u, _ = testHandlers.Models.Users.GetByEmail(u.Email)
+
+	// a weak password is refused alike whether or not the address has an account
+	weak := url.Values{}
+	for key := range form {
+		weak.Set(key, form.Get(key))
+	}
+	weak.Set("password", "Password1234!")
+	weak.Set("verify-password", "Password1234!")
+	existing, _ := postForm(testHandlers.PostRegister, "/users/register", weak)
+	weak.Set("email", "unknown@auth.test")
+	unknown, _ := postForm(testHandlers.PostRegister, "/users/register", weak)
+	if existing.Code != http.StatusUnprocessableEntity || unknown.Code != existing.Code {
+		t.Errorf("weak password answered differently for an existing address: %d, and for a new one: %d", existing.Code, unknown.Code)
+	}
+
+	if err := testHandlers.Models.Users.Delete(u.ID); err != nil {
+		t.Fatal(err)
+	}
+	rr, req = postForm(testHandlers.PostRegister, "/users/register", form)
+	if rr.Code != http.StatusSeeOther || cel.Session.GetString(req.Context(), "flash") != flash {
+		t.Errorf("signing up with the address of a deleted user, expected the same response, got %d", rr.Code)
+	}
+
+	for name, tt := range map[string]struct {
+		field, value, message string
+	}{
+		"passwords differ": {"verify-password", "lantern-meadow-cobalt-8", "do not match"},
+		"common password":  {"password", "Password1234!", "commonly used"},
+		"missing name":     {"first_name", "", "cannot be blank"},
+	} {
+		values := url.Values{}
+		for key := range form {
+			values.Set(key, form.Get(key))
+		}
+		values.Set("email", "other@auth.test")
+		values.Set(tt.field, tt.value)
+		if tt.field == "password" {
+			values.Set("verify-password", tt.value)
+		}
+
+		rr, _ := postForm(testHandlers.PostRegister, "/users/register", values)
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), tt.message) {
+			t.Errorf("%s: expected the form back with %q, got %d", name, tt.message, rr.Code)
+		}
+	}
+
+	if _, err := testHandlers.Models.Users.GetByEmail("other@auth.test"); !errors.Is(err, data.ErrNotFound) {
+		t.Error("user stored from a rejected form")
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// serveWithSession calls handler with a request carrying the session of token, returning the
+// response and the request
+func serveWithSession(handler http.Handler, method, target, token string) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest(method, target, nil)
+	req.Header.Set("X-Session", token)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr, req
+}
+
+// ok answers every request with a 200
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestAuthMiddleware(t *testing.T) {
+	u := insertUser(t, "auth@middleware.test", true)
+	auth := testMiddleware.Auth(ok)
+
+	rr, _ := serveWithSession(auth, "GET", "/account?tab=tokens", "")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect to log in, got %d", rr.Code)
+	}
+
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	returnTo := location.Query().Get("return_to")
+	if location.Path != "/users/login" || returnTo != "/account?tab=tokens" {
+		t.Errorf("expected a redirect to log in returning to the page, got %q", rr.Header().Get("Location"))
+	}
+
+	rr, _ = serveWithSession(auth, "POST", "/account", "")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Errorf("expected a form post to be sent to log in without a return_to, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	req, _ := http.NewRequest("GET", "/account", nil)
+	req.Header.Set("Accept", "application/json")
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+	auth.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 for JSON, got %d", rr.Code)
+	}
+
+	rr, _ = serveWithSession(auth, "GET", "/account", sessionToken(t, map[string]any{"userID": u.ID}))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a logged in user to be let through, got %d", rr.Code)
+	}
+
+	rr, _ = get(testHandlers.Login, location.String())
+	if !strings.Contains(rr.Body.String(), `value="/account?tab=tokens"`) {
+		t.Error("login form does not pass on the page to return to")
+	}
+
+	form := url.Values{"email": {u.Email}, "password": {testPassword}, "return_to": {returnTo}}
+	rr, _ = postForm(testHandlers.PostLogin, "/users/login", form)
+	if rr.Header().Get("Location") != returnTo {
+		t.Errorf("expected to return to %q after logging in, got %q", returnTo, rr.Header().Get("Location"))
+	}
+
+	form.Set("return_to", "//evil.example/phish")
+	rr, _ = postForm(testHandlers.PostLogin, "/users/login", form)
+	if rr.Header().Get("Location") != "/" {
+		t.Errorf("expected an offsite return_to to be ignored, got %q", rr.Header().Get("Location"))
+	}
+}
+
+func TestGuestMiddleware(t *testing.T) {
+	u := insertUser(t, "guest@middleware.test", true)
+	routes := getRoutes()
+
+	for _, path := range []string{"/users/login", "/users/register"} {
+		rr, _ := serveWithSession(routes, "GET", path, "")
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected the form for a visitor, got %d", path, rr.Code)
+		}
+	}
+
+	guest := testMiddleware.Guest(ok)
+	rr, _ := serveWithSession(guest, "GET", "/users/login", sessionToken(t, map[string]any{"userID": u.ID}))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Errorf("expected a logged in user to be sent home, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestLoadUser(t *testing.T) {
+	u := insertUser(t, "load@middleware.test", true)
+
+	var loaded *data.User
+	load := testMiddleware.LoadUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loaded, _ = data.UserFrom(r.Context())
+		testHandlers.Home(w, r)
+	}))
+
+	rr, _ := serveWithSession(load, "GET", "/", sessionToken(t, map[string]any{"userID": u.ID}))
+	if loaded == nil || loaded.ID != u.ID {
+		t.Fatal("logged in user not put in the request context")
+	}
+	if !strings.Contains(rr.Body.String(), "Logged in as John Smith") {
+		t.Error("logged in user not available to the page")
+	}
+	if !strings.Contains(rr.Body.String(), `<form method="post" action="/users/logout">`) {
+		t.Error("logged in user not offered the logout form")
+	}
+
+	loaded = nil
+	serveWithSession(load, "GET", "/", "")
+	if loaded != nil {
+		t.Error("user put in the context of an anonymous request")
+	}
+
+	if err := testHandlers.Models.Users.Delete(u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	_, req := serveWithSession(load, "GET", "/", sessionToken(t, map[string]any{"userID": u.ID}))
+	if loaded != nil || cel.Session.Exists(req.Context(), "userID") {
+		t.Error("session of a deleted user not logged out")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for target, want := range map[string]string{
+		"/account?tab=tokens":  "/account?tab=tokens",
+		"/":                    "/",
+		"":                     "/",
+		"account":              "/",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+		"javascript:alert(1)":  "/",
+	} {
+		if got := localPath(target); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
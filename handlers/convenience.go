@@ -2,15 +2,139 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"myapp/data"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/CloudyKit/jet/v6"
 	"github.com/s-petr/celeritas"
+	"github.com/s-petr/celeritas/mailer"
 )
 
+// render renders the jet page tmpl. The user put in the request context by the LoadUser
+// middleware is available to every page as currentUser.
 func (h *Handlers) render(w http.ResponseWriter, r *http.Request, tmpl string, variables, data any) error {
+	if u, ok := userFrom(r); ok {
+		vars, _ := variables.(jet.VarMap)
+		if vars == nil {
+			vars = make(jet.VarMap)
+		}
+		vars.Set("currentUser", u)
+		variables = vars
+	}
+
 	return h.App.Render.Page(w, r, tmpl, variables, data)
 }
 
+// userFrom returns the logged in user loaded by the LoadUser middleware
+func userFrom(r *http.Request) (*data.User, bool) {
+	return data.UserFrom(r.Context())
+}
+
+// localPath returns target when it is a path on this site and "/" otherwise, so a crafted
+// link cannot use a redirect after logging in to send the user to another site
+func localPath(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(target, "/") ||
+		strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+
+	return target
+}
+
+// models returns the models for the request, reading from the primary after it has written
+func (h *Handlers) models(r *http.Request) data.Models {
+	return h.Models.WithContext(r.Context())
+}
+
+// addFieldErrors adds the messages of err to the form validator when it is data.FieldErrors,
+// such as a password rejected by the password policy, and reports whether it was
+func (h *Handlers) addFieldErrors(v *celeritas.Validation, err error) bool {
+	var fieldErrors data.FieldErrors
+	if !errors.As(err, &fieldErrors) {
+		return false
+	}
+
+	for field, message := range fieldErrors {
+		v.AddError(field, message)
+	}
+
+	return true
+}
+
+// errMailTimeout is returned when the mail listener does not take or answer a message in time
+var errMailTimeout = errors.New("timed out waiting for the mail listener")
+
+// mailTimeout is how long sendMail waits for the mail listener to take a message, and again for its result
+var mailTimeout = time.Minute
+
+// sendMail hands msg to the mail listener of the application, from the default sender unless
+// it has one, and waits for it to be sent. The listener answers on a channel shared by every
+// sender, one result per message in order, so senders take turns; the results of senders that
+// gave up waiting are drained before the next message goes out.
+func (h *Handlers) sendMail(msg mailer.Message) error {
+	if msg.From == "" {
+		msg.From = h.App.Mail.FromAddress
+		msg.FromName = h.App.Mail.FromName
+	}
+
+	h.mail.Lock()
+	defer h.mail.Unlock()
+
+	timeout := time.NewTimer(mailTimeout)
+	defer timeout.Stop()
+
+	for ; h.mail.abandoned > 0; h.mail.abandoned-- {
+		select {
+		case <-h.App.Mail.Results:
+		case <-timeout.C:
+			return errMailTimeout
+		}
+	}
+
+	select {
+	case h.App.Mail.Jobs <- msg:
+	case <-timeout.C:
+		return errMailTimeout
+	}
+
+	select {
+	case res := <-h.App.Mail.Results:
+		return res.Error
+	case <-timeout.C:
+		h.mail.abandoned++
+		return errMailTimeout
+	}
+}
+
+// sendMailInBackground sends msg without keeping the request waiting, logging a failure. Besides
+// answering sooner, the time taken by a response then cannot tell whether an email went out.
+func (h *Handlers) sendMailInBackground(msg mailer.Message, what string) {
+	h.background(func() {
+		if err := h.sendMail(msg); err != nil {
+			h.App.ErrorLog.Printf("error sending %s email: %s", what, err)
+		}
+	})
+}
+
+// background runs fn in a goroutine that the application waits for before it shuts down
+func (h *Handlers) background(fn func()) {
+	if h.Background != nil {
+		h.Background.Add(1)
+	}
+
+	go func() {
+		if h.Background != nil {
+			defer h.Background.Done()
+		}
+		fn()
+	}()
+}
+
 func (h *Handlers) sessionPut(ctx context.Context, key string, val any) {
 	h.App.Session.Put(ctx, key, val)
 }
@@ -34,6 +158,12 @@ func (h *Handlers) sessionDestroy(ctx context.Context) error {
 	return h.App.Session.Destroy(ctx)
 }
 
+func (h *Handlers) regenerateCSRFToken(w http.ResponseWriter, r *http.Request) {
+	if h.CSRF != nil {
+		h.CSRF.RegenerateCSRFToken(w, r)
+	}
+}
+
 func (h *Handlers) randomString(size int) string {
 	return h.App.RandomString(size)
 }
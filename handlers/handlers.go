@@ -3,19 +3,75 @@ package handlers
 import (
 	"myapp/data"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/CloudyKit/jet/v6"
 	"github.com/s-petr/celeritas"
 )
 
+// RememberCookies makes and reads the cookies behind "remember me"; *middleware.Middleware is one
+type RememberCookies interface {
+	// RememberCookie returns the cookie that logs userID back in with the remember token
+	RememberCookie(userID int, token string) *http.Cookie
+	// ForgetCookie returns a cookie that removes the remember cookie from the browser
+	ForgetCookie() *http.Cookie
+	// RememberedToken returns the remember token in the remember cookie of the request, if any
+	RememberedToken(r *http.Request) (string, bool)
+}
+
+// CSRFTokens renews the CSRF token of a request; *middleware.Middleware is one
+type CSRFTokens interface {
+	// RegenerateCSRFToken replaces the CSRF token of the request and its cookie
+	RegenerateCSRFToken(w http.ResponseWriter, r *http.Request)
+}
+
 type Handlers struct {
 	App    *celeritas.Celeritas
 	Models data.Models
+
+	// Remember makes the remember cookie when a user logs in asking to be remembered
+	Remember RememberCookies
+
+	// CSRF renews the CSRF token whenever the session token is renewed
+	CSRF CSRFTokens
+
+	// PasswordResetLifetime is how long a password reset link can be used; 0 means DefaultPasswordResetLifetime
+	PasswordResetLifetime time.Duration
+
+	// Background counts the goroutines the handlers leave running, such as emails being sent,
+	// so shutting down can wait for them
+	Background *sync.WaitGroup
+
+	mail mailQueue
+}
+
+// mailQueue takes turns at the mail listener for sendMail
+type mailQueue struct {
+	sync.Mutex
+	// abandoned counts the results still owed for messages whose sender gave up waiting
+	abandoned int
 }
 
+// Home shows the home page, where a user who belongs to more than one organization can switch between them
 func (h *Handlers) Home(w http.ResponseWriter, r *http.Request) {
 	defer h.App.LoadTime(time.Now())
-	err := h.render(w, r, "home", nil, nil)
+
+	vars := make(jet.VarMap)
+	if u, ok := userFrom(r); ok {
+		orgs, err := h.models(r).Organizations.ForUser(u.ID)
+		if err != nil {
+			h.App.ErrorLog.Println("error finding organizations of user:", err)
+			h.App.Error500(w, r)
+			return
+		}
+
+		organizationID, _ := data.TenantFrom(r.Context())
+		vars.Set("organizations", orgs)
+		vars.Set("organizationID", organizationID)
+	}
+
+	err := h.render(w, r, "home", vars, nil)
 	if err != nil {
 		h.App.ErrorLog.Println("error rendering:", err)
 	}
@@ -1,11 +1,19 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"myapp/data"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/s-petr/celeritas"
+	"github.com/s-petr/celeritas/mailer"
 )
 
 func TestHome(t *testing.T) {
@@ -56,3 +64,74 @@ func TestHome2(t *testing.T) {
 		t.Errorf("could not fetch saved value from session; expected test=\"hello world\", got test=\"%s\"", cel.Session.Get(ctx, "test"))
 	}
 }
+
+func TestAddFieldErrors(t *testing.T) {
+	v := cel.Validator(nil)
+
+	if testHandlers.addFieldErrors(v, errors.New("database is down")) || !v.Valid() {
+		t.Error("other error added to the form")
+	}
+
+	err := fmt.Errorf("registering: %w", data.ValidatePassword("password", data.User{}))
+	if !testHandlers.addFieldErrors(v, err) || v.Errors["password"] == "" {
+		t.Error("password policy error not added to the form")
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	app := celeritas.Celeritas{Mail: mailer.Mail{Jobs: make(chan mailer.Message), Results: make(chan mailer.Result)}}
+	h := Handlers{App: &app, Background: &sync.WaitGroup{}}
+
+	// a listener failing every message with an error naming its recipient
+	stalled := make(chan bool)
+	go func() {
+		for msg := range app.Mail.Jobs {
+			if msg.To == "slow@mail.test" {
+				<-stalled
+			}
+			app.Mail.Results <- mailer.Result{Error: errors.New(msg.To)}
+		}
+	}()
+	defer close(app.Mail.Jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		to := fmt.Sprintf("user%d@mail.test", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.sendMail(mailer.Message{To: to}); err == nil || err.Error() != to {
+				t.Errorf("sending to %s got the result %v", to, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	defer func(timeout time.Duration) { mailTimeout = timeout }(mailTimeout)
+	mailTimeout = 50 * time.Millisecond
+
+	if err := h.sendMail(mailer.Message{To: "slow@mail.test"}); !errors.Is(err, errMailTimeout) {
+		t.Errorf("expected errMailTimeout from a stalled listener, got %v", err)
+	}
+
+	// the late result of the abandoned message must not be taken for the result of the next one
+	close(stalled)
+	if err := h.sendMail(mailer.Message{To: "next@mail.test"}); err == nil || err.Error() != "next@mail.test" {
+		t.Errorf("expected the result for next@mail.test, got %v", err)
+	}
+
+	done := make(chan bool)
+	h.background(func() { <-done })
+	waited := make(chan bool)
+	go func() {
+		h.Background.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Error("background work not waited for")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(done)
+	<-waited
+}
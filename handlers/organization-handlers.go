@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/data"
+	"net/http"
+	"strconv"
+)
+
+// SwitchOrganization moves the logged in user to the organization in the organization_id
+// field, which Tenant then scopes their requests to, and sends them back to the page in
+// return_to. Users can only switch to an organization they are a member of.
+func (h *Handlers) SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.App.ErrorLog.Println(err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	u, ok := userFrom(r)
+	if !ok {
+		h.App.ErrorUnauthorized(w, r)
+		return
+	}
+
+	organizationID, err := strconv.Atoi(r.Form.Get("organization_id"))
+	if err != nil {
+		h.App.ErrorStatus(w, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.models(r).Organizations.Membership(organizationID, u.ID); err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			h.App.ErrorForbidden(w, r)
+			return
+		}
+
+		h.App.ErrorLog.Println("error checking organization membership:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	h.sessionPut(r.Context(), "organizationID", organizationID)
+	http.Redirect(w, r, localPath(r.Form.Get("return_to")), http.StatusSeeOther)
+}
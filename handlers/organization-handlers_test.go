@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSwitchOrganization(t *testing.T) {
+	u := insertUser(t, "switch@organization.test", true)
+
+	var orgIDs []int
+	for _, name := range []string{"Acme", "Globex"} {
+		id, err := testHandlers.Models.Organizations.Insert(data.Organization{Name: name, Slug: strings.ToLower(name) + "-switch"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testHandlers.Models.Organizations.AddMember(id, u.ID, data.RoleMember); err != nil {
+			t.Fatal(err)
+		}
+		orgIDs = append(orgIDs, id)
+	}
+	other, err := testHandlers.Models.Organizations.Insert(data.Organization{Name: "Initech", Slug: "initech-switch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, _ := serveWithSession(testMiddleware.LoadUser(http.HandlerFunc(testHandlers.Home)), "GET", "/", sessionToken(t, map[string]any{"userID": u.ID}))
+	if !strings.Contains(rr.Body.String(), `action="/organizations/switch"`) || !strings.Contains(rr.Body.String(), "Globex") {
+		t.Error("user in two organizations not offered a choice between them")
+	}
+
+	switchTo := func(organizationID string) (*httptest.ResponseRecorder, *http.Request) {
+		form := url.Values{"organization_id": {organizationID}, "return_to": {"/account"}}
+		req, _ := http.NewRequest("POST", "/organizations/switch", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Session", sessionToken(t, map[string]any{"userID": u.ID, "organizationID": orgIDs[0]}))
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		testMiddleware.LoadUser(http.HandlerFunc(testHandlers.SwitchOrganization)).ServeHTTP(rr, req)
+		return rr, req
+	}
+
+	rr, req := switchTo(strconv.Itoa(orgIDs[1]))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account" {
+		t.Errorf("expected a redirect back after switching, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if got := cel.Session.GetInt(req.Context(), "organizationID"); got != orgIDs[1] {
+		t.Errorf("expected organization %d in the session, got %d", orgIDs[1], got)
+	}
+
+	rr, req = switchTo(strconv.Itoa(other))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("switching to an organization of others: expected 403, got %d", rr.Code)
+	}
+	if got := cel.Session.GetInt(req.Context(), "organizationID"); got != orgIDs[0] {
+		t.Errorf("organization in the session changed to one of others: %d", got)
+	}
+
+	if rr, _ := switchTo("acme"); rr.Code != http.StatusBadRequest {
+		t.Errorf("switching to a malformed organization id: expected 400, got %d", rr.Code)
+	}
+}
@@ -2,9 +2,14 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"log"
+	"myapp/data"
+	"myapp/middleware"
+	"myapp/migrations"
 	"net/http"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,11 +19,46 @@ import (
 	"github.com/s-petr/celeritas"
 	"github.com/s-petr/celeritas/mailer"
 	"github.com/s-petr/celeritas/render"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var cel celeritas.Celeritas
 var testSession *scs.SessionManager
 var testHandlers Handlers
+var testMailbox mailbox
+var testMiddleware *middleware.Middleware
+
+// mailbox keeps the messages sent through the mailer of the test application
+type mailbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+// listen stands in for the mail listener of celeritas, taking every message as sent
+func (b *mailbox) listen(mail mailer.Mail) {
+	for msg := range mail.Jobs {
+		b.mu.Lock()
+		b.messages = append(b.messages, msg)
+		b.mu.Unlock()
+
+		mail.Results <- mailer.Result{Success: true}
+	}
+}
+
+// sentTo returns the messages sent to the address, oldest first
+func (b *mailbox) sentTo(to string) []mailer.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var sent []mailer.Message
+	for _, msg := range b.messages {
+		if msg.To == to {
+			sent = append(sent, msg)
+		}
+	}
+
+	return sent
+}
 
 func TestMain(m *testing.M) {
 	infoLog := log.New(os.Stdout, "INFO  ", log.Ldate|log.Ltime)
@@ -30,6 +70,23 @@ func TestMain(m *testing.M) {
 	testSession.Cookie.SameSite = http.SameSiteLaxMode
 	testSession.Cookie.Secure = false
 
+	// handler tests run against an in-memory sqlite database, so they need no containers
+	os.Setenv("DATABASE_TYPE", "sqlite")
+
+	testDB, err := sql.Open("sqlite3", "file:handlers_test?mode=memory&cache=shared&_fk=1")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migrator, err := migrations.New(testDB, os.Getenv("DATABASE_TYPE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Fatalf("error creating tables: %s", err)
+	}
+
 	var views = jet.NewSet(
 		jet.NewOSFileSystemLoader("../views"),
 		jet.InDevelopmentMode(),
@@ -52,24 +109,59 @@ func TestMain(m *testing.M) {
 		Routes:        nil,
 		Render:        &myRenderer,
 		Session:       testSession,
-		DB:            celeritas.Database{},
+		DB:            celeritas.Database{Pool: testDB},
 		JetViews:      views,
 		EncryptionKey: cel.RandomString(32),
 		Cache:         nil,
 		Scheduler:     nil,
-		Mail:          mailer.Mail{},
+		Mail:          mailer.Mail{Jobs: make(chan mailer.Message), Results: make(chan mailer.Result)},
 		Server:        celeritas.Server{},
 	}
 
+	go testMailbox.listen(cel.Mail)
+
+	// the cheapest bcrypt cost keeps the many logins of the tests fast
+	data.SetPasswordHasher(data.BcryptHasher{Cost: bcrypt.MinCost})
+
 	testHandlers.App = &cel
+	testMiddleware = middleware.New(&cel)
+	testMiddleware.Models = &testHandlers.Models
+	testHandlers.Remember = testMiddleware
+	testHandlers.CSRF = testMiddleware
+	testHandlers.Models, err = data.New(testDB)
+	if err != nil {
+		log.Fatalf("error creating models: %s", err)
+	}
 
-	os.Exit(m.Run())
+	code := m.Run()
+
+	testDB.Close()
+	os.Exit(code)
 }
 
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
 	mux.Use(cel.SessionLoad)
+	mux.Use(testMiddleware.Global()...)
 	mux.Get("/", testHandlers.Home)
+	mux.Group(func(r chi.Router) {
+		r.Use(testMiddleware.Guest)
+		r.Get("/users/login", testHandlers.Login)
+		r.Post("/users/login", testHandlers.PostLogin)
+		r.Get("/users/register", testHandlers.Register)
+		r.Post("/users/register", testHandlers.PostRegister)
+		r.Get("/auth/{provider}", testHandlers.SocialLogin)
+		r.Get("/auth/{provider}/callback", testHandlers.SocialCallback)
+	})
+	mux.With(testMiddleware.Auth).Post("/organizations/switch", testHandlers.SwitchOrganization)
+	mux.Post("/users/logout", testHandlers.Logout)
+	mux.Get("/users/verify", testHandlers.VerifyEmail)
+	mux.Get("/users/verify/resend", testHandlers.ResendVerification)
+	mux.Post("/users/verify/resend", testHandlers.PostResendVerification)
+	mux.Get("/users/forgot-password", testHandlers.ForgotPassword)
+	mux.Post("/users/forgot-password", testHandlers.PostForgotPassword)
+	mux.Get("/users/reset-password", testHandlers.ResetPassword)
+	mux.Post("/users/reset-password", testHandlers.PostResetPassword)
 
 	fileServer := http.FileServer(http.Dir("./../public"))
 	mux.Handle("/public/*", http.StripPrefix("/public", fileServer))
@@ -77,6 +169,24 @@ func getRoutes() http.Handler {
 	return mux
 }
 
+// getAPIRoutes returns the routes of the API the tests use, as routes-api.go mounts them at /api
+func getAPIRoutes() http.Handler {
+	mux := chi.NewRouter()
+	mux.Route("/api", func(r chi.Router) {
+		r.Group(func(mux chi.Router) {
+			mux.Use(testMiddleware.AuthToken())
+			mux.Get("/me", testHandlers.ApiMe)
+		})
+
+		r.Group(func(mux chi.Router) {
+			mux.Use(testMiddleware.AuthToken("users:read"))
+			mux.With(testMiddleware.RequirePermission("users.read")).Get("/users", testHandlers.ApiUsers)
+		})
+	})
+
+	return mux
+}
+
 func getCtx(r *http.Request) context.Context {
 	ctx, err := testSession.Load(r.Context(), r.Header.Get("X-Session"))
 	if err != nil {
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"myapp/data"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/markbates/goth"
+)
+
+// errUnverifiedEmail is returned when a provider does not vouch for the email address of an account
+var errUnverifiedEmail = errors.New("provider did not verify the email address")
+
+const socialLoginFailed = "Logging in with that account did not work. Try again, or log in with your email and password."
+
+// socialProviders returns the names of the social login providers in use, sorted
+func socialProviders() []string {
+	names := make([]string, 0, len(goth.GetProviders()))
+	for name := range goth.GetProviders() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SocialLogin sends the user to the provider named in the URL to log in there. The state
+// sent along is kept in the session and checked by SocialCallback, so a callback cannot be
+// forged from another site.
+func (h *Handlers) SocialLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := goth.GetProvider(chi.URLParam(r, "provider"))
+	if err != nil {
+		h.App.Error404(w, r)
+		return
+	}
+
+	state, err := data.RandomToken()
+	if err != nil {
+		h.App.ErrorLog.Println("error generating oauth state:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	session, err := provider.BeginAuth(state)
+	if err != nil {
+		h.App.ErrorLog.Println("error starting social login:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		h.App.ErrorLog.Println("error starting social login:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	h.sessionPut(r.Context(), "oauthProvider", provider.Name())
+	h.sessionPut(r.Context(), "oauthState", state)
+	h.sessionPut(r.Context(), "oauthSession", session.Marshal())
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// SocialCallback finishes logging in with a provider. The account is matched to a user by
+// an identity linked before, or else by its email address, which the provider must have
+// verified; a user is created when there is none with that address.
+func (h *Handlers) SocialCallback(w http.ResponseWriter, r *http.Request) {
+	provider, err := goth.GetProvider(chi.URLParam(r, "provider"))
+	if err != nil {
+		h.App.Error404(w, r)
+		return
+	}
+
+	providerName, _ := h.sessionGet(r.Context(), "oauthProvider").(string)
+	state, _ := h.sessionGet(r.Context(), "oauthState").(string)
+	marshalled, _ := h.sessionGet(r.Context(), "oauthSession").(string)
+
+	// the state is single use, whatever comes of this callback
+	h.sessionRemove(r.Context(), "oauthProvider")
+	h.sessionRemove(r.Context(), "oauthState")
+	h.sessionRemove(r.Context(), "oauthSession")
+
+	query := r.URL.Query()
+
+	if providerName != provider.Name() || state == "" ||
+		subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		h.socialLoginFailed(w, r)
+		return
+	}
+
+	if query.Get("error") != "" {
+		// the user declined, or the provider refused, to log them in
+		h.socialLoginFailed(w, r)
+		return
+	}
+
+	session, err := provider.UnmarshalSession(marshalled)
+	if err != nil {
+		h.App.ErrorLog.Println("error restoring social login session:", err)
+		h.socialLoginFailed(w, r)
+		return
+	}
+
+	if _, err := session.Authorize(provider, query); err != nil {
+		h.App.InfoLog.Println("social login not authorized by", provider.Name()+":", err)
+		h.socialLoginFailed(w, r)
+		return
+	}
+
+	account, err := provider.FetchUser(session)
+	if err != nil {
+		h.App.InfoLog.Println("error fetching user from", provider.Name()+":", err)
+		h.socialLoginFailed(w, r)
+		return
+	}
+
+	u, err := h.socialUser(r, account)
+	switch {
+	case errors.Is(err, errUnverifiedEmail):
+		h.sessionPut(r.Context(), "error", "Your "+provider.Name()+" account has no verified email address, so it cannot be used to log in here.")
+		http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+		return
+	case errors.Is(err, data.ErrNotFound), errors.Is(err, data.ErrDuplicateEmail), errors.Is(err, data.ErrStaleRecord):
+		// the account is linked to a user who has since been deleted, its address still belongs to one,
+		// or the unverified user taken up by the login was changed at the same moment
+		h.socialLoginFailed(w, r)
+		return
+	case err != nil:
+		h.App.ErrorLog.Println("error finding user for social login:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	if err := h.logIn(w, r, u); err != nil {
+		h.App.ErrorLog.Println("error renewing session token:", err)
+		h.App.Error500(w, r)
+		return
+	}
+
+	h.sessionPut(r.Context(), "flash", "Welcome, "+u.FirstName+".")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handlers) socialLoginFailed(w http.ResponseWriter, r *http.Request) {
+	h.sessionPut(r.Context(), "error", socialLoginFailed)
+	http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+}
+
+// socialUser returns the user the provider account belongs to, linking the account to the user
+// with its verified email address, or to a new user, the first time it is used
+func (h *Handlers) socialUser(r *http.Request, account goth.User) (*data.User, error) {
+	var u *data.User
+
+	err := h.models(r).WithTx(r.Context(), func(tx data.Models) error {
+		identity, err := tx.UserIdentities.Get(account.Provider, account.UserID)
+		switch {
+		case err == nil:
+			u, err = tx.Users.Get(identity.UserID)
+			return err
+		case !errors.Is(err, data.ErrNotFound):
+			return err
+		}
+
+		email := strings.TrimSpace(account.Email)
+		if email == "" || !emailVerified(account) {
+			return errUnverifiedEmail
+		}
+
+		u, err = tx.Users.GetByEmail(email)
+		switch {
+		case errors.Is(err, data.ErrNotFound):
+			if u, err = insertSocialUser(tx, account, email); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case u.Active == 0:
+			if err := claimUnverifiedUser(tx, u); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.UserIdentities.Insert(data.UserIdentity{
+			UserID:         u.ID,
+			Provider:       account.Provider,
+			ProviderUserID: account.UserID,
+			Email:          email,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// claimUnverifiedUser activates u for the owner of their email address, whom the provider has
+// verified. Whoever registered the address never proved they own it, so the password they chose
+// and any tokens issued to them are replaced, or they could log in to the account once it is taken up.
+func claimUnverifiedUser(tx data.Models, u *data.User) error {
+	password, err := data.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	hash, err := data.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	u.Active = 1
+	u.Password = hash
+	if err := tx.Users.Update(*u); err != nil {
+		return err
+	}
+
+	if err := tx.RememberTokens.DeleteForUser(u.ID); err != nil {
+		return err
+	}
+
+	tokens, err := tx.Tokens.GetTokensForUser(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := tx.Tokens.Delete(token.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertSocialUser creates an active user for the provider account. They get a random password,
+// which they can replace through a password reset if they want to log in without the provider.
+func insertSocialUser(tx data.Models, account goth.User, email string) (*data.User, error) {
+	password, err := data.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// nobody chose the password, so it is stored without being checked by the password policy,
+	// which could refuse it for happening to contain part of the name or address
+	hash, err := data.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	u := data.User{
+		FirstName: account.FirstName,
+		LastName:  account.LastName,
+		Email:     email,
+		Active:    1,
+		Password:  hash,
+	}
+
+	if u.FirstName == "" && u.LastName == "" {
+		u.FirstName, u.LastName, _ = strings.Cut(strings.TrimSpace(account.Name), " ")
+	}
+	if u.FirstName == "" {
+		u.FirstName, _, _ = strings.Cut(email, "@")
+	}
+
+	if u.ID, err = tx.Users.InsertWithHash(u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// emailVerified reports whether the provider says it has verified the email address of the account
+func emailVerified(account goth.User) bool {
+	for _, claim := range []string{"email_verified", "verified_email"} {
+		switch verified := account.RawData[claim].(type) {
+		case bool:
+			return verified
+		case string:
+			return verified == "true"
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+const (
+	fakeClientKey    = "fake-client"
+	fakeClientSecret = "fake-secret"
+)
+
+// fakeOIDC is an OpenID Connect provider running in the test process. It logs in whoever
+// has the claims set by next without asking, so the whole flow runs without a network.
+type fakeOIDC struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	claims map[string]any
+	codes  map[string]map[string]any
+	tokens map[string]map[string]any
+	issued int
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	t.Helper()
+
+	f := &fakeOIDC{codes: make(map[string]map[string]any), tokens: make(map[string]map[string]any)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/userinfo", f.userinfo)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	provider, err := openidConnect.NewCustomisedURL(fakeClientKey, fakeClientSecret, "http://localhost/auth/fake/callback",
+		f.URL+"/authorize", f.URL+"/token", f.URL, f.URL+"/userinfo", "", "email", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.SetName("fake")
+
+	goth.UseProviders(provider)
+	t.Cleanup(goth.ClearProviders)
+
+	return f
+}
+
+// next sets the claims of the account the provider logs in from now on
+func (f *fakeOIDC) next(claims map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.claims = claims
+}
+
+func (f *fakeOIDC) issue(prefix string) string {
+	f.issued++
+	return fmt.Sprintf("%s-%d", prefix, f.issued)
+}
+
+func (f *fakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	if query.Get("client_id") != fakeClientKey || query.Get("response_type") != "code" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := f.issue("code")
+	f.codes[code] = f.claims
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key, secret, ok := r.BasicAuth()
+	if !ok {
+		key, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if key != fakeClientKey || secret != fakeClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	claims, ok := f.codes[r.PostFormValue("code")]
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	delete(f.codes, r.PostFormValue("code"))
+
+	idClaims := map[string]any{"iss": f.URL, "aud": fakeClientKey, "exp": time.Now().Add(time.Hour).Unix()}
+	for claim, value := range claims {
+		idClaims[claim] = value
+	}
+	payload, _ := json.Marshal(idClaims)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	accessToken := f.issue("access")
+	f.tokens[accessToken] = claims
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".",
+	})
+}
+
+func (f *fakeOIDC) userinfo(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	claims, ok := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+// serveSocial calls handler for the provider in the URL with the session of token, returning
+// the response, the session context and the token of the session the handler left behind
+func serveSocial(t *testing.T, handler http.HandlerFunc, provider, target, token string) (*httptest.ResponseRecorder, *http.Request, string) {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", target, nil)
+	req.Header.Set("X-Session", token)
+	req = req.WithContext(getCtx(req))
+
+	routeContext := chi.NewRouteContext()
+	routeContext.URLParams.Add("provider", provider)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeContext))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	token, _, err := testSession.Commit(req.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rr, req, token
+}
+
+// socialLogin goes through the provider as a browser would, returning the response to the callback
+func socialLogin(t *testing.T) (*httptest.ResponseRecorder, *http.Request) {
+	t.Helper()
+
+	rr, _, token := serveSocial(t, testHandlers.SocialLogin, "fake", "/auth/fake", sessionToken(t, nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to the provider, got %d", rr.Code)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Path != "/auth/fake/callback" {
+		t.Fatalf("provider did not send the user back to the callback: %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	rr, req, _ := serveSocial(t, testHandlers.SocialCallback, "fake", callback.RequestURI(), token)
+	return rr, req
+}
+
+func TestSocialLogin(t *testing.T) {
+	fake := newFakeOIDC(t)
+
+	fake.next(map[string]any{"sub": "1001", "email": "social@auth.test", "email_verified": true, "given_name": "Sam", "family_name": "Jones"})
+	rr, req := socialLogin(t)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("expected a redirect home after logging in, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	created, err := testHandlers.Models.Users.GetByEmail("social@auth.test")
+	if err != nil {
+		t.Fatal("no user created for the new account:", err)
+	}
+	if created.Active != 1 || created.FirstName != "Sam" || created.LastName != "Jones" {
+		t.Errorf("user created with the wrong details: %+v", created)
+	}
+	if cel.Session.GetInt(req.Context(), "userID") != created.ID {
+		t.Error("new user not logged in")
+	}
+	if identity, err := testHandlers.Models.UserIdentities.Get("fake", "1001"); err != nil || identity.UserID != created.ID {
+		t.Error("account not linked to the new user:", err)
+	}
+
+	// the email address given by the provider no longer matters once the account is linked
+	fake.next(map[string]any{"sub": "1001", "email": "renamed@auth.test", "email_verified": true})
+	if _, req := socialLogin(t); cel.Session.GetInt(req.Context(), "userID") != created.ID {
+		t.Error("linked account did not log in its user")
+	}
+
+	// an existing user is linked by their email address, which the provider has verified for them
+	u := insertUser(t, "existing@auth.test", true)
+	fake.next(map[string]any{"sub": "1002", "email": "existing@auth.test", "email_verified": true})
+	if _, req := socialLogin(t); cel.Session.GetInt(req.Context(), "userID") != u.ID {
+		t.Error("account not matched to the user with its email address")
+	}
+	if identity, err := testHandlers.Models.UserIdentities.Get("fake", "1002"); err != nil || identity.UserID != u.ID {
+		t.Error("account not linked to the existing user:", err)
+	}
+	if stored, _ := testHandlers.Models.Users.Get(u.ID); stored == nil {
+		t.Error("existing user not found")
+	} else if matches, _ := stored.PasswordMatches(testPassword); !matches {
+		t.Error("password of a verified user replaced by linking their account")
+	}
+}
+
+func TestSocialLogin_UnverifiedUser(t *testing.T) {
+	fake := newFakeOIDC(t)
+
+	// someone registered the address of another person with a password of their own, and never verified it
+	squatter := insertUser(t, "squatted@auth.test", false)
+	rememberToken, err := testHandlers.Models.RememberTokens.Issue(squatter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiToken, err := testHandlers.Models.Tokens.GenerateToken(squatter.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testHandlers.Models.Tokens.Insert(*apiToken, *squatter); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.next(map[string]any{"sub": "3001", "email": "squatted@auth.test", "email_verified": true})
+	if _, req := socialLogin(t); cel.Session.GetInt(req.Context(), "userID") != squatter.ID {
+		t.Fatal("owner of the email address not logged in")
+	}
+
+	stored, err := testHandlers.Models.Users.Get(squatter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Active != 1 {
+		t.Error("user not activated by a provider verifying their email address")
+	}
+	if matches, _ := stored.PasswordMatches(testPassword); matches {
+		t.Error("password chosen before the email address was verified still logs in")
+	}
+	if valid, _ := testHandlers.Models.RememberTokens.Valid(squatter.ID, rememberToken); valid {
+		t.Error("remember token issued before the email address was verified still valid")
+	}
+	if valid, _ := testHandlers.Models.Tokens.ValidToken(apiToken.PlainText); valid {
+		t.Error("API token issued before the email address was verified still valid")
+	}
+}
+
+func TestSocialLogin_DeletedUser(t *testing.T) {
+	fake := newFakeOIDC(t)
+
+	u := insertUser(t, "deleted-social@auth.test", true)
+	if _, err := testHandlers.Models.UserIdentities.Insert(data.UserIdentity{UserID: u.ID, Provider: "fake", ProviderUserID: "4001", Email: u.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testHandlers.Models.Users.Delete(u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.next(map[string]any{"sub": "4001", "email": "deleted-social@auth.test", "email_verified": true})
+	rr, req := socialLogin(t)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Errorf("expected a redirect to log in, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cel.Session.Exists(req.Context(), "userID") {
+		t.Error("deleted user logged in")
+	}
+}
+
+func TestSocialLogin_Refused(t *testing.T) {
+	fake := newFakeOIDC(t)
+
+	insertUser(t, "victim@auth.test", true)
+	fake.next(map[string]any{"sub": "2001", "email": "victim@auth.test", "email_verified": false})
+
+	rr, req := socialLogin(t)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+		t.Errorf("unverified email: expected a redirect to log in, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cel.Session.Exists(req.Context(), "userID") {
+		t.Error("unverified email: user logged in")
+	}
+	if _, err := testHandlers.Models.UserIdentities.Get("fake", "2001"); err == nil {
+		t.Error("unverified email: account linked")
+	}
+
+	// every case starts logging in afresh, since the state kept in the session is single use
+	for name, callback := range map[string]func(state string) url.Values{
+		"state mismatch": func(string) url.Values { return url.Values{"code": {"anything"}, "state": {"forged"}} },
+		"no state":       func(string) url.Values { return url.Values{"code": {"anything"}} },
+		"declined":       func(state string) url.Values { return url.Values{"error": {"access_denied"}, "state": {state}} },
+	} {
+		rr, _, token := serveSocial(t, testHandlers.SocialLogin, "fake", "/auth/fake", sessionToken(t, nil))
+		location, _ := url.Parse(rr.Header().Get("Location"))
+
+		target := "/auth/fake/callback?" + callback(location.Query().Get("state")).Encode()
+		rr, req, _ := serveSocial(t, testHandlers.SocialCallback, "fake", target, token)
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/users/login" {
+			t.Errorf("%s: expected a redirect to log in, got %d to %q", name, rr.Code, rr.Header().Get("Location"))
+		}
+		if cel.Session.Exists(req.Context(), "userID") {
+			t.Errorf("%s: user logged in", name)
+		}
+	}
+
+	// a callback the session did not start, such as one replayed in another browser
+	rr, req, _ = serveSocial(t, testHandlers.SocialCallback, "fake", "/auth/fake/callback?code=anything&state=anything", sessionToken(t, nil))
+	if rr.Code != http.StatusSeeOther || cel.Session.Exists(req.Context(), "userID") {
+		t.Errorf("callback without a login started: expected a redirect without logging in, got %d", rr.Code)
+	}
+}
+
+func TestSocialLogin_UnknownProvider(t *testing.T) {
+	newFakeOIDC(t)
+
+	for _, handler := range []http.HandlerFunc{testHandlers.SocialLogin, testHandlers.SocialCallback} {
+		if rr, _, _ := serveSocial(t, handler, "nope", "/auth/nope", sessionToken(t, nil)); rr.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown provider, got %d", rr.Code)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"myapp/handlers"
 	"myapp/middleware"
 	"os"
+	"time"
 
 	"github.com/s-petr/celeritas"
 )
@@ -24,20 +25,44 @@ func initApplication() *application {
 
 	cel.AppName = "myapp"
 
-	myMiddleware := &middleware.Middleware{
-		App: cel,
-	}
+	myMiddleware := middleware.New(cel)
+
+	myHandlers := &handlers.Handlers{App: cel, Remember: myMiddleware, CSRF: myMiddleware}
 
-	myHandlers := &handlers.Handlers{App: cel}
+	if lifetime := os.Getenv("PASSWORD_RESET_LIFETIME"); lifetime != "" {
+		myHandlers.PasswordResetLifetime, err = time.ParseDuration(lifetime)
+		if err != nil {
+			log.Fatalf("PASSWORD_RESET_LIFETIME is not a duration such as 30m: %s", err)
+		}
+	}
 
 	app := &application{App: cel,
 		Handlers:   myHandlers,
 		Middleware: myMiddleware,
 	}
+	myHandlers.Background = &app.wg
 
 	app.App.Routes = app.routes()
 
-	app.Models = data.New(app.App.DB.Pool)
+	if err := app.useSocialProviders(); err != nil {
+		log.Fatal(err)
+	}
+
+	hasher, err := data.PasswordHasherFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data.SetPasswordHasher(hasher)
+
+	replica, err := app.openReplica()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.Models, err = data.New(app.App.DB.Pool, replica)
+	if err != nil {
+		log.Fatal(err)
+	}
 	myHandlers.Models = app.Models
 	app.Middleware.Models = &app.Models
 
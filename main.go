@@ -24,6 +24,21 @@ type application struct {
 
 func main() {
 	c := initApplication()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := c.migrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "purge-users" {
+		if err := c.purgeUsers(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	go c.listenForShutDown()
 	log.Fatal(c.App.ListenAndServe())
 }
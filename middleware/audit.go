@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"myapp/data"
+	"net"
+	"net/http"
+)
+
+// AuditActor stores the logged in user and the client address in the request context, so the
+// changes made through data.Models.WithContext are attributed to them in the audit log
+func (m *Middleware) AuditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		actor := data.Actor{Via: data.ActorAnonymous, IP: clientIP(r)}
+		if userID := m.App.Session.GetInt(ctx, "userID"); userID != 0 {
+			actor.UserID = userID
+			actor.Via = data.ActorSession
+		}
+
+		next.ServeHTTP(w, r.WithContext(data.WithActor(ctx, actor)))
+	})
+}
+
+// clientIP returns the address of the client without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/s-petr/celeritas"
+)
+
+func TestAuditActor(t *testing.T) {
+	session := scs.New()
+	m := Middleware{App: &celeritas.Celeritas{Session: session}}
+
+	var actor data.Actor
+	var ok bool
+	handler := session.LoadAndSave(m.AuditActor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok = data.ActorFrom(r.Context())
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !ok {
+		t.Fatal("no actor in request context")
+	}
+	if actor != (data.Actor{Via: data.ActorAnonymous, IP: "192.0.2.1"}) {
+		t.Errorf("expected an anonymous actor from 192.0.2.1, got %+v", actor)
+	}
+
+	ctx, _ := session.Load(req.Context(), "")
+	session.Put(ctx, "userID", 7)
+	token, _, err := session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if actor != (data.Actor{UserID: 7, Via: data.ActorSession, IP: "192.0.2.1"}) {
+		t.Errorf("expected user 7 logged in from 192.0.2.1, got %+v", actor)
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"errors"
+	"myapp/data"
+	"net/http"
+	"net/url"
+)
+
+// LoadUser puts the logged in user in the request context, where data.UserFrom finds it. A
+// session whose user no longer exists, for instance because they were deleted, is logged out.
+func (m *Middleware) LoadUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID := m.App.Session.GetInt(ctx, "userID")
+		if userID == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, err := m.models(r).Users.Get(userID)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				m.App.Session.Remove(ctx, "userID")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			m.App.ErrorLog.Println("error loading user:", err)
+			m.App.Error500(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(data.WithUser(ctx, u)))
+	})
+}
+
+// Auth lets only logged in users through. Anyone else is sent to the login form, which brings
+// them back to the page they asked for; the API answers with a 401 instead.
+func (m *Middleware) Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.App.Session.Exists(r.Context(), "userID") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if WantsJSON(r) {
+			m.deny(w, r, http.StatusUnauthorized, "You must be logged in to do that.")
+			return
+		}
+
+		target := "/users/login"
+		// only a page can be returned to; the body of a form is lost on the way
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			target += "?" + url.Values{"return_to": {r.URL.RequestURI()}}.Encode()
+		}
+
+		http.Redirect(w, r, target, http.StatusSeeOther)
+	})
+}
+
+// Guest keeps logged in users away from pages only meant for visitors, such as the login and
+// sign up forms, by sending them home
+func (m *Middleware) Guest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.App.Session.Exists(r.Context(), "userID") {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"myapp/data"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tokenRealm is the realm of the WWW-Authenticate challenges of the API
+const tokenRealm = "api"
+
+// OrganizationHeader is the header naming the organization an API request is made in
+const OrganizationHeader = "X-Organization-ID"
+
+var (
+	// errInvalidOrganization is returned when the X-Organization-ID header is not an id
+	errInvalidOrganization = errors.New("invalid organization id")
+	// errOrganizationRequired is returned when a user of several organizations names none
+	errOrganizationRequired = errors.New("organization required")
+)
+
+// AuthToken returns middleware that lets a request through only with a valid API token in a
+// "Bearer <token>" Authorization header, for use on a route group of the API:
+//
+//	r.Route("/api", func(r chi.Router) {
+//		r.With(a.Middleware.AuthToken("users:read")).Get("/users", a.Handlers.ApiUsers)
+//	})
+//
+// The request must have been made with a token allowing every one of scopes. The user of
+// the token is put in the context for data.UserFrom and data.Models.WithContext, and its
+// scopes for data.ScopesFrom. The request is scoped to the organization whose id is in the
+// X-Organization-ID header, which the user must be a member of. Without the header it is scoped
+// to the only organization of the user, or to data.NoOrganization when they belong to none; a
+// user of several organizations has to name one. Refused requests get a JSON 401, or a 403
+// when the token lacks a scope, with a WWW-Authenticate header as in RFC 6750.
+func (m *Middleware) AuthToken(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, err := m.models(r).Tokens.AuthenticateToken(r)
+			switch {
+			case errors.Is(err, data.ErrNoAuthHeader):
+				m.denyToken(w, http.StatusUnauthorized, fmt.Sprintf("Bearer realm=%q", tokenRealm), "An API token is required.")
+				return
+			case errors.Is(err, data.ErrInvalidAuthHeader), errors.Is(err, data.ErrInvalidToken), errors.Is(err, data.ErrExpiredToken):
+				m.denyToken(w, http.StatusUnauthorized, fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\"", tokenRealm), "The API token is malformed, unknown or expired.")
+				return
+			case err != nil:
+				m.App.ErrorLog.Println("error authenticating token:", err)
+				m.denyToken(w, http.StatusInternalServerError, "", "Something went wrong.")
+				return
+			}
+
+			for _, scope := range scopes {
+				if !u.Token.HasScope(scope) {
+					challenge := fmt.Sprintf("Bearer realm=%q, error=\"insufficient_scope\", scope=%q", tokenRealm, strings.Join(scopes, " "))
+					m.denyToken(w, http.StatusForbidden, challenge, "The API token does not allow this.")
+					return
+				}
+			}
+
+			organizationID, err := m.tokenTenant(r, u.ID)
+			switch {
+			case errors.Is(err, errInvalidOrganization):
+				m.denyToken(w, http.StatusBadRequest, "", "The "+OrganizationHeader+" header must hold the id of an organization.")
+				return
+			case errors.Is(err, errOrganizationRequired):
+				m.denyToken(w, http.StatusBadRequest, "", "Choose one of your organizations with the "+OrganizationHeader+" header.")
+				return
+			case errors.Is(err, data.ErrNotFound):
+				m.denyToken(w, http.StatusForbidden, "", "The user of the API token is not a member of that organization.")
+				return
+			case err != nil:
+				m.App.ErrorLog.Println("error finding organization of token user:", err)
+				m.denyToken(w, http.StatusInternalServerError, "", "Something went wrong.")
+				return
+			}
+
+			ctx := r.Context()
+			actor, _ := data.ActorFrom(ctx)
+			actor.UserID = u.ID
+			actor.Via = data.ActorToken
+			if actor.IP == "" {
+				actor.IP = clientIP(r)
+			}
+
+			ctx = data.WithUser(ctx, u)
+			ctx = data.WithScopes(ctx, u.Token.ScopeList())
+			ctx = data.WithActor(ctx, actor)
+			ctx = data.WithTenant(ctx, organizationID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// tokenTenant returns the organization an API request of the user is scoped to: the one named
+// by the X-Organization-ID header, or ErrNotFound when the user is not a member of it. Without
+// the header it is their only organization, or data.NoOrganization when they belong to none.
+func (m *Middleware) tokenTenant(r *http.Request, userID int) (int, error) {
+	if header := r.Header.Get(OrganizationHeader); header != "" {
+		organizationID, err := strconv.Atoi(header)
+		if err != nil || organizationID <= 0 {
+			return 0, errInvalidOrganization
+		}
+
+		if _, err := m.models(r).Organizations.Membership(organizationID, userID); err != nil {
+			return 0, err
+		}
+
+		return organizationID, nil
+	}
+
+	orgs, err := m.models(r).Organizations.ForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(orgs) {
+	case 0:
+		return data.NoOrganization, nil
+	case 1:
+		return orgs[0].ID, nil
+	default:
+		return 0, errOrganizationRequired
+	}
+}
+
+// denyToken refuses an API request with status and a JSON body, challenging the client to
+// authenticate when challenge is not empty
+func (m *Middleware) denyToken(w http.ResponseWriter, status int, challenge, message string) {
+	if challenge != "" {
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+
+	WriteJSONError(m.App, w, status, message)
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"encoding/json"
+	"myapp/data"
+	"myapp/data/memory"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/s-petr/celeritas"
+)
+
+func TestAuthToken(t *testing.T) {
+	models := memory.New()
+	m := Middleware{App: &celeritas.Celeritas{}, Models: &models}
+
+	userID, _ := models.Users.Insert(data.User{FirstName: "John", Email: "api@test.com", Password: "kettle-harbor-quilt-42"})
+	u, _ := models.Users.Get(userID)
+
+	insertToken := func(scopes string, ttl time.Duration) string {
+		token, _ := models.Tokens.GenerateToken(u.ID, ttl)
+		token.Scopes = scopes
+		if err := models.Tokens.Insert(*token, *u); err != nil {
+			t.Fatal("error inserting token:", err)
+		}
+		return token.PlainText
+	}
+	reader := insertToken("users:read", time.Hour)
+	unscoped := insertToken("", time.Hour)
+	expired := insertToken("", -time.Hour)
+
+	var (
+		loaded *data.User
+		scopes []string
+		actor  data.Actor
+		tenant int
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		loaded, _ = data.UserFrom(r.Context())
+		scopes, _ = data.ScopesFrom(r.Context())
+		actor, _ = data.ActorFrom(r.Context())
+		tenant, _ = data.TenantFrom(r.Context())
+	}
+
+	mux := chi.NewRouter()
+	mux.Route("/api", func(r chi.Router) {
+		r.With(m.AuthToken()).Get("/me", handler)
+		r.With(m.AuthToken("users:write")).Delete("/users/{id}", handler)
+	})
+
+	var organization string
+	serve := func(method, path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if organization != "" {
+			req.Header.Set(OrganizationHeader, organization)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("GET", "/api/me", "Bearer "+reader)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("valid token: expected 200, got %d", rr.Code)
+	}
+	if loaded == nil || loaded.ID != u.ID {
+		t.Error("user of the token not put in the context")
+	}
+	if len(scopes) != 1 || scopes[0] != "users:read" {
+		t.Errorf("scopes of the token not put in the context, got %q", scopes)
+	}
+	if actor.UserID != u.ID || actor.Via != data.ActorToken {
+		t.Errorf("changes not attributed to the token user, got %+v", actor)
+	}
+	if tenant != data.NoOrganization {
+		t.Errorf("user in no organization: expected tenant %d, got %d", data.NoOrganization, tenant)
+	}
+
+	orgID, _ := models.Organizations.Insert(data.Organization{Name: "Acme", Slug: "acme"})
+	if err := models.Organizations.AddMember(orgID, u.ID, data.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve("GET", "/api/me", "Bearer "+reader); rr.Code != http.StatusOK || tenant != orgID {
+		t.Errorf("member of an organization: expected 200 and tenant %d, got %d and tenant %d", orgID, rr.Code, tenant)
+	}
+
+	// a user of several organizations names the one the request is made in
+	otherID, _ := models.Organizations.Insert(data.Organization{Name: "Aardvark", Slug: "aardvark"})
+	if err := models.Organizations.AddMember(otherID, u.ID, data.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve("GET", "/api/me", "Bearer "+reader); rr.Code != http.StatusBadRequest {
+		t.Errorf("member of several organizations without the header: expected 400, got %d", rr.Code)
+	}
+	organization = strconv.Itoa(orgID)
+	if rr := serve("GET", "/api/me", "Bearer "+reader); rr.Code != http.StatusOK || tenant != orgID {
+		t.Errorf("organization in the header: expected 200 and tenant %d, got %d and tenant %d", orgID, rr.Code, tenant)
+	}
+	strangerID, _ := models.Organizations.Insert(data.Organization{Name: "Strangers", Slug: "strangers"})
+	organization = strconv.Itoa(strangerID)
+	if rr := serve("GET", "/api/me", "Bearer "+reader); rr.Code != http.StatusForbidden {
+		t.Errorf("organization the user is not a member of: expected 403, got %d", rr.Code)
+	}
+	organization = "acme"
+	if rr := serve("GET", "/api/me", "Bearer "+reader); rr.Code != http.StatusBadRequest {
+		t.Errorf("organization header that is not an id: expected 400, got %d", rr.Code)
+	}
+	organization = strconv.Itoa(orgID)
+
+	for name, tt := range map[string]struct {
+		method, path, authorization string
+		code                        int
+		challenge                   string
+	}{
+		"no header":     {"GET", "/api/me", "", http.StatusUnauthorized, `Bearer realm="api"`},
+		"not bearer":    {"GET", "/api/me", "Basic " + reader, http.StatusUnauthorized, `error="invalid_token"`},
+		"unknown token": {"GET", "/api/me", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized, `error="invalid_token"`},
+		"expired token": {"GET", "/api/me", "Bearer " + expired, http.StatusUnauthorized, `error="invalid_token"`},
+		"missing scope": {"DELETE", "/api/users/1", "Bearer " + reader, http.StatusForbidden, `error="insufficient_scope", scope="users:write"`},
+	} {
+		rr := serve(tt.method, tt.path, tt.authorization)
+		if rr.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", name, tt.code, rr.Code)
+		}
+		if challenge := rr.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, tt.challenge) {
+			t.Errorf("%s: expected a challenge with %s, got %q", name, tt.challenge, challenge)
+		}
+
+		var payload struct {
+			Error   bool   `json:"error"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil || !payload.Error || payload.Message == "" {
+			t.Errorf("%s: expected a JSON error, got %v", name, err)
+		}
+	}
+
+	if rr := serve("DELETE", "/api/users/1", "Bearer "+unscoped); rr.Code != http.StatusOK {
+		t.Errorf("token without scopes: expected 200, got %d", rr.Code)
+	}
+}
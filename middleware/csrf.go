@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/nosurf"
+)
+
+// csrfNextKey holds the handler a request goes on to once CSRF has let it through
+const csrfNextKey contextKey = "csrfNext"
+
+// CSRF protects the site from cross-site request forgery. Requests with an unsafe method must
+// send back the CSRF token of the page they came from: forms in the csrf_token hidden field,
+// fetch requests in an X-CSRF-Token header taken from the csrf-token meta tag of base.jet. The
+// token lives in a cookie that follows the settings of the session cookie. API calls with a
+// bearer token are exempt, since they carry no cookie a forged request could ride on; without
+// one they are checked like any other request. Refused requests get the forbidden page. Every
+// use of CSRF shares the one token handler made by New.
+func (m *Middleware) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.csrf.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfNextKey, next)))
+	})
+}
+
+// newCSRFHandler returns the token handler of CSRF, which passes the requests it lets through
+// on to the handler CSRF was given for them
+func (m *Middleware) newCSRFHandler() *nosurf.CSRFHandler {
+	csrf := nosurf.New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Context().Value(csrfNextKey).(http.Handler).ServeHTTP(w, r)
+	}))
+
+	csrf.SetBaseCookie(http.Cookie{
+		Path:     "/",
+		Domain:   m.App.Session.Cookie.Domain,
+		MaxAge:   int(m.App.Session.Lifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   m.App.Session.Cookie.Secure,
+		SameSite: m.App.Session.Cookie.SameSite,
+	})
+
+	csrf.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api/") && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+	})
+
+	csrf.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.App.InfoLog.Printf("refused %s %s: %s", r.Method, r.URL.Path, nosurf.Reason(r))
+		m.deny(w, r, http.StatusForbidden, "This form has expired or was not sent from this site. Go back, reload the page and try again.")
+	}))
+
+	return csrf
+}
+
+// RegenerateCSRFToken replaces the CSRF token of the request and its cookie. Call it wherever
+// the session token is renewed, so a token known from before no longer passes. It does nothing
+// for a request that has not been through CSRF.
+func (m *Middleware) RegenerateCSRFToken(w http.ResponseWriter, r *http.Request) {
+	if nosurf.Token(r) == "" {
+		return
+	}
+
+	m.csrf.RegenerateToken(w, r)
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/s-petr/celeritas"
+)
+
+// ErrorResponse is the JSON body of a request that was refused or failed
+type ErrorResponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+}
+
+// WantsJSON reports whether the request is answered with JSON rather than a page
+func WantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// WriteJSONError answers with status and an ErrorResponse holding message
+func WriteJSONError(app *celeritas.Celeritas, w http.ResponseWriter, status int, message string) {
+	if err := app.WriteJSON(w, status, ErrorResponse{Error: true, Message: message}); err != nil {
+		app.ErrorLog.Println(err)
+	}
+}
@@ -2,11 +2,47 @@ package middleware
 
 import (
 	"myapp/data"
+	"net/http"
 
+	"github.com/justinas/nosurf"
 	"github.com/s-petr/celeritas"
 )
 
 type Middleware struct {
 	App    *celeritas.Celeritas
 	Models *data.Models
+
+	// csrf checks the CSRF tokens of the requests passing through CSRF, and RegenerateCSRFToken
+	// renews them with it
+	csrf *nosurf.CSRFHandler
+}
+
+// New returns the middleware of app, whose session must already be set up, since the CSRF
+// cookie follows its settings. Models can be set once they are opened.
+func New(app *celeritas.Celeritas) *Middleware {
+	m := &Middleware{App: app}
+	m.csrf = m.newCSRFHandler()
+
+	return m
+}
+
+// Global returns the middleware every request of the application goes through, in the order
+// it has to run. ReadYourWrites comes before anything that reads, so the current user is loaded
+// from the primary right after they changed something, and Tenant comes after CheckRemember,
+// so a user logged back in by their remember cookie is scoped to their organization as well.
+func (m *Middleware) Global() []func(http.Handler) http.Handler {
+	return []func(http.Handler) http.Handler{
+		m.CSRF,
+		m.ReadYourWrites,
+		m.CheckRemember,
+		m.LoadUser,
+		m.AuditActor,
+		m.Tenant,
+	}
+}
+
+// models returns the models for the request, attributing changes to its actor and reading from
+// the primary after it has written. Middleware running before Tenant gets unscoped models.
+func (m *Middleware) models(r *http.Request) data.Models {
+	return m.Models.WithContext(r.Context())
 }
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"myapp/data"
+	"net/http"
+	"sync"
+
+	"github.com/CloudyKit/jet/v6"
+)
+
+type contextKey string
+
+const permissionsKey contextKey = "permissions"
+
+// permissionCache holds the permissions of the user making a request, loaded the first time
+// one is checked, so several checks in one request cost a single query
+type permissionCache struct {
+	once        sync.Once
+	permissions map[string]bool
+	err         error
+}
+
+// RequirePermission returns middleware that lets a request through only when the user making
+// it has the permission, for use on chi routes:
+//
+//	r.With(a.Middleware.RequirePermission("users.delete")).Delete("/users/{id}", a.Handlers.DeleteUser)
+//
+// Anonymous requests get a 401 and users without the permission a 403, as JSON for the API
+// and as a page otherwise.
+func (m *Middleware) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = m.withPermissionCache(r)
+
+			if m.userID(r) == 0 {
+				m.deny(w, r, http.StatusUnauthorized, "You must be logged in to do that.")
+				return
+			}
+
+			allowed, err := m.HasPermission(r, permission)
+			if err != nil {
+				m.App.ErrorLog.Println("error loading permissions:", err)
+				m.App.Error500(w, r)
+				return
+			}
+
+			if !allowed {
+				m.deny(w, r, http.StatusForbidden, "You do not have permission to do that.")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasPermission reports whether the user making the request has the permission. Behind
+// RequirePermission the permissions are loaded once per request; elsewhere every call loads them.
+func (m *Middleware) HasPermission(r *http.Request, permission string) (bool, error) {
+	userID := m.userID(r)
+	if userID == 0 {
+		return false, nil
+	}
+
+	cache, ok := r.Context().Value(permissionsKey).(*permissionCache)
+	if !ok {
+		cache = &permissionCache{}
+	}
+
+	cache.once.Do(func() {
+		var names []string
+		names, cache.err = m.models(r).Roles.PermissionsForUser(userID)
+
+		cache.permissions = make(map[string]bool, len(names))
+		for _, name := range names {
+			cache.permissions[name] = true
+		}
+	})
+
+	return cache.permissions[permission], cache.err
+}
+
+// withPermissionCache returns r with an empty permission cache, unless it already has one
+func (m *Middleware) withPermissionCache(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(permissionsKey).(*permissionCache); ok {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), permissionsKey, &permissionCache{}))
+}
+
+// userID returns the id of the user making the request, as identified by AuditActor or the
+// session, or 0 for an anonymous request
+func (m *Middleware) userID(r *http.Request) int {
+	if actor, ok := data.ActorFrom(r.Context()); ok && actor.UserID != 0 {
+		return actor.UserID
+	}
+
+	return m.App.Session.GetInt(r.Context(), "userID")
+}
+
+// deny refuses the request with status, as JSON for the API and as a page otherwise
+func (m *Middleware) deny(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if WantsJSON(r) {
+		WriteJSONError(m.App, w, status, message)
+		return
+	}
+
+	vars := make(jet.VarMap)
+	vars.Set("message", message)
+
+	w.WriteHeader(status)
+	if err := m.App.Render.Page(w, r, "forbidden", vars, nil); err != nil {
+		m.App.ErrorLog.Println("error rendering forbidden page:", err)
+		_, _ = w.Write([]byte(http.StatusText(status)))
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"myapp/data"
+	"myapp/data/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CloudyKit/jet/v6"
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/s-petr/celeritas"
+	"github.com/s-petr/celeritas/render"
+)
+
+// countingRoles counts how often the permissions of a user are loaded
+type countingRoles struct {
+	data.RoleRepository
+	loads int
+}
+
+func (r *countingRoles) PermissionsForUser(userID int) ([]string, error) {
+	r.loads++
+	return r.RoleRepository.PermissionsForUser(userID)
+}
+
+func TestRequirePermission(t *testing.T) {
+	models := memory.New()
+	roles := &countingRoles{RoleRepository: models.Roles}
+	models.Roles = roles
+	// without a backend WithContext hands back these models, counting wrapper included
+	models.Backend = nil
+
+	session := scs.New()
+	views := jet.NewSet(jet.NewOSFileSystemLoader("../views"), jet.InDevelopmentMode())
+	m := Middleware{App: &celeritas.Celeritas{
+		Session: session,
+		Render:  &render.Render{Renderer: "jet", RootPath: "../", JetViews: views, Session: session},
+	}, Models: &models}
+
+	admin, _ := models.Users.Insert(data.User{Email: "admin@test.com", Password: "kettle-harbor-quilt-42"})
+	user, _ := models.Users.Insert(data.User{Email: "user@test.com", Password: "kettle-harbor-quilt-42"})
+	roleID, _ := models.Roles.Insert(data.Role{Name: "admin"})
+	_ = models.Roles.Grant(roleID, "users.delete")
+	_ = models.Roles.Grant(roleID, "users.update")
+	_ = models.Roles.Assign(admin, roleID)
+
+	mux := chi.NewRouter()
+	mux.Use(session.LoadAndSave)
+	mux.With(m.RequirePermission("users.update"), m.RequirePermission("users.delete")).
+		Delete("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.With(m.RequirePermission("users.delete")).
+		Delete("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(path string, userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", path, nil)
+		if userID != 0 {
+			ctx, _ := session.Load(context.Background(), "")
+			session.Put(ctx, "userID", userID)
+			token, _, _ := session.Commit(ctx)
+			req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("/users/1", admin); rr.Code != http.StatusOK {
+		t.Errorf("user with the permissions: expected 200, got %d", rr.Code)
+	}
+	if roles.loads != 1 {
+		t.Errorf("expected the permissions to be loaded once per request, got %d loads", roles.loads)
+	}
+
+	rr := serve("/users/1", user)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("user without the permission: expected 403, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Access denied") {
+		t.Error("user without the permission: expected the access denied page, got", rr.Body.String())
+	}
+
+	rr = serve("/api/users/1", user)
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil || rr.Code != http.StatusForbidden || !payload.Error {
+		t.Errorf("API request without the permission: expected a 403 JSON error, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	if rr := serve("/api/users/1", 0); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous API request: expected 401, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"myapp/data"
+	"net/http"
+	"time"
+)
+
+// readYourWritesWindow is how long reads stay on the primary after a request that changes data,
+// long enough for the page it redirects to
+const readYourWritesWindow = 5 * time.Second
+
+// ReadYourWrites marks the context of requests that change data, and of the requests from the
+// same session that follow shortly after, so models taken with data.Models.WithContext read
+// from the primary instead of a replica that may not have caught up yet
+func (m *Middleware) ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			if until := m.App.Session.GetInt64(ctx, "readPrimaryUntil"); until != 0 {
+				if time.Now().Unix() <= until {
+					ctx = data.WithPrimaryReads(ctx)
+				} else {
+					m.App.Session.Remove(ctx, "readPrimaryUntil")
+				}
+			}
+		default:
+			m.App.Session.Put(ctx, "readPrimaryUntil", time.Now().Add(readYourWritesWindow).Unix())
+			ctx = data.WithPrimaryReads(ctx)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
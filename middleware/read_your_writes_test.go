@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"myapp/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/s-petr/celeritas"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	session := scs.New()
+	m := Middleware{App: &celeritas.Celeritas{Session: session}}
+
+	var primary bool
+	handler := session.LoadAndSave(m.ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary = data.PrimaryReads(r.Context())
+	})))
+
+	serve := func(method string, cookies []*http.Cookie) []*http.Cookie {
+		req := httptest.NewRequest(method, "/", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Result().Cookies()
+	}
+
+	if serve("GET", nil); primary {
+		t.Error("plain GET request reads from the primary")
+	}
+
+	cookies := serve("POST", nil)
+	if !primary {
+		t.Error("POST request does not read from the primary")
+	}
+
+	if serve("GET", cookies); !primary {
+		t.Error("GET request right after a POST does not read from the primary")
+	}
+
+	if serve("GET", nil); primary {
+		t.Error("GET request from another session reads from the primary")
+	}
+
+	ctx, _ := session.Load(httptest.NewRequest("GET", "/", nil).Context(), cookies[0].Value)
+	session.Put(ctx, "readPrimaryUntil", time.Now().Add(-time.Second).Unix())
+	if _, _, err := session.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if serve("GET", cookies); primary {
+		t.Error("GET request after the read-your-writes window reads from the primary")
+	}
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"myapp/data"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckRemember logs a user back in from their remember cookie when the session has no userID.
+// A valid cookie rotates the remember token and only then renews the session and CSRF tokens.
+// A malformed cookie, or one of a user who no longer exists, is deleted. Of several requests
+// sent at once with the same cookie, only the one that rotates the token logs in, and the
+// others, whether they find the token already gone or lose the rotation, leave the cookie for
+// its successor.
+func (m *Middleware) CheckRemember(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if m.App.Session.Exists(ctx, "userID") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(m.rememberCookieName())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, token, ok := m.parseRememberCookie(cookie.Value)
+		if !ok {
+			http.SetCookie(w, m.ForgetCookie())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		valid, err := m.models(r).RememberTokens.Valid(userID, token)
+		if err != nil {
+			m.App.ErrorLog.Println("error validating remember token:", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !valid {
+			// another request with the same cookie may have rotated the token a moment ago, and
+			// clearing the cookie could overwrite the new one it sent, so a token that is gone,
+			// whether rotated or revoked, only means there is nobody to log in
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := m.models(r).Users.Get(userID)
+		if err != nil {
+			http.SetCookie(w, m.ForgetCookie())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		newToken, err := m.models(r).RememberTokens.Rotate(user.ID, token)
+		if err != nil {
+			// another request with the same cookie rotated the token first, and its response
+			// carries the new one, so this cookie is left for that to replace
+			if !errors.Is(err, data.ErrInvalidToken) {
+				m.App.ErrorLog.Println("error rotating remember token:", err)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.SetCookie(w, m.RememberCookie(user.ID, newToken))
+
+		if err := m.App.Session.RenewToken(ctx); err != nil {
+			m.App.ErrorLog.Println("error renewing session token:", err)
+			m.App.Error500(w, r)
+			return
+		}
+		m.RegenerateCSRFToken(w, r)
+
+		m.App.Session.Put(ctx, "userID", user.ID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RememberCookie returns the signed cookie that lets CheckRemember log userID back in with token
+func (m *Middleware) RememberCookie(userID int, token string) *http.Cookie {
+	value := fmt.Sprintf("%d|%s", userID, token)
+
+	return &http.Cookie{
+		Name:     m.rememberCookieName(),
+		Value:    value + "|" + m.signRememberValue(value),
+		Path:     "/",
+		Expires:  time.Now().Add(data.RememberTokenLifetime),
+		MaxAge:   int(data.RememberTokenLifetime.Seconds()),
+		HttpOnly: true,
+		Domain:   m.App.Session.Cookie.Domain,
+		Secure:   m.App.Session.Cookie.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ForgetCookie returns a cookie that removes the remember cookie from the browser
+func (m *Middleware) ForgetCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     m.rememberCookieName(),
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now().Add(-100 * time.Hour),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Domain:   m.App.Session.Cookie.Domain,
+		Secure:   m.App.Session.Cookie.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// RememberedToken returns the remember token in the remember cookie of the request, if it has a validly signed one
+func (m *Middleware) RememberedToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(m.rememberCookieName())
+	if err != nil {
+		return "", false
+	}
+
+	_, token, ok := m.parseRememberCookie(cookie.Value)
+	return token, ok
+}
+
+func (m *Middleware) rememberCookieName() string {
+	return fmt.Sprintf("_%s_remember", m.App.AppName)
+}
+
+func (m *Middleware) signRememberValue(value string) string {
+	mac := hmac.New(sha256.New, []byte(m.App.EncryptionKey))
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Middleware) parseRememberCookie(value string) (int, string, bool) {
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 {
+		return 0, "", false
+	}
+
+	signed := parts[0] + "|" + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(m.signRememberValue(signed))) {
+		return 0, "", false
+	}
+
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil || parts[1] == "" {
+		return 0, "", false
+	}
+
+	return userID, parts[1], true
+}
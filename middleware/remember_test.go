@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"myapp/data"
+	"myapp/data/memory"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/s-petr/celeritas"
+)
+
+func TestRememberCookie(t *testing.T) {
+	m := Middleware{App: &celeritas.Celeritas{
+		AppName:       "myapp",
+		EncryptionKey: "abcdefghijklmnopqrstuvwxyz123456",
+		Session:       scs.New(),
+	}}
+
+	cookie := m.RememberCookie(7, "TOKEN")
+	if cookie.Name != "_myapp_remember" {
+		t.Error("wrong cookie name", cookie.Name)
+	}
+
+	id, token, ok := m.parseRememberCookie(cookie.Value)
+	if !ok || id != 7 || token != "TOKEN" {
+		t.Errorf("failed to parse signed cookie; got id=%d token=%q ok=%v", id, token, ok)
+	}
+
+	tampered := strings.Replace(cookie.Value, "7|", "8|", 1)
+	if _, _, ok := m.parseRememberCookie(tampered); ok {
+		t.Error("accepted cookie with tampered user id")
+	}
+
+	for _, value := range []string{"", "7|TOKEN", "x|TOKEN|sig", "7||" + m.signRememberValue("7|")} {
+		if _, _, ok := m.parseRememberCookie(value); ok {
+			t.Errorf("accepted malformed cookie %q", value)
+		}
+	}
+
+	other := Middleware{App: &celeritas.Celeritas{AppName: "myapp", EncryptionKey: "another key", Session: scs.New()}}
+	if _, _, ok := other.parseRememberCookie(cookie.Value); ok {
+		t.Error("accepted cookie signed with a different key")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, ok := m.RememberedToken(req); ok {
+		t.Error("found a remember token without a cookie")
+	}
+	req.AddCookie(cookie)
+	if token, ok := m.RememberedToken(req); !ok || token != "TOKEN" {
+		t.Errorf("failed to read remember token from cookie; got %q ok=%v", token, ok)
+	}
+
+	if m.ForgetCookie().MaxAge >= 0 {
+		t.Error("forget cookie does not expire the remember cookie")
+	}
+}
+
+func TestCheckRemember(t *testing.T) {
+	models := memory.New()
+	session := scs.New()
+	m := Middleware{App: &celeritas.Celeritas{
+		AppName:       "myapp",
+		EncryptionKey: "abcdefghijklmnopqrstuvwxyz123456",
+		Session:       session,
+		InfoLog:       log.New(io.Discard, "", 0),
+		ErrorLog:      log.New(io.Discard, "", 0),
+	}, Models: &models}
+
+	var userID int
+	var flash string
+	handler := session.LoadAndSave(m.CheckRemember(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = session.GetInt(r.Context(), "userID")
+		flash = session.GetString(r.Context(), "error")
+	})))
+
+	// serve sends a request with a fresh session and the remember cookie, returning the response
+	// and the token of the session the request started with
+	serve := func(remember *http.Cookie) (*httptest.ResponseRecorder, string) {
+		ctx, _ := session.Load(context.Background(), "")
+		session.Put(ctx, "visited", true)
+		token, _, err := session.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		userID, flash = 0, ""
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+		req.AddCookie(remember)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr, token
+	}
+
+	cookie := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	id, err := models.Users.Insert(data.User{FirstName: "John", LastName: "Smith", Email: "remember@test.com", Password: "kettle-harbor-quilt-42", Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := models.RememberTokens.Issue(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, sessionToken := serve(m.RememberCookie(id, token))
+	if userID != id {
+		t.Fatalf("remembered user not logged in, got user %d", userID)
+	}
+	if renewed := cookie(rr, session.Cookie.Name); renewed == nil || renewed.Value == sessionToken {
+		t.Error("session token not renewed when logging in from the remember cookie")
+	}
+	reissued := cookie(rr, m.rememberCookieName())
+	if reissued == nil {
+		t.Fatal("remember cookie not reissued")
+	}
+	reissuedID, rotated, ok := m.parseRememberCookie(reissued.Value)
+	if !ok || reissuedID != id || rotated == token {
+		t.Fatalf("reissued cookie does not hold a rotated token for the user: id %d ok %v", reissuedID, ok)
+	}
+	if valid, _ := models.RememberTokens.Valid(id, rotated); !valid {
+		t.Error("rotated remember token is not valid")
+	}
+	if valid, _ := models.RememberTokens.Valid(id, token); valid {
+		t.Error("remember token still valid after rotating it")
+	}
+
+	// the token in the cookie from before the rotation is gone, which logs nobody in, but the
+	// cookie is left alone, since the browser may already hold the rotated one in its place
+	rr, _ = serve(m.RememberCookie(id, token))
+	if userID != 0 {
+		t.Error("rotated remember token logged the user in")
+	}
+	if c := cookie(rr, m.rememberCookieName()); c != nil {
+		t.Error("remember cookie with a token that is gone was replaced or cleared")
+	}
+	if flash != "" {
+		t.Errorf("expected no flash for a token that is gone, got %q", flash)
+	}
+
+	// a user who has been deleted is refused, even with the token the cookie was last given
+	if err := models.Users.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	rr, _ = serve(reissued)
+	if userID != 0 {
+		t.Error("deleted user logged in from their remember cookie")
+	}
+	if forget := cookie(rr, m.rememberCookieName()); forget == nil || forget.MaxAge >= 0 {
+		t.Error("remember cookie of a deleted user not cleared")
+	}
+
+	forged := m.RememberCookie(id, token)
+	forged.Value += "x"
+	rr, _ = serve(forged)
+	if userID != 0 {
+		t.Error("forged remember cookie logged a user in")
+	}
+	if forget := cookie(rr, m.rememberCookieName()); forget == nil || forget.MaxAge >= 0 {
+		t.Error("forged remember cookie not cleared")
+	}
+}
+
+// barrierBackend hands out models whose remember tokens make every caller of Valid wait for
+// the others, so that concurrent requests all check a token before any of them rotates it
+type barrierBackend struct {
+	data.Backend
+	checked *sync.WaitGroup
+}
+
+func (b barrierBackend) WithContext(ctx context.Context) data.Models {
+	m := b.Backend.WithContext(ctx)
+	m.RememberTokens = barrierRememberTokens{m.RememberTokens, b.checked}
+	m.Backend = b
+	return m
+}
+
+type barrierRememberTokens struct {
+	data.RememberTokenRepository
+	checked *sync.WaitGroup
+}
+
+func (r barrierRememberTokens) Valid(userID int, plainText string) (bool, error) {
+	valid, err := r.RememberTokenRepository.Valid(userID, plainText)
+	r.checked.Done()
+	r.checked.Wait()
+	return valid, err
+}
+
+func TestCheckRemember_SameCookie(t *testing.T) {
+	store := memory.New()
+	session := scs.New()
+
+	var checked sync.WaitGroup
+	models := data.Models{Backend: barrierBackend{store.Backend, &checked}}
+	m := Middleware{App: &celeritas.Celeritas{
+		AppName:       "myapp",
+		EncryptionKey: "abcdefghijklmnopqrstuvwxyz123456",
+		Session:       session,
+		InfoLog:       log.New(io.Discard, "", 0),
+		ErrorLog:      log.New(io.Discard, "", 0),
+	}, Models: &models}
+
+	handler := session.LoadAndSave(m.CheckRemember(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, session.GetInt(r.Context(), "userID"))
+	})))
+
+	id, err := store.Users.Insert(data.User{FirstName: "John", LastName: "Smith", Email: "twice@test.com", Password: "kettle-harbor-quilt-42", Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.RememberTokens.Issue(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remember := m.RememberCookie(id, token)
+
+	// a page and one of its assets, requested at once by a browser holding the cookie
+	responses := make([]*httptest.ResponseRecorder, 2)
+	checked.Add(len(responses))
+	var served sync.WaitGroup
+	for i := range responses {
+		served.Add(1)
+		go func(i int) {
+			defer served.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(remember)
+			responses[i] = httptest.NewRecorder()
+			handler.ServeHTTP(responses[i], req)
+		}(i)
+	}
+	served.Wait()
+
+	var loggedIn, rotated int
+	for _, rr := range responses {
+		if rr.Body.String() == strconv.Itoa(id) {
+			loggedIn++
+		}
+		for _, c := range rr.Result().Cookies() {
+			if c.Name != m.rememberCookieName() {
+				continue
+			}
+			if c.MaxAge < 0 {
+				t.Error("remember cookie cleared by the request that lost the rotation")
+			} else {
+				rotated++
+			}
+		}
+	}
+
+	if loggedIn != 1 || rotated != 1 {
+		t.Errorf("expected one request to log in and rotate the token, got %d logged in and %d rotated", loggedIn, rotated)
+	}
+
+	// a request that only checks the token once the first has rotated it finds it gone, and
+	// must neither clear the cookie the first one sent nor claim the user was logged out
+	token, err = store.RememberTokens.Issue(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remember = m.RememberCookie(id, token)
+	for i := range responses {
+		checked.Add(1)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(remember)
+		responses[i] = httptest.NewRecorder()
+		handler.ServeHTTP(responses[i], req)
+	}
+
+	if responses[0].Body.String() != strconv.Itoa(id) {
+		t.Error("first request with the cookie not logged in")
+	}
+	if responses[1].Body.String() != "0" {
+		t.Error("second request logged in with a token that was already rotated")
+	}
+	for _, c := range responses[1].Result().Cookies() {
+		if c.Name == m.rememberCookieName() {
+			t.Errorf("second request sent a remember cookie with max age %d, which could replace the rotated one", c.MaxAge)
+		}
+	}
+}
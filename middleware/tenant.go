@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"errors"
+	"myapp/data"
+	"net/http"
+)
+
+// Tenant scopes the request to the organization stored under "organizationID" in the session,
+// so models taken with data.Models.WithContext only see that organization's data. A logged in
+// user without one in the session is put in the first of their organizations by name, which
+// SwitchOrganization can change, so a member is never left unscoped. A user who belongs to no
+// organization is scoped to data.NoOrganization, where they see nobody's data. Logged out
+// requests are not scoped, and a logged in user who is not a member of the organization in
+// the session is refused.
+func (m *Middleware) Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		organizationID := m.App.Session.GetInt(ctx, "organizationID")
+		userID := m.App.Session.GetInt(ctx, "userID")
+		if userID == 0 {
+			if organizationID != 0 {
+				m.App.Session.Remove(ctx, "organizationID")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if organizationID == 0 {
+			var err error
+			organizationID, err = m.defaultTenant(r, userID)
+			if err != nil {
+				m.App.ErrorLog.Println("error finding organizations of user:", err)
+				m.App.Error500(w, r)
+				return
+			}
+
+			if organizationID != data.NoOrganization {
+				m.App.Session.Put(ctx, "organizationID", organizationID)
+			}
+			next.ServeHTTP(w, r.WithContext(data.WithTenant(ctx, organizationID)))
+			return
+		}
+
+		if _, err := m.models(r).Organizations.Membership(organizationID, userID); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				m.App.Session.Remove(ctx, "organizationID")
+				m.App.ErrorForbidden(w, r)
+				return
+			}
+
+			m.App.ErrorLog.Println("error checking organization membership:", err)
+			m.App.Error500(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(data.WithTenant(ctx, organizationID)))
+	})
+}
+
+// defaultTenant returns the id of the first of the organizations of the user by name, or
+// data.NoOrganization when they belong to none
+func (m *Middleware) defaultTenant(r *http.Request, userID int) (int, error) {
+	orgs, err := m.models(r).Organizations.ForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(orgs) == 0 {
+		return data.NoOrganization, nil
+	}
+
+	return orgs[0].ID, nil
+}
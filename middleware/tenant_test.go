@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"myapp/data"
+	"myapp/data/memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/s-petr/celeritas"
+)
+
+func TestTenant(t *testing.T) {
+	models := memory.New()
+	session := scs.New()
+	m := Middleware{App: &celeritas.Celeritas{Session: session}, Models: &models}
+
+	orgID, _ := models.Organizations.Insert(data.Organization{Name: "Acme", Slug: "acme"})
+	member, _ := models.Users.Insert(data.User{Email: "member@acme.test", Password: "kettle-harbor-quilt-42"})
+	outsider, _ := models.Users.Insert(data.User{Email: "outsider@test.com", Password: "kettle-harbor-quilt-42"})
+	if err := models.Organizations.AddMember(orgID, member, data.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	// a user in two organizations starts in the first by name
+	zebraID, _ := models.Organizations.Insert(data.Organization{Name: "Zebra", Slug: "zebra"})
+	abacusID, _ := models.Organizations.Insert(data.Organization{Name: "Abacus", Slug: "abacus"})
+	consultant, _ := models.Users.Insert(data.User{Email: "consultant@test.com", Password: "kettle-harbor-quilt-42"})
+	for _, id := range []int{zebraID, abacusID} {
+		if err := models.Organizations.AddMember(id, consultant, data.RoleMember); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tenant, stored int
+	var scoped bool
+	handler := session.LoadAndSave(m.Tenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, scoped = data.TenantFrom(r.Context())
+		stored = session.GetInt(r.Context(), "organizationID")
+	})))
+
+	serve := func(values map[string]int) int {
+		ctx, _ := session.Load(context.Background(), "")
+		for key, value := range values {
+			session.Put(ctx, key, value)
+		}
+		token, _, err := session.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tenant, stored, scoped = 0, 0, false
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := serve(map[string]int{"userID": member}); code != http.StatusOK || tenant != orgID || stored != orgID {
+		t.Errorf("member without an organization in the session: expected 200 and tenant %d kept in the session, got %d and tenant %d", orgID, code, tenant)
+	}
+
+	if code := serve(map[string]int{"userID": consultant}); code != http.StatusOK || tenant != abacusID || stored != abacusID {
+		t.Errorf("member of two organizations: expected 200 and tenant %d, got %d and tenant %d", abacusID, code, tenant)
+	}
+
+	if code := serve(map[string]int{"userID": outsider}); code != http.StatusOK || tenant != data.NoOrganization || stored != 0 {
+		t.Errorf("user in no organization: expected 200 and tenant %d, got %d and tenant %d", data.NoOrganization, code, tenant)
+	}
+
+	if code := serve(map[string]int{"userID": member, "organizationID": orgID}); code != http.StatusOK || tenant != orgID {
+		t.Errorf("member of the organization: expected 200 and tenant %d, got %d and tenant %d", orgID, code, tenant)
+	}
+
+	if code := serve(map[string]int{"userID": outsider, "organizationID": orgID}); code != http.StatusForbidden || scoped {
+		t.Errorf("user outside the organization: expected 403, got %d and tenant %d", code, tenant)
+	}
+
+	if code := serve(map[string]int{"organizationID": orgID}); code != http.StatusOK || scoped {
+		t.Errorf("logged out request: expected 200 and no tenant, got %d and tenant %d", code, tenant)
+	}
+}
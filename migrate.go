@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myapp/migrations"
+	"os"
+	"strconv"
+)
+
+// migrate runs the "myapp migrate" subcommand: up, down [n|all] or status
+func (a *application) migrate(args []string) error {
+	if a.App.DB.Pool == nil {
+		return errors.New("cannot migrate: no database is configured, set DATABASE_TYPE in .env")
+	}
+
+	m, err := migrations.New(a.App.DB.Pool, os.Getenv("DATABASE_TYPE"))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			a.App.InfoLog.Printf("applied %04d_%s", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			a.App.InfoLog.Println("database is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				statuses, err := m.Status(ctx)
+				if err != nil {
+					return err
+				}
+				steps = len(statuses)
+			} else if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := m.Down(ctx, steps)
+		for _, migration := range reverted {
+			a.App.InfoLog.Printf("rolled back %04d_%s", migration.Version, migration.Name)
+		}
+		return err
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+
+	default:
+		return errors.New("usage: myapp migrate [up|down [n|all]|status]")
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS users;
+
+DROP FUNCTION IF EXISTS trigger_set_timestamp();
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id int NOT NULL,
+    first_name varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    token_hash varbinary(32) NOT NULL UNIQUE,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    expiry datetime NOT NULL,
+    CONSTRAINT tokens_user_id_fk FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    id SERIAL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    first_name character varying(255) NOT NULL,
+    email character varying(255) NOT NULL,
+    token_hash bytea NOT NULL UNIQUE,
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    updated_at timestamp without time zone NOT NULL DEFAULT now(),
+    expiry timestamp without time zone NOT NULL
+);
+
+-- a tokens table made before migrations, by create-test-tables.sql, also kept the plain
+-- text of every token and did not make the hashes unique
+ALTER TABLE tokens DROP COLUMN IF EXISTS token;
+CREATE UNIQUE INDEX IF NOT EXISTS tokens_token_hash_key ON tokens (token_hash);
+
+DROP TRIGGER IF EXISTS set_timestamp ON tokens;
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON tokens
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    first_name varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    token_hash blob NOT NULL UNIQUE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expiry timestamp NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS tokens_set_timestamp
+AFTER UPDATE ON tokens
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE tokens SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
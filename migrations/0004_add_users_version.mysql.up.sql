@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN version int NOT NULL DEFAULT 1;
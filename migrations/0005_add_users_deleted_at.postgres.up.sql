@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN deleted_at timestamp without time zone NULL;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
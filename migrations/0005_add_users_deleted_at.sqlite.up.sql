@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN deleted_at timestamp NULL;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
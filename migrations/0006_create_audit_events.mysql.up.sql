@@ -0,0 +1,13 @@
+CREATE TABLE audit_events (
+    id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    actor_id int NOT NULL DEFAULT 0,
+    actor_via varchar(20) NOT NULL,
+    actor_ip varchar(45) NOT NULL DEFAULT '',
+    action varchar(20) NOT NULL,
+    entity varchar(50) NOT NULL,
+    entity_id int NOT NULL,
+    changes text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX audit_events_entity_idx (entity, entity_id),
+    INDEX audit_events_actor_id_idx (actor_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
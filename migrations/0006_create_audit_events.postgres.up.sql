@@ -0,0 +1,14 @@
+CREATE TABLE audit_events (
+    id SERIAL PRIMARY KEY,
+    actor_id integer NOT NULL DEFAULT 0,
+    actor_via character varying(20) NOT NULL,
+    actor_ip character varying(45) NOT NULL DEFAULT '',
+    action character varying(20) NOT NULL,
+    entity character varying(50) NOT NULL,
+    entity_id integer NOT NULL,
+    changes text NOT NULL,
+    created_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_events_entity_idx ON audit_events (entity, entity_id);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
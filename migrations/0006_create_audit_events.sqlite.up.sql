@@ -0,0 +1,14 @@
+CREATE TABLE audit_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    actor_id integer NOT NULL DEFAULT 0,
+    actor_via varchar(20) NOT NULL,
+    actor_ip varchar(45) NOT NULL DEFAULT '',
+    action varchar(20) NOT NULL,
+    entity varchar(50) NOT NULL,
+    entity_id integer NOT NULL,
+    changes text NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_entity_idx ON audit_events (entity, entity_id);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
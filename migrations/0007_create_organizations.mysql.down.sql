@@ -0,0 +1,6 @@
+DROP INDEX audit_events_organization_id_idx ON audit_events;
+
+ALTER TABLE audit_events DROP COLUMN organization_id;
+
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
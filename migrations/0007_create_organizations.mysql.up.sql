@@ -0,0 +1,23 @@
+CREATE TABLE organizations (
+    id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    slug varchar(100) NOT NULL UNIQUE,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE memberships (
+    id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    organization_id int NOT NULL,
+    user_id int NOT NULL,
+    role varchar(20) NOT NULL DEFAULT 'member',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY memberships_organization_user (organization_id, user_id),
+    INDEX memberships_user_id_idx (user_id),
+    CONSTRAINT memberships_organization_id_fk FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT memberships_user_id_fk FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE audit_events ADD COLUMN organization_id int NOT NULL DEFAULT 0;
+
+CREATE INDEX audit_events_organization_id_idx ON audit_events (organization_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS audit_events_organization_id_idx;
+
+ALTER TABLE audit_events DROP COLUMN organization_id;
+
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,27 @@
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    slug character varying(100) NOT NULL UNIQUE,
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    updated_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON organizations
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
+
+CREATE TABLE memberships (
+    id SERIAL PRIMARY KEY,
+    organization_id integer NOT NULL REFERENCES organizations(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    role character varying(20) NOT NULL DEFAULT 'member',
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    UNIQUE (organization_id, user_id)
+);
+
+CREATE INDEX memberships_user_id_idx ON memberships (user_id);
+
+ALTER TABLE audit_events ADD COLUMN organization_id integer NOT NULL DEFAULT 0;
+
+CREATE INDEX audit_events_organization_id_idx ON audit_events (organization_id);
@@ -0,0 +1,29 @@
+CREATE TABLE organizations (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    slug varchar(100) NOT NULL UNIQUE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER organizations_set_timestamp
+AFTER UPDATE ON organizations
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE organizations SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TABLE memberships (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    organization_id integer NOT NULL REFERENCES organizations(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    role varchar(20) NOT NULL DEFAULT 'member',
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (organization_id, user_id)
+);
+
+CREATE INDEX memberships_user_id_idx ON memberships (user_id);
+
+ALTER TABLE audit_events ADD COLUMN organization_id integer NOT NULL DEFAULT 0;
+
+CREATE INDEX audit_events_organization_id_idx ON audit_events (organization_id);
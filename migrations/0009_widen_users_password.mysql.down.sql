@@ -0,0 +1,12 @@
+-- hashes made by argon2id do not fit in 60 characters, so the column is only narrowed once
+-- no user has one; reset their passwords, or leave this migration applied, to roll back.
+-- MySQL has no way to raise an error outside a stored program, and would cut the hashes
+-- short rather than refuse outside strict mode, so a second row with the same key in a
+-- temporary table stops the rollback when a longer hash is found.
+DROP TEMPORARY TABLE IF EXISTS users_password_longer_than_60;
+CREATE TEMPORARY TABLE users_password_longer_than_60 (found int NOT NULL PRIMARY KEY);
+INSERT INTO users_password_longer_than_60 (found) VALUES (1);
+INSERT INTO users_password_longer_than_60 (found) SELECT 1 FROM users WHERE length(password) > 60 LIMIT 1;
+DROP TEMPORARY TABLE users_password_longer_than_60;
+
+ALTER TABLE users MODIFY password varchar(60) NOT NULL;
@@ -0,0 +1,11 @@
+-- hashes made by argon2id do not fit in 60 characters, so the column is only narrowed once
+-- no user has one; reset their passwords, or leave this migration applied, to roll back
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE length(password) > 60) THEN
+        RAISE EXCEPTION 'users.password holds hashes longer than 60 characters, which the narrower column cannot store';
+    END IF;
+END
+$$;
+
+ALTER TABLE users ALTER COLUMN password TYPE character varying(60);
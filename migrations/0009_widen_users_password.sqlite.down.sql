@@ -0,0 +1,7 @@
+-- sqlite does not enforce varchar lengths, so there is no column to narrow, but as with the
+-- other databases the rollback is refused while a user has a hash longer than 60 characters,
+-- such as one made by argon2id: a second row with the same key in a temporary table stops it
+CREATE TEMP TABLE users_password_longer_than_60 (found integer NOT NULL PRIMARY KEY);
+INSERT INTO users_password_longer_than_60 (found) VALUES (1);
+INSERT INTO users_password_longer_than_60 (found) SELECT 1 FROM users WHERE length(password) > 60 LIMIT 1;
+DROP TABLE users_password_longer_than_60;
@@ -0,0 +1,2 @@
+-- sqlite does not enforce varchar lengths, so the password column already holds any hash
+SELECT 1;
@@ -0,0 +1,9 @@
+CREATE TABLE email_verifications (
+    id SERIAL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expiry timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX email_verifications_user_id_idx ON email_verifications (user_id);
@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN scopes varchar(1000) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN scopes character varying(1000) NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+CREATE TABLE user_identities (
+    id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id int NOT NULL,
+    provider varchar(100) NOT NULL,
+    provider_user_id varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT user_identities_provider_user_unique UNIQUE (provider, provider_user_id),
+    CONSTRAINT user_identities_user_id_fk FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
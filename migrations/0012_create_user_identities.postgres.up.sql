@@ -0,0 +1,11 @@
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    provider character varying(100) NOT NULL,
+    provider_user_id character varying(255) NOT NULL,
+    email character varying(255) NOT NULL,
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    UNIQUE (provider, provider_user_id)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE user_identities (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    provider varchar(100) NOT NULL,
+    provider_user_id varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, provider_user_id)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
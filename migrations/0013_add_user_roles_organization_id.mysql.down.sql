@@ -0,0 +1,8 @@
+-- assignments made within an organization are dropped rather than widened to all of them
+DELETE FROM user_roles WHERE organization_id <> 0;
+
+ALTER TABLE user_roles
+    DROP INDEX user_roles_organization_id_idx,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (user_id, role_id),
+    DROP COLUMN organization_id;
@@ -0,0 +1,14 @@
+-- a role is assigned within an organization; 0 holds the assignments made outside of any,
+-- which only apply outside of one. Existing assignments carry over into every organization
+-- their user is in.
+ALTER TABLE user_roles
+    ADD COLUMN organization_id int NOT NULL DEFAULT 0 AFTER role_id,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (user_id, role_id, organization_id),
+    ADD INDEX user_roles_organization_id_idx (organization_id);
+
+INSERT INTO user_roles (user_id, role_id, organization_id, created_at)
+SELECT user_roles.user_id, user_roles.role_id, memberships.organization_id, user_roles.created_at
+FROM user_roles
+JOIN memberships ON memberships.user_id = user_roles.user_id
+WHERE user_roles.organization_id = 0;
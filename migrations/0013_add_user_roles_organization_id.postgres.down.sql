@@ -0,0 +1,6 @@
+-- assignments made within an organization are dropped rather than widened to all of them
+DROP INDEX IF EXISTS user_roles_organization_id_idx;
+DELETE FROM user_roles WHERE organization_id <> 0;
+ALTER TABLE user_roles DROP CONSTRAINT user_roles_pkey;
+ALTER TABLE user_roles ADD PRIMARY KEY (user_id, role_id);
+ALTER TABLE user_roles DROP COLUMN organization_id;
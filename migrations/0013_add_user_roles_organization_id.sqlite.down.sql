@@ -0,0 +1,15 @@
+-- assignments made within an organization are dropped rather than widened to all of them
+CREATE TABLE user_roles_old (
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    role_id integer NOT NULL REFERENCES roles(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO user_roles_old (user_id, role_id, created_at)
+SELECT user_id, role_id, created_at FROM user_roles WHERE organization_id = 0;
+
+DROP TABLE user_roles;
+ALTER TABLE user_roles_old RENAME TO user_roles;
+
+CREATE INDEX user_roles_role_id_idx ON user_roles (role_id);
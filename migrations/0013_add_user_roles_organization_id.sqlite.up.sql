@@ -0,0 +1,24 @@
+-- a role is assigned within an organization; 0 holds the assignments made outside of any,
+-- which only apply outside of one. Existing assignments carry over into every organization
+-- their user is in. sqlite cannot change a primary key, so the table is rebuilt.
+CREATE TABLE user_roles_new (
+    user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    role_id integer NOT NULL REFERENCES roles(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    organization_id integer NOT NULL DEFAULT 0,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id, organization_id)
+);
+
+INSERT INTO user_roles_new (user_id, role_id, organization_id, created_at)
+SELECT user_id, role_id, 0, created_at FROM user_roles;
+
+INSERT INTO user_roles_new (user_id, role_id, organization_id, created_at)
+SELECT user_roles.user_id, user_roles.role_id, memberships.organization_id, user_roles.created_at
+FROM user_roles
+JOIN memberships ON memberships.user_id = user_roles.user_id;
+
+DROP TABLE user_roles;
+ALTER TABLE user_roles_new RENAME TO user_roles;
+
+CREATE INDEX user_roles_role_id_idx ON user_roles (role_id);
+CREATE INDEX user_roles_organization_id_idx ON user_roles (organization_id);
@@ -0,0 +1 @@
+ALTER TABLE email_verifications ADD COLUMN password_hash character varying(255) NOT NULL DEFAULT '';
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+)
+
+// lockName identifies the migration lock; the numeric form is used for postgres advisory locks
+const (
+	lockName    = "myapp_schema_migrations"
+	lockID      = 72710040
+	lockTimeout = 60
+)
+
+// dialect holds the SQL that differs between the supported databases. Drivers with
+// multiStatements set run a whole migration file in one call instead of statement by statement.
+type dialect struct {
+	name            string
+	createTable     string
+	insertVersion   string
+	deleteVersion   string
+	multiStatements bool
+	lock            func(ctx context.Context, conn *sql.Conn) error
+	unlock          func(ctx context.Context, conn *sql.Conn) error
+}
+
+var postgres = dialect{
+	name: "postgres",
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    applied_at timestamp without time zone NOT NULL DEFAULT now()
+)`,
+	insertVersion: "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+	deleteVersion: "DELETE FROM schema_migrations WHERE version = $1",
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID)
+		return err
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockID)
+		return err
+	},
+}
+
+var mysql = dialect{
+	name: "mysql",
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint NOT NULL PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    applied_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	insertVersion: "INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+	deleteVersion: "DELETE FROM schema_migrations WHERE version = ?",
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&acquired); err != nil {
+			return err
+		}
+		if !acquired.Valid || acquired.Int64 != 1 {
+			return ErrLockTimeout
+		}
+		return nil
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		return err
+	},
+}
+
+// sqlite has no server wide locks; it is meant for local development and tests where a
+// single process owns the database file, so locking is a no-op
+var sqlite = dialect{
+	name: "sqlite",
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version integer PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+	insertVersion:   "INSERT INTO schema_migrations (version, name) VALUES (?, ?)",
+	deleteVersion:   "DELETE FROM schema_migrations WHERE version = ?",
+	multiStatements: true,
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		return nil
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		return nil
+	},
+}
+
+func dialectFor(databaseType string) (dialect, error) {
+	switch databaseType {
+	case "postgres", "postgresql":
+		return postgres, nil
+	case "mysql", "mariadb":
+		return mysql, nil
+	case "sqlite", "sqlite3":
+		return sqlite, nil
+	default:
+		return dialect{}, ErrUnknownDialect
+	}
+}
@@ -0,0 +1,331 @@
+// Package migrations holds the versioned database schema of the application and applies it.
+// Every migration is a pair of files named <version>_<name>.<dialect>.<up|down>.sql which
+// are embedded into the binary, so a deployed executable can always migrate its own database.
+// The first migrations only create the tables that are missing, so a database made before
+// migrations existed, from the old create-test-tables.sql, is adopted by migrating it up.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+var (
+	ErrUnknownDialect = errors.New("migrations: unsupported database type")
+	ErrLockTimeout    = errors.New("migrations: timed out waiting for the migration lock")
+	ErrInvalidSteps   = errors.New("migrations: number of steps must be at least 1")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.([a-z]+)\.(up|down)\.sql$`)
+
+// Migration is a single schema change with the statements to apply and revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a known migration and whether it has been applied to the database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back the embedded migrations for one database
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// New returns a Migrator for db. databaseType takes the same values as the DATABASE_TYPE
+// environment variable: postgres, postgresql, mysql, mariadb, sqlite or sqlite3.
+func New(db *sql.DB, databaseType string) (*Migrator, error) {
+	d, err := dialectFor(databaseType)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := load(files, d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies every pending migration in version order and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the given number of most recently applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, ErrInvalidSteps
+	}
+
+	var reverted []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status lists every known migration in version order along with when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			appliedAt, ok := done[migration.Version]
+			statuses = append(statuses, Status{
+				Migration: migration,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withLock runs fn on a single connection holding the database wide migration lock, so that
+// two instances of the application starting at the same time never migrate concurrently
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.dialect.lock(ctx, conn); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := m.dialect.unlock(context.Background(), conn); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.createTable); err != nil {
+		return fmt.Errorf("migrations: creating schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+
+	return done, rows.Err()
+}
+
+// run executes one direction of a migration and records it in schema_migrations within a
+// single transaction. MySQL commits DDL implicitly, so there a failure part way through a
+// migration can leave earlier statements of that migration applied.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	script, record, args := migration.Down, m.dialect.deleteVersion, []any{migration.Version}
+	if up {
+		script, record, args = migration.Up, m.dialect.insertVersion, []any{migration.Version, migration.Name}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{script}
+	if !m.dialect.multiStatements {
+		statements = splitStatements(script)
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrations: %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// load reads the up and down scripts for one dialect from fsys, sorted by version
+func load(fsys fs.FS, dialectName string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		parts := fileName.FindStringSubmatch(entry.Name())
+		if parts == nil || parts[3] != dialectName {
+			continue
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = migration
+		} else if migration.Name != parts[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, migration.Name, parts[2])
+		}
+
+		if parts[4] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s.%s needs both an up and a down file", migration.Version, migration.Name, dialectName)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// splitStatements splits a script on semicolons, ignoring those inside quoted strings,
+// dollar quoted function bodies and comments
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	inSingle, inDollar, inComment := false, false, false
+
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+				current.WriteByte(c)
+			}
+			continue
+		case !inSingle && !inDollar && c == '-' && i+1 < len(script) && script[i+1] == '-':
+			inComment = true
+			continue
+		case !inSingle && c == '$' && i+1 < len(script) && script[i+1] == '$':
+			inDollar = !inDollar
+			current.WriteString("$$")
+			i++
+			continue
+		case !inDollar && c == '\'':
+			inSingle = !inSingle
+		case !inSingle && !inDollar && c == ';':
+			flush()
+			continue
+		}
+
+		current.WriteByte(c)
+	}
+	flush()
+
+	return statements
+}
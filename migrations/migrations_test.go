@@ -0,0 +1,154 @@
+package migrations
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	for _, databaseType := range []string{"postgres", "postgresql", "mysql", "mariadb", "sqlite"} {
+		d, err := dialectFor(databaseType)
+		if err != nil {
+			t.Fatal(databaseType, err)
+		}
+
+		migrations, err := load(files, d.name)
+		if err != nil {
+			t.Fatalf("%s: error loading embedded migrations: %s", databaseType, err)
+		}
+
+		if len(migrations) == 0 {
+			t.Fatalf("%s: no migrations loaded", databaseType)
+		}
+
+		for i, migration := range migrations {
+			if i > 0 && migration.Version <= migrations[i-1].Version {
+				t.Errorf("%s: migrations out of order at %d", databaseType, migration.Version)
+			}
+		}
+	}
+
+	if _, err := dialectFor("oracle"); err != ErrUnknownDialect {
+		t.Error("unknown database type accepted")
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_one.postgres.up.sql":   {Data: []byte("up")},
+		"0001_one.postgres.down.sql": {Data: []byte("down")},
+		"0002_two.postgres.up.sql":   {Data: []byte("up")},
+		"0002_two.mysql.down.sql":    {Data: []byte("down")},
+		"README.md":                  {Data: []byte("ignored")},
+	}
+
+	if _, err := load(fsys, "postgres"); err == nil {
+		t.Error("migration without a down file accepted")
+	}
+
+	fsys["0002_two.postgres.down.sql"] = &fstest.MapFile{Data: []byte("down")}
+	migrations, err := load(fsys, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "one" || migrations[1].Name != "two" {
+		t.Errorf("wrong migrations loaded: %+v", migrations)
+	}
+
+	fsys["0002_other.postgres.up.sql"] = &fstest.MapFile{Data: []byte("up")}
+	if _, err := load(fsys, "postgres"); err == nil {
+		t.Error("duplicate version accepted")
+	}
+}
+
+var splitData = []struct {
+	name     string
+	script   string
+	expected int
+}{
+	{"single", "CREATE TABLE a (id int);", 1},
+	{"no_trailing_semicolon", "CREATE TABLE a (id int)", 1},
+	{"multiple", "CREATE TABLE a (id int);\n\nCREATE TABLE b (id int);\n", 2},
+	{"quoted", "INSERT INTO a VALUES ('x;y');", 1},
+	{"comment", "-- drop; everything\nCREATE TABLE a (id int);", 1},
+	{"function", "CREATE FUNCTION f() RETURNS TRIGGER AS $$\nBEGIN\n  NEW.a = 1;\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\n\nCREATE TABLE a (id int);", 2},
+	{"empty", "\n  \n", 0},
+	{"do_block", "DO $$\nBEGIN\n  IF true THEN\n    RAISE EXCEPTION 'no; never';\n  END IF;\nEND\n$$;\n\nALTER TABLE a DROP COLUMN b;", 2},
+}
+
+func TestSplitStatements(t *testing.T) {
+	for _, tt := range splitData {
+		statements := splitStatements(tt.script)
+		if len(statements) != tt.expected {
+			t.Errorf("%s: expected %d statements, got %d: %q", tt.name, tt.expected, len(statements), statements)
+		}
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := &Migrator{
+		db:      db,
+		dialect: postgres,
+		migrations: []Migration{
+			{Version: 1, Name: "one", Up: "CREATE TABLE one (id int)", Down: "DROP TABLE one"},
+			{Version: 2, Name: "two", Up: "CREATE TABLE two (id int)", Down: "DROP TABLE two"},
+		},
+	}
+
+	q := regexp.QuoteMeta
+
+	mock.ExpectExec(q("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(q("CREATE TABLE two (id int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q("INSERT INTO schema_migrations")).WithArgs(2, "two").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(q("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := m.Up(context.Background())
+	if err != nil {
+		t.Fatal("error applying migrations:", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Errorf("expected only migration 2 to be applied, got %+v", applied)
+	}
+
+	mock.ExpectExec(q("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(q("DROP TABLE two")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q("DELETE FROM schema_migrations")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(q("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reverted, err := m.Down(context.Background(), 1)
+	if err != nil {
+		t.Fatal("error rolling back migrations:", err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 2 {
+		t.Errorf("expected only migration 2 to be rolled back, got %+v", reverted)
+	}
+
+	if _, err := m.Down(context.Background(), 0); err != ErrInvalidSteps {
+		t.Error("rolling back zero steps, expected ErrInvalidSteps, got", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// userRetentionDays is how long soft deleted users can be restored before purge-users removes them
+const userRetentionDays = 30
+
+// purgeUsers runs the "myapp purge-users [days]" subcommand, permanently removing the users
+// soft deleted more than the given number of days ago. Run it daily from cron.
+func (a *application) purgeUsers(args []string) error {
+	if a.App.DB.Pool == nil {
+		return errors.New("cannot purge users: no database is configured, set DATABASE_TYPE in .env")
+	}
+
+	days := userRetentionDays
+	if len(args) > 0 {
+		var err error
+		if days, err = strconv.Atoi(args[0]); err != nil || days < 0 {
+			return errors.New("usage: myapp purge-users [days]")
+		}
+	}
+
+	purged, err := a.Models.Users.Purge(time.Duration(days) * 24 * time.Hour)
+	if err != nil {
+		return err
+	}
+
+	a.App.InfoLog.Printf("purged %d users deleted more than %d days ago", purged, days)
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// replicaConfig is the replica entry of config/database.yml
+type replicaConfig struct {
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	SSLMode  string `yaml:"sslmode"`
+	Pool     int    `yaml:"pool"`
+}
+
+// openReplica opens the read replica configured in config/database.yml. It returns nil
+// when the application has no database or no replica is configured.
+func (a *application) openReplica() (*sql.DB, error) {
+	if a.App.DB.Pool == nil {
+		return nil, nil
+	}
+
+	contents, err := os.ReadFile(filepath.Join(a.App.RootPath, "config", "database.yml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]replicaConfig
+	if err := yaml.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("reading config/database.yml: %w", err)
+	}
+
+	replica, ok := config["replica"]
+	if !ok || replica.Database == "" {
+		return nil, nil
+	}
+
+	driverName, dsn, err := replicaDSN(os.Getenv("DATABASE_TYPE"), replica)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaPool, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if replica.Pool > 0 {
+		replicaPool.SetMaxOpenConns(replica.Pool)
+	}
+
+	if err := replicaPool.Ping(); err != nil {
+		replicaPool.Close()
+		return nil, fmt.Errorf("connecting to read replica: %w", err)
+	}
+
+	return replicaPool, nil
+}
+
+// replicaDSN returns the driver name and data source name for connecting to replica with the
+// driver for databaseType. A blank sslmode turns TLS off, which each driver spells differently.
+func replicaDSN(databaseType string, replica replicaConfig) (string, string, error) {
+	switch databaseType {
+	case "postgres", "postgresql":
+		if replica.SSLMode == "" {
+			replica.SSLMode = "disable"
+		}
+		dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s timezone=UTC connect_timeout=5",
+			replica.Host, replica.Port, replica.User, replica.Database, replica.SSLMode)
+		if replica.Password != "" {
+			dsn = fmt.Sprintf("%s password=%s", dsn, replica.Password)
+		}
+		return "pgx", dsn, nil
+	case "mysql", "mariadb":
+		if replica.SSLMode == "" {
+			replica.SSLMode = "false"
+		}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?collation=utf8_unicode_ci&timeout=5s&parseTime=true&tls=%s&readTimeout=5s",
+			replica.User, replica.Password, replica.Host, replica.Port, replica.Database, replica.SSLMode)
+		return "mysql", dsn, nil
+	default:
+		return "", "", fmt.Errorf("read replicas are not supported for DATABASE_TYPE %q", databaseType)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+)
+
+func TestReplicaDSN(t *testing.T) {
+	replica := replicaConfig{Database: "myapp", User: "reader", Password: "secret", Host: "replica.internal", Port: "5433"}
+
+	for _, databaseType := range []string{"mysql", "mariadb"} {
+		for sslMode, tls := range map[string]string{"": "false", "skip-verify": "skip-verify"} {
+			replica.SSLMode = sslMode
+
+			driverName, dsn, err := replicaDSN(databaseType, replica)
+			if err != nil || driverName != "mysql" {
+				t.Fatalf("%s: expected the mysql driver, got %q: %v", databaseType, driverName, err)
+			}
+
+			config, err := mysql.ParseDSN(dsn)
+			if err != nil {
+				t.Fatalf("%s with sslmode %q: generated DSN rejected by the driver: %v", databaseType, sslMode, err)
+			}
+			if config.TLSConfig != tls || config.DBName != "myapp" || config.Addr != "replica.internal:5433" || config.Passwd != "secret" {
+				t.Errorf("%s with sslmode %q: wrong connection settings: %+v", databaseType, sslMode, config)
+			}
+		}
+	}
+
+	for _, databaseType := range []string{"postgres", "postgresql"} {
+		for sslMode, tls := range map[string]bool{"": false, "require": true} {
+			replica.SSLMode = sslMode
+
+			driverName, dsn, err := replicaDSN(databaseType, replica)
+			if err != nil || driverName != "pgx" {
+				t.Fatalf("%s: expected the pgx driver, got %q: %v", databaseType, driverName, err)
+			}
+
+			config, err := pgconn.ParseConfig(dsn)
+			if err != nil {
+				t.Fatalf("%s with sslmode %q: generated DSN rejected by the driver: %v", databaseType, sslMode, err)
+			}
+			if (config.TLSConfig != nil) != tls || config.Database != "myapp" || config.Host != "replica.internal" || config.Port != 5433 || config.Password != "secret" {
+				t.Errorf("%s with sslmode %q: wrong connection settings: %+v", databaseType, sslMode, config)
+			}
+		}
+	}
+
+	if _, _, err := replicaDSN("sqlite", replica); err == nil {
+		t.Error("expected replicas to be refused for sqlite")
+	}
+}
@@ -6,18 +6,29 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
+// ApiRoutes returns the routes of the API. They are mounted at /api, which their patterns
+// leave out. Routes outside a group are public; a group using AuthToken needs an API token,
+// with whatever scopes it asks for.
 func (a *application) ApiRoutes() http.Handler {
 	r := chi.NewRouter()
 
-	r.Route("/api", func(mux chi.Router) {
-		r.Get("/test-api", func(w http.ResponseWriter, r *http.Request) {
-			var payload struct {
-				Content string `json:"content"`
-			}
-			payload.Content = "Hello world"
+	r.Get("/test-api", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Content string `json:"content"`
+		}
+		payload.Content = "Hello world"
 
-			a.App.WriteJSON(w, http.StatusOK, payload)
-		})
+		a.App.WriteJSON(w, http.StatusOK, payload)
+	})
+
+	r.Group(func(mux chi.Router) {
+		mux.Use(a.Middleware.AuthToken())
+		mux.Get("/me", a.Handlers.ApiMe)
+	})
+
+	r.Group(func(mux chi.Router) {
+		mux.Use(a.Middleware.AuthToken("users:read"))
+		mux.With(a.Middleware.RequirePermission("users.read")).Get("/users", a.Handlers.ApiUsers)
 	})
 
 	return r
@@ -9,10 +9,33 @@ import (
 func (a *application) routes() *chi.Mux {
 
 	// middleware
+	a.use(a.Middleware.Global()...)
 
 	// routes
 	a.get("/", a.Handlers.Home)
 
+	a.App.Routes.Group(func(r chi.Router) {
+		r.Use(a.Middleware.Guest)
+		r.Get("/users/login", a.Handlers.Login)
+		r.Post("/users/login", a.Handlers.PostLogin)
+		r.Get("/users/register", a.Handlers.Register)
+		r.Post("/users/register", a.Handlers.PostRegister)
+		r.Get("/auth/{provider}", a.Handlers.SocialLogin)
+		r.Get("/auth/{provider}/callback", a.Handlers.SocialCallback)
+	})
+	a.App.Routes.Group(func(r chi.Router) {
+		r.Use(a.Middleware.Auth)
+		r.Post("/organizations/switch", a.Handlers.SwitchOrganization)
+	})
+	a.post("/users/logout", a.Handlers.Logout)
+	a.get("/users/verify", a.Handlers.VerifyEmail)
+	a.get("/users/verify/resend", a.Handlers.ResendVerification)
+	a.post("/users/verify/resend", a.Handlers.PostResendVerification)
+	a.get("/users/forgot-password", a.Handlers.ForgotPassword)
+	a.post("/users/forgot-password", a.Handlers.PostForgotPassword)
+	a.get("/users/reset-password", a.Handlers.ResetPassword)
+	a.post("/users/reset-password", a.Handlers.PostResetPassword)
+
 	// static routes
 	fileServer := http.FileServer(http.Dir("./public"))
 	a.App.Routes.Handle("/public/*", http.StripPrefix("/public", fileServer))
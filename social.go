@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// useSocialProviders registers the OpenID Connect providers named in SOCIAL_PROVIDERS, a comma
+// separated list such as "google,gitlab". Each one is configured by <NAME>_KEY, <NAME>_SECRET
+// and <NAME>_DISCOVERY_URL, and users are sent back to /auth/<name>/callback.
+func (a *application) useSocialProviders() error {
+	var providers []goth.Provider
+
+	for _, name := range strings.Split(os.Getenv("SOCIAL_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := strings.ToUpper(name) + "_"
+		key, secret, discoveryURL := os.Getenv(prefix+"KEY"), os.Getenv(prefix+"SECRET"), os.Getenv(prefix+"DISCOVERY_URL")
+		if key == "" || secret == "" || discoveryURL == "" {
+			return fmt.Errorf("social provider %s needs %sKEY, %sSECRET and %sDISCOVERY_URL", name, prefix, prefix, prefix)
+		}
+
+		callbackURL := strings.TrimRight(a.App.Server.URL, "/") + "/auth/" + name + "/callback"
+
+		provider, err := openidConnect.New(key, secret, callbackURL, discoveryURL, "email", "profile")
+		if err != nil {
+			return fmt.Errorf("social provider %s: %w", name, err)
+		}
+		provider.SetName(name)
+
+		providers = append(providers, provider)
+	}
+
+	goth.UseProviders(providers...)
+
+	return nil
+}